@@ -2,122 +2,957 @@
 
 import subprocess
 import os
+import posixpath
+import re
+import shlex
+import shutil
+import signal
+import socket
 import sys
 import hashlib
+import io
+import ipaddress
 import argparse
+import difflib
+import fnmatch
+import getpass
+import glob
 import json
+import logging
+import logging.handlers
+import tarfile
 import tempfile
+import threading
 import asyncio
+import concurrent.futures
+import contextlib
+import datetime
+import functools
+import time
+import urllib.error
+import urllib.parse
+import urllib.request
 from pathlib import Path
 
 # Global configuration
 IMAGE_NAME = "vibecon:latest"
 
 
+# ============================================================================
+# Logging
+# ============================================================================
+
+# Every command vibecon runs is logged here when logging is enabled
+LOG = logging.getLogger("vibecon")
+# Until setup_logging() enables it, keep warnings from reaching logging's stderr fallback
+LOG.addHandler(logging.NullHandler())
+LOG_DIR = Path.home() / ".local" / "state" / "vibecon" / "logs"
+LOG_FILE = LOG_DIR / "vibecon.log"
+LOG_MAX_BYTES = 5 * 1024 * 1024
+LOG_BACKUPS = 5
+
+
+def setup_logging(debug=False):
+    """Log to LOG_FILE (rotated) if enabled, and trace to stderr with debug.
+
+    File logging is on with --debug, VIBECON_DEBUG=1 or "log": true in the
+    global config. Safe to call again, e.g. once --debug is parsed.
+    """
+    debug = debug or bool(os.environ.get("VIBECON_DEBUG"))
+    to_file = debug
+    if not to_file:
+        # Read the raw file: an invalid global config is reported later, by the command itself
+        try:
+            to_file = read_config_file(global_config_path()).get("log", False) is True
+        except (OSError, ValueError, AttributeError):
+            to_file = False
+    if not to_file:
+        return
+    LOG.handlers.clear()
+    LOG.setLevel(logging.DEBUG)
+    LOG_DIR.mkdir(parents=True, exist_ok=True)
+    handler = logging.handlers.RotatingFileHandler(LOG_FILE, maxBytes=LOG_MAX_BYTES, backupCount=LOG_BACKUPS)
+    handler.setFormatter(logging.Formatter("%(asctime)s %(process)d %(levelname)s %(message)s"))
+    LOG.addHandler(handler)
+    if debug:
+        console = logging.StreamHandler(sys.stderr)
+        console.setFormatter(logging.Formatter("[debug] %(message)s"))
+        LOG.addHandler(console)
+    LOG.info("vibecon %s (cwd %s)", shlex.join(sys.argv[1:]), os.getcwd())
+
+
+# user:password@ in URLs, e.g. proxy settings
+URL_CREDENTIALS_PATTERN = re.compile(r"(\w+://)[^/\s@]+@")
+
+
+def redact_url_credentials(text):
+    """Hide the user info of URLs in text"""
+    return URL_CREDENTIALS_PATTERN.sub(r"\1***@", text)
+
+
+def format_command(args):
+    """Format a command for the log, hiding values that may be secrets: -e and
+    --build-arg values, 'security -w' passwords and credentials in URLs"""
+    if isinstance(args, str):
+        return redact_url_credentials(args)
+    shown = []
+    for index, arg in enumerate(args):
+        arg = str(arg)
+        previous = str(args[index - 1]) if index > 0 else None
+        if previous in ("-e", "--env", "--build-arg") and "=" in arg:
+            arg = arg.split("=", 1)[0] + "=***"
+        elif previous == "-w" and str(args[0]) == "security":
+            arg = "***"
+        shown.append(redact_url_credentials(arg))
+    return shlex.join(shown)
+
+
+def run_command(args, **kwargs):
+    """subprocess.run, logging the command, its duration, exit code and captured stderr"""
+    if not LOG.isEnabledFor(logging.DEBUG):
+        return subprocess.run(args, **kwargs)
+    start = time.monotonic()
+    try:
+        result = subprocess.run(args, **kwargs)
+    except (OSError, subprocess.SubprocessError) as e:
+        LOG.debug("%s -> %s after %.2fs", format_command(args), e, time.monotonic() - start)
+        raise
+    LOG.debug("%s -> %d in %.2fs", format_command(args), result.returncode, time.monotonic() - start)
+    stderr = result.stderr.decode(errors="replace") if isinstance(result.stderr, bytes) else result.stderr
+    if stderr and stderr.strip():
+        LOG.debug("  stderr: %s", stderr.strip())
+    return result
+
+
 # ============================================================================
 # Config file support
 # ============================================================================
 
+# ${VAR} or ${VAR:-default}; $${ escapes a literal ${
+ENV_VAR_PATTERN = re.compile(r"\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}")
+
+# Config keys holding container paths, where ~ means the container user's home
+CONTAINER_PATH_KEYS = ("target", "root")
+CONTAINER_HOME = "/home/node"
+
+
+def expand_string(value, key=None):
+    """Expand ${VAR}, ${VAR:-default} and a leading ~ in a config string"""
+    def replace(match):
+        if match.group(0) == "$${":
+            return "${"
+        name, default = match.group(1), match.group(2)
+        if name in os.environ:
+            return os.environ[name]
+        if default is not None:
+            return default
+        warn(f"config references unset environment variable '{name}'")
+        return ""
+
+    value = ENV_VAR_PATTERN.sub(replace, value)
+    if value == "~" or value.startswith("~/"):
+        home = CONTAINER_HOME if key in CONTAINER_PATH_KEYS else str(Path.home())
+        value = home + value[1:]
+    return value
+
+
+def expand_config(value, key=None):
+    """Recursively expand environment variables and ~ in all config strings"""
+    if isinstance(value, dict):
+        return {k: expand_config(v, k) for k, v in value.items()}
+    if isinstance(value, list):
+        return [expand_config(v, key) for v in value]
+    if isinstance(value, str):
+        return expand_string(value, key)
+    return value
+
+
+@functools.lru_cache(maxsize=None)
+def load_schema():
+    """Load the JSON Schema shipped next to vibecon.py, or None if missing"""
+    schema_path = Path(__file__).resolve().parent / "vibecon.schema.json"
+    if not schema_path.exists():
+        return None
+    with open(schema_path) as f:
+        return json.load(f)
+
+
+JSON_TYPES = {
+    "string": str,
+    "integer": int,
+    "number": (int, float),
+    "boolean": bool,
+    "object": dict,
+    "array": list,
+    "null": type(None),
+}
+
+
+def json_type_matches(value, type_name):
+    """Check a value against a JSON Schema type name"""
+    if isinstance(value, bool) and type_name in ("integer", "number"):
+        return False
+    return isinstance(value, JSON_TYPES[type_name])
+
+
+def validate_value(value, schema, path, errors):
+    """Validate a value against the subset of JSON Schema used by vibecon.schema.json.
+
+    Supports $ref (local), type, enum, required, properties,
+    patternProperties, additionalProperties and items. Appends error strings to errors.
+    """
+    if "$ref" in schema:
+        ref = load_schema()
+        for part in schema["$ref"].lstrip("#/").split("/"):
+            if part:
+                ref = ref[part]
+        schema = ref
+
+    types = schema.get("type")
+    if types:
+        types = types if isinstance(types, list) else [types]
+        if not any(json_type_matches(value, t) for t in types):
+            errors.append(f"{path}: expected {' or '.join(types)}, got {json.dumps(value)}")
+            return
+
+    if "enum" in schema and value not in schema["enum"]:
+        choices = ", ".join(json.dumps(choice) for choice in schema["enum"])
+        errors.append(f"{path}: {json.dumps(value)} is not one of {choices}")
+        return
+
+    if isinstance(value, dict):
+        properties = schema.get("properties", {})
+        additional = schema.get("additionalProperties", True)
+        for key in schema.get("required", []):
+            if key not in value:
+                errors.append(f"{path}: missing required field '{key}'")
+        patterns = schema.get("patternProperties", {})
+        for key, item in value.items():
+            pattern = next((p for p in patterns if re.search(p, key)), None)
+            if key in properties:
+                validate_value(item, properties[key], f"{path}.{key}", errors)
+            elif pattern is not None:
+                validate_value(item, patterns[pattern], f"{path}.{key}", errors)
+            elif additional is False:
+                message = f"{path}: unknown field '{key}'"
+                suggestions = difflib.get_close_matches(key, properties, n=1)
+                if suggestions:
+                    message += f" (did you mean '{suggestions[0]}'?)"
+                errors.append(message)
+            elif isinstance(additional, dict):
+                validate_value(item, additional, f"{path}.{key}", errors)
+
+    if isinstance(value, list) and "items" in schema:
+        for i, item in enumerate(value):
+            validate_value(item, schema["items"], f"{path}[{i}]", errors)
+
+
+def validate_config(config):
+    """Validate a config dict against the schema. Returns a list of error strings."""
+    schema = load_schema()
+    if schema is None:
+        return []
+    errors = []
+    validate_value(config, schema, "config", errors)
+    return errors
+
+
+# Terminal styling for vibecon's own output; off with NO_COLOR, --no-color,
+# TERM=dumb, or when stdout isn't a terminal (logs, CI)
+COLOR = {"enabled": None}
+STYLES = {
+    "reset": "\033[0m",
+    "bold": "\033[1m",
+    "red": "\033[91m",
+    "green": "\033[92m",
+    "yellow": "\033[93m",
+    "blue": "\033[94m",
+    "magenta": "\033[95m",
+    "cyan": "\033[96m",
+}
+
+
+def use_color():
+    """Whether output may contain ANSI colors"""
+    if COLOR["enabled"] is None:
+        COLOR["enabled"] = (
+            sys.stdout.isatty()
+            and not os.environ.get("NO_COLOR")
+            and os.environ.get("TERM") != "dumb"
+        )
+    return COLOR["enabled"]
+
+
+def style(text, *names):
+    """Wrap text in the named STYLES when colors are enabled"""
+    if not use_color() or not names:
+        return text
+    return "".join(STYLES[name] for name in names) + text + STYLES["reset"]
+
+
+def success(message):
+    """Print a success message"""
+    print(style(message, "green"))
+
+
+def warn(message):
+    """Print a warning"""
+    LOG.warning(message)
+    print(f"{style('Warning:', 'yellow', 'bold')} {message}")
+
+
+# Exit codes by error category, so scripts can tell vibecon's own failures
+# apart; other errors exit with 1 and commands' exit codes are passed through
+ERROR_CATEGORIES = {
+    "docker-not-found": (80, "Install Docker (Docker Desktop, Docker Engine or Podman) and make sure 'docker' is on PATH"),
+    "daemon-unreachable": (81, "Start Docker Desktop or the docker service, and check DOCKER_HOST and 'docker context ls'"),
+    "image-missing": (82, "Check the 'image' setting and your registry login, or rebuild the vibecon image with 'vibecon -B'"),
+    "config-invalid": (83, "Run 'vibecon config validate' and 'vibecon config show' to check the config"),
+    "mount-error": (84, "Check that mount sources exist and that Docker may access them (Docker Desktop: Settings > Resources > File sharing)"),
+}
+EXIT_ERROR = 1
+
+
+def fail(category, message, hint=None):
+    """Print an error with a hint for its category and exit with the category's exit code.
+
+    category may be None for uncategorized errors, which exit with EXIT_ERROR.
+    """
+    code, default_hint = ERROR_CATEGORIES.get(category, (EXIT_ERROR, None))
+    LOG.error("%s (%s, exit %d)", message, category, code)
+    print(f"{style('Error:', 'red', 'bold')} {message}")
+    if hint or default_hint:
+        print(f"{style('Hint:', 'cyan')} {hint or default_hint}")
+    sys.exit(code)
+
+
+def docker_error_category(stderr):
+    """Guess the error category from a failed docker command's stderr"""
+    text = (stderr or "").lower()
+    if "cannot connect to the docker daemon" in text or "error during connect" in text or "docker daemon running" in text:
+        return "daemon-unreachable"
+    if "no such image" in text or "pull access denied" in text or "manifest unknown" in text:
+        return "image-missing"
+    if "mounts denied" in text or "invalid mount" in text or "bind source path does not exist" in text or "mount source" in text:
+        return "mount-error"
+    return None
+
+
+# Retry policy for docker operations that may fail transiently, set from the
+# 'docker_retry' config by set_docker_retry()
+DEFAULT_DOCKER_RETRY = {"attempts": 3, "delay": 2, "timeout": 120, "pull_timeout": 1800, "daemon_wait": 60}
+DOCKER_RETRY = dict(DEFAULT_DOCKER_RETRY)
+
+# stderr fragments of failures worth retrying
+TRANSIENT_DOCKER_ERRORS = (
+    "cannot connect to the docker daemon", "error during connect", "docker daemon running",
+    "tls handshake timeout", "i/o timeout", "connection reset", "connection refused",
+    "temporary failure in name resolution", "unexpected eof", "context deadline exceeded",
+    "502 bad gateway", "503 service unavailable", "504 gateway timeout", "too many requests",
+)
+
+
+def set_docker_retry(config):
+    """Apply the 'docker_retry' config to docker operations of this run"""
+    DOCKER_RETRY.update(DEFAULT_DOCKER_RETRY)
+    DOCKER_RETRY.update(config.get("docker_retry", {}))
+
+
+def run_docker(args, timeout=None, **kwargs):
+    """Run a docker command, retrying transient failures with exponential backoff.
+
+    stderr is always captured (and echoed on final failure by the caller); timeout
+    defaults to DOCKER_RETRY["timeout"], 0 means none. Returns the last CompletedProcess,
+    with returncode 124 if the last attempt timed out.
+    """
+    if timeout is None:
+        timeout = DOCKER_RETRY["timeout"]
+    kwargs["stderr"] = subprocess.PIPE
+    attempts = max(1, DOCKER_RETRY["attempts"])
+    for attempt in range(1, attempts + 1):
+        try:
+            result = run_command(args, timeout=timeout or None, **kwargs)
+            stderr = result.stderr.decode(errors="replace") if isinstance(result.stderr, bytes) else result.stderr
+            transient = result.returncode != 0 and any(error in stderr.lower() for error in TRANSIENT_DOCKER_ERRORS)
+        except subprocess.TimeoutExpired:
+            stderr = f"'{' '.join(args[:3])}' timed out after {timeout}s"
+            message = stderr.encode() if not kwargs.get("text") else stderr
+            result = subprocess.CompletedProcess(args, 124, None, message)
+            transient = True
+        if not transient or attempt == attempts:
+            return result
+        delay = DOCKER_RETRY["delay"] * 2 ** (attempt - 1)
+        warn(f"{stderr.strip().splitlines()[-1] if stderr.strip() else 'docker failed'}; "
+             f"retrying in {delay}s ({attempt}/{attempts - 1})")
+        time.sleep(delay)
+    return result
+
+
+def wait_for_docker():
+    """Wait up to DOCKER_RETRY["daemon_wait"] seconds for the Docker daemon, e.g. while Docker Desktop starts"""
+    deadline = time.monotonic() + DOCKER_RETRY["daemon_wait"]
+    announced = False
+    while True:
+        result = run_command(
+            ["docker", "version", "--format", "{{.Server.Version}}"],
+            stdout=subprocess.DEVNULL,
+            stderr=subprocess.PIPE,
+            text=True
+        )
+        if result.returncode == 0:
+            return
+        if docker_error_category(result.stderr) != "daemon-unreachable" or time.monotonic() >= deadline:
+            fail(docker_error_category(result.stderr), f"Docker is not available: {result.stderr.strip()}")
+        if not announced:
+            print("Waiting for the Docker daemon...")
+            announced = True
+        time.sleep(1)
+
+
+def check_config(config, path):
+    """Exit with an error listing all schema violations in a config file"""
+    errors = validate_config(config)
+    if errors:
+        fail("config-invalid", f"Invalid config {path}:\n" + "\n".join(f"  {error}" for error in errors))
+
+
+# Config file names, in order of preference
+CONFIG_FILENAMES = (".vibecon.json", ".vibecon.yaml", ".vibecon.yml")
+
+
+def find_config_in_dir(directory):
+    """Return the config file in a directory, or None if there is none"""
+    found = [Path(directory) / name for name in CONFIG_FILENAMES if (Path(directory) / name).exists()]
+    if len(found) > 1:
+        warn(f"Multiple config files in {directory}, using {found[0].name}")
+    return found[0] if found else None
+
+
+def global_config_path():
+    """Path of the global config: $VIBECON_CONFIG, or ~/.vibecon.json (.yaml, .yml)"""
+    if os.environ.get("VIBECON_CONFIG"):
+        return Path(os.path.expanduser(os.environ["VIBECON_CONFIG"]))
+    return find_config_in_dir(Path.home()) or Path.home() / ".vibecon.json"
+
+
+# Environment variables that override config settings (see env_overrides)
+ENV_OVERRIDES = {
+    "VIBECON_IMAGE": "image",
+    "VIBECON_RUNTIME": "runtime",
+    "VIBECON_DEFAULT_COMMAND": "default_command",
+    "VIBECON_NETWORK": "network",
+}
+
+
+def env_overrides():
+    """Build a partial config from VIBECON_* environment variables.
+
+    Precedence, highest first: command-line flags, VIBECON_* variables,
+    project config, global config.
+    """
+    overrides = {}
+    for var, key in ENV_OVERRIDES.items():
+        if os.environ.get(var):
+            overrides[key] = os.environ[var]
+    return overrides
+
+
+def env_profiles():
+    """Profiles from VIBECON_PROFILE (comma-separated), used when no -p is given"""
+    return [name for name in os.environ.get("VIBECON_PROFILE", "").split(",") if name]
+
+
+def parse_config(text, name):
+    """Parse config file contents as YAML or JSON, based on the file name.
+
+    Raises ValueError for invalid content. YAML needs PyYAML, which is only
+    imported when a YAML config is actually used.
+    """
+    if str(name).endswith((".yaml", ".yml")):
+        try:
+            import yaml
+        except ImportError:
+            fail("config-invalid", f"PyYAML is required to read {name} (pip install pyyaml)")
+        try:
+            config = yaml.safe_load(text)
+        except yaml.YAMLError as e:
+            raise ValueError(f"Invalid YAML: {e}")
+        return config if config is not None else {}
+    try:
+        return json.loads(text)
+    except json.JSONDecodeError as e:
+        raise ValueError(f"Invalid JSON: {e}")
+
+
+def read_config_file(path):
+    """Read and parse a config file. Raises ValueError for invalid content."""
+    with open(path) as f:
+        return parse_config(f.read(), path)
+
+
 def load_config(config_path):
-    """Load JSON config file, return empty dict if not found or invalid."""
+    """Load a config file, return empty dict if not found. Exits if invalid."""
     path = os.path.expanduser(config_path)
     if not os.path.exists(path):
         return {}
     try:
-        with open(path) as f:
-            return json.load(f)
-    except json.JSONDecodeError as e:
-        print(f"Error: Invalid JSON in {path}: {e}")
-        sys.exit(1)
+        config = read_config_file(path)
+    except ValueError as e:
+        fail("config-invalid", f"{e} in {path}")
+    check_config(config, path)
+    return expand_config(resolve_extends(config, os.path.dirname(os.path.abspath(path))))
 
 
-def find_project_root():
-    """Find project root by searching for .vibecon.json with 'root' defined.
+# Remote base configs are refetched at most this often (seconds)
+EXTENDS_CACHE_TTL = 3600
+EXTENDS_CACHE_DIR = Path.home() / ".cache" / "vibecon" / "extends"
 
-    Searches current directory and parents until finding a .vibecon.json
-    with a 'root' field defined. Returns tuple of (project_root_path, root_config, container_mount_root).
-    Exits with error if no .vibecon.json with 'root' is found.
+
+def is_url(location):
+    return location.startswith(("http://", "https://"))
+
+
+# Settings a remote base config may only set once the user trusts it: they run
+# commands, grant privileges, or expose host files and credentials
+REMOTE_RESTRICTED_KEYS = (
+    "secrets", "on_create", "privileged", "docker_access", "cap_add", "security_opt", "compose",
+    "cloud_credentials", "kubeconfig", "git_credentials", "git_signing", "env_passthrough",
+)
+EXTENDS_TRUST_FILE = EXTENDS_CACHE_DIR / "trusted.json"
+
+
+def restricted_settings(config, prefix=""):
+    """Names of the REMOTE_RESTRICTED_KEYS and host mounts a config sets, profiles included"""
+    found = [prefix + key for key in REMOTE_RESTRICTED_KEYS if key in config]
+    if any(isinstance(m, dict) and m.get("type") in ("bind", "device") for m in config.get("mounts", [])):
+        found.append(prefix + "mounts")
+    for name, profile in config.get("profiles", {}).items():
+        found.extend(restricted_settings(profile, f"{prefix}profiles.{name}."))
+    return found
+
+
+def check_remote_base_trust(url, content, config):
+    """Exit unless the user trusts a remote base config that sets restricted settings.
+
+    Trust is remembered per URL for this exact content, so a changed config
+    is asked about again.
+    """
+    restricted = restricted_settings(config)
+    if not restricted:
+        return
+    digest = hashlib.sha256(content.encode()).hexdigest()
+    trusted = json.loads(EXTENDS_TRUST_FILE.read_text()) if EXTENDS_TRUST_FILE.exists() else {}
+    if trusted.get(url) == digest:
+        return
+    message = f"Base config {url} sets {', '.join(restricted)}, which can run commands on or expose this machine"
+    if not sys.stdin.isatty():
+        fail("config-invalid", message, "Run vibecon in a terminal once to review and trust it, or move those settings into a local config")
+    warn(message)
+    if not confirm(f"Trust this version of {url}?"):
+        fail("config-invalid", f"Refused base config {url}")
+    trusted[url] = digest
+    EXTENDS_TRUST_FILE.parent.mkdir(parents=True, exist_ok=True)
+    EXTENDS_TRUST_FILE.write_text(json.dumps(trusted, indent=2) + "\n")
+
+
+def fetch_config_url(url):
+    """Fetch a remote base config, caching it under EXTENDS_CACHE_DIR.
+
+    A cached copy younger than EXTENDS_CACHE_TTL is used without fetching; a
+    stale copy is used with a warning if the fetch fails.
+    """
+    cache_file = EXTENDS_CACHE_DIR / (hashlib.sha256(url.encode()).hexdigest()[:16] + ".json")
+    if cache_file.exists() and time.time() - cache_file.stat().st_mtime < EXTENDS_CACHE_TTL:
+        return cache_file.read_text()
+
+    try:
+        with urllib.request.urlopen(url, timeout=10) as response:
+            content = response.read().decode()
+    except (urllib.error.URLError, OSError) as e:
+        if cache_file.exists():
+            warn(f"Failed to fetch {url} ({e}), using cached copy")
+            return cache_file.read_text()
+        fail("config-invalid", f"Failed to fetch base config {url}: {e}")
+
+    EXTENDS_CACHE_DIR.mkdir(parents=True, exist_ok=True)
+    cache_file.write_text(content)
+    return content
+
+
+# Object-valued settings merged key by key when configs are layered
+MERGED_DICT_KEYS = ("env", "profiles", "secrets", "ulimits", "sysctls")
+
+# Settings that only make sense at the top level of a config, not in a profile
+NON_PROFILE_KEYS = ("root", "extends", "profiles")
+
+
+def layer_config(base, override):
+    """Layer one config on top of another.
+
+    Settings in override replace those in base, except MERGED_DICT_KEYS which
+    are merged key by key; mounts are appended, with an override mount
+    replacing a base mount that has the same target.
     """
+    result = {**base, **override}
+    for key in MERGED_DICT_KEYS:
+        if key in base and key in override:
+            result[key] = {**base[key], **override[key]}
+    override_mounts = override.get("mounts", [])
+    override_targets = {mount_target(m) for m in override_mounts} - {None}
+    base_mounts = [m for m in base.get("mounts", []) if mount_target(m) not in override_targets]
+    if base_mounts or override_mounts:
+        result["mounts"] = base_mounts + override_mounts
+    return result
+
+
+def resolve_extends(config, location, seen=frozenset()):
+    """Apply the 'extends' key: layer config on top of the base configs it names.
+
+    'extends' is a path (relative to the config's location) or http(s) URL, or
+    a list of them applied in order. Base configs may extend further configs.
+    """
+    extends = config.get("extends")
+    if not extends:
+        return config
+
+    result = {}
+    for ref in as_list(extends):
+        if is_url(ref):
+            base_location = ref
+        elif is_url(location):
+            base_location = urllib.parse.urljoin(location, ref)
+        else:
+            base_location = os.path.normpath(os.path.join(location, os.path.expanduser(ref)))
+
+        if base_location in seen:
+            fail("config-invalid", f"Circular 'extends' involving {base_location}")
+
+        if base_location.startswith("http://"):
+            fail("config-invalid", f"Base config URLs must use https: {base_location}")
+        if is_url(base_location):
+            content = fetch_config_url(base_location)
+        elif os.path.exists(base_location):
+            with open(base_location) as f:
+                content = f.read()
+        else:
+            fail("config-invalid", f"Base config not found: {base_location}")
+
+        try:
+            base = parse_config(content, base_location)
+        except ValueError as e:
+            fail("config-invalid", f"{e} in {base_location}")
+        check_config(base, base_location)
+        if is_url(base_location):
+            check_remote_base_trust(base_location, content, base)
+
+        parent = base_location.rsplit("/", 1)[0] + "/" if is_url(base_location) else os.path.dirname(base_location)
+        base = resolve_extends(base, parent, seen | {base_location})
+        result = layer_config(result, base)
+
+    own = {key: value for key, value in config.items() if key != "extends"}
+    return layer_config(result, own)
+
+
+def container_mount_root(root, project_root):
+    """Resolve the 'root' config value: an absolute container path, or "host"
+    to mount the project at the same path as on the host (keeps absolute paths
+    in stack traces, gopls caches etc. valid on both sides)."""
+    if root == "host":
+        return Path(project_root).as_posix()
+    if not posixpath.isabs(root):
+        fail("config-invalid", f"'root' must be an absolute container path or \"host\", got: {root}")
+    return posixpath.normpath(root)
+
+
+def locate_project_root():
+    """find_project_root() without the error: None outside of a project"""
     current = Path(os.getcwd()).resolve()
 
     while True:
-        config_path = current / ".vibecon.json"
-        if config_path.exists():
+        config_path = find_config_in_dir(current)
+        if config_path:
             try:
-                with open(config_path) as f:
-                    config = json.load(f)
-                if "root" in config:
+                config = read_config_file(config_path)
+                if isinstance(config, dict) and "root" in config:
                     # Found a config with root defined
-                    return str(current), config, config["root"]
-            except json.JSONDecodeError:
-                pass  # Invalid JSON, skip this file
+                    check_config(config, config_path)
+                    config = expand_config(resolve_extends(config, str(current)))
+                    return str(current), config, container_mount_root(config["root"], str(current))
+            except ValueError:
+                pass  # Invalid config, skip this file
 
         # Move to parent directory
         parent = current.parent
         if parent == current:
             # Reached filesystem root
-            break
+            return None
         current = parent
 
+
+def find_project_root():
+    """Find project root by searching for a config file with 'root' defined.
+
+    Searches current directory and parents until finding a .vibecon.json
+    (or .vibecon.yaml/.yml) with a 'root' field defined. Returns tuple of
+    (project_root_path, root_config, container_mount_root).
+    Exits with error if no config with 'root' is found.
+    """
+    found = locate_project_root()
+    if found:
+        return found
+
     # No root config found - exit with error
-    print("Error: No .vibecon.json with 'root' field found in current directory or any parent.")
-    print("Create a .vibecon.json file with a 'root' field to define the project root.")
-    print('Example: {"root": "/workspace"}')
-    sys.exit(1)
+    fail(
+        "config-invalid",
+        "No .vibecon.json with 'root' field found in current directory or any parent.",
+        "Create a .vibecon.json file with a 'root' field to define the project root, "
+        'e.g. {"root": "/workspace"}, or run \'vibecon init\''
+    )
 
 
-def get_merged_config(root_config):
+def get_merged_config(root_config, mount_root):
     """Load and merge global + project configs.
 
     Args:
         root_config: The root config from find_project_root() - required.
+        mount_root: The resolved container mount root from find_project_root().
 
-    Global mounts from ~/.vibecon.json are added first, then project mounts.
+    Global mounts from ~/.vibecon.json are added first, then project mounts;
+    a project mount replaces a global one with the same target. Other settings
+    are taken from the project config when present, falling back to the global
+    config; MERGED_DICT_KEYS are merged key by key instead.
+
+    The project config can set ignore_global (skip the global config entirely)
+    or ignore_global_mounts (skip only its mounts).
     """
-    global_cfg = load_config("~/.vibecon.json")
+    # Hermetic projects can opt out of the developer's global config
+    if root_config.get("ignore_global", False):
+        global_cfg = {}
+    else:
+        global_cfg = load_config(global_config_path())
+        if root_config.get("ignore_global_mounts", False):
+            global_cfg = {key: value for key, value in global_cfg.items() if key != "mounts"}
     project_mounts = root_config.get("mounts", [])
 
-    return {
-        "mounts": global_cfg.get("mounts", []) + project_mounts,
-    }
+    # Non-mount settings: project values override global ones
+    merged = {}
+    for cfg in (global_cfg, root_config):
+        for key, value in cfg.items():
+            if key in MERGED_DICT_KEYS:
+                merged[key] = {**merged.get(key, {}), **value}
+            elif key != "mounts":
+                merged[key] = value
+
+    merged["mounts"] = merge_mounts(global_cfg.get("mounts", []), project_mounts, mount_root)
+    return merged
+
+
+def apply_profiles(config, profile_names):
+    """Layer the named profiles from config["profiles"] on top of the config.
+
+    Without explicit profile names, the "default" profile is applied if it exists.
+    """
+    profiles = config.get("profiles", {})
+    if not profile_names:
+        profile_names = ["default"] if "default" in profiles else []
+
+    for name in profile_names:
+        if name not in profiles:
+            available = ", ".join(profiles) or "none defined"
+            fail("config-invalid", f"Unknown profile '{name}' (available: {available})")
+        profile = profiles[name]
+        for key in NON_PROFILE_KEYS:
+            if key in profile:
+                fail("config-invalid", f"Profile '{name}' cannot set '{key}'")
+        config = layer_config(config, profile)
+    return config
+
+
+def parse_cli_mount(value):
+    """Parse a --mount value: a JSON mount object, or SOURCE:TARGET[:ro] for a bind mount"""
+    if value.startswith("{"):
+        try:
+            return json.loads(value)
+        except json.JSONDecodeError as e:
+            fail("config-invalid", f"Invalid JSON in --mount: {e}")
+    parts = value.split(":")
+    if len(parts) not in (2, 3) or (len(parts) == 3 and parts[2] != "ro"):
+        fail("config-invalid", f"--mount must be SOURCE:TARGET[:ro] or a JSON mount object, got: {value}")
+    mount_spec = {"type": "bind", "source": parts[0], "target": parts[1]}
+    if len(parts) == 3:
+        mount_spec["read_only"] = True
+    return expand_config(mount_spec)
+
+
+def cli_overrides(args):
+    """Build a partial config from --mount, --env, --port, --publish-all and --network flags"""
+    overrides = {}
+    if args.mount:
+        overrides["mounts"] = [parse_cli_mount(value) for value in args.mount]
+    if args.env:
+        env = {}
+        for value in args.env:
+            # KEY=VALUE, or just KEY to pass the host's value through
+            key, sep, val = value.partition("=")
+            env[key] = val if sep else os.environ.get(key, "")
+        overrides["env"] = env
+    if args.port:
+        overrides["ports"] = args.port
+    if args.publish_all:
+        overrides["publish_all"] = True
+    if args.network:
+        overrides["network"] = args.network
+    return overrides
+
+
+def mount_target(mount_spec):
+    """Normalized container path of a mount spec, or None if it has none"""
+    if not isinstance(mount_spec, dict):
+        return None
+    target = mount_spec.get("target")
+    if not target and mount_spec.get("type") == "device":
+        target = mount_spec.get("source")
+    return posixpath.normpath(target) if target else None
+
+
+def merge_mounts(global_mounts, project_mounts, mount_root):
+    """Merge global and project mounts, resolving target conflicts.
+
+    A project mount replaces a global mount with the same target. Duplicate
+    targets within one config file, and mounts onto the workspace root itself,
+    are errors.
+    """
+    for source_name, mounts in (("~/.vibecon.json", global_mounts), (".vibecon.json", project_mounts)):
+        seen = set()
+        for mount_spec in mounts:
+            target = mount_target(mount_spec)
+            if target is None:
+                continue
+            if target in seen:
+                fail("mount-error", f"Duplicate mount target '{target}' in {source_name}")
+            if mount_root and target == posixpath.normpath(mount_root):
+                fail("mount-error", f"Mount in {source_name} targets the workspace root '{target}'")
+            seen.add(target)
+
+    project_targets = {mount_target(m) for m in project_mounts} - {None}
+    merged = []
+    for mount_spec in global_mounts:
+        target = mount_target(mount_spec)
+        if target in project_targets:
+            print(f"Note: project mount for '{target}' overrides the global mount")
+            continue
+        merged.append(mount_spec)
+    return merged + project_mounts
+
+
+BIND_PROPAGATION_MODES = ("rprivate", "private", "rshared", "shared", "rslave", "slave")
+BIND_CONSISTENCY_MODES = ("consistent", "cached", "delegated")
+
+
+def host_os():
+    """Host operating system name as used by the mount 'os' field"""
+    if sys.platform.startswith("linux"):
+        return "linux"
+    if sys.platform == "win32":
+        return "windows"
+    return sys.platform  # "darwin"
+
+
+def mount_field(field):
+    """Quote a --mount field for Docker's CSV parser if it contains a comma or quote"""
+    if "," in field or '"' in field:
+        return '"' + field.replace('"', '""') + '"'
+    return field
+
+
+def tmpfs_ownership_opts(uid, gid):
+    """Local driver options for a tmpfs-backed volume owned by uid/gid.
+
+    Docker's local driver only supports uid/gid ownership with tmpfs.
+    """
+    mount_opts = []
+    if uid is not None:
+        mount_opts.append(f"uid={uid}")
+    if gid is not None:
+        mount_opts.append(f"gid={gid}")
+    return {"type": "tmpfs", "device": "tmpfs", "o": ",".join(mount_opts)}
+
+
+def volume_mount_args(volume_name, target, driver, driver_opts, read_only):
+    """Build --mount arguments for a volume with a driver and/or driver options.
+
+    volume_name may be None for an anonymous volume.
+    """
+    mount_parts = ["type=volume"]
+    if volume_name:
+        mount_parts.append(f"source={volume_name}")
+    mount_parts.append(f"target={target}")
+    if driver:
+        mount_parts.append(f"volume-driver={driver}")
+    for key, value in driver_opts.items():
+        mount_parts.append(mount_field(f"volume-opt={key}={value}"))
+    if read_only:
+        mount_parts.append("readonly")
+    return ["--mount", ",".join(mount_parts)]
 
 
 def parse_mount(mount_spec, project_root, container_name):
     """Parse mount spec into docker mount arguments.
 
-    Returns a list of docker arguments, e.g., ["-v", "..."] or ["--mount", "..."]
+    Returns a list of docker arguments, e.g., ["-v", "..."] or ["--mount", "..."],
+    or an empty list if the mount is skipped on this host.
+
+    Any mount may set os (list of "darwin", "linux", "windows") to apply only on
+    those hosts. Bind and device mounts may set optional (bool) to be skipped
+    silently when the source doesn't exist.
 
     All mounts must be objects with explicit type. Supported types:
 
     1. type="bind" - Bind mount from host to container
        Required: type, source, target
-       Optional: read_only (bool), selinux ("z" or "Z")
+       Optional: read_only (bool), selinux ("z" or "Z"),
+                 propagation ("rprivate", "rshared", "rslave", ...),
+                 consistency ("consistent", "cached", "delegated" - macOS only)
 
     2. type="volume" - Named Docker volume
        Required: type, source (volume name), target
-       Optional: read_only (bool), uid (int), gid (int), selinux ("z" or "Z"), global (bool)
+       Optional: read_only (bool), uid (int), gid (int), selinux ("z" or "Z"), global (bool),
+                 driver (str), driver_opts (object)
 
     3. type="anonymous" - Anonymous Docker volume
        Required: type, target
        Optional: read_only (bool), uid (int), gid (int)
+
+    4. type="tmpfs" - In-memory filesystem
+       Required: type, target
+       Optional: size (e.g. "512m"), mode (octal string, e.g. "1777"), read_only (bool)
+
+    5. type="device" - Host device passthrough
+       Required: type, source (host device path)
+       Optional: target (defaults to source), permissions ("rwm" subset), cgroup_rule (e.g. "c 10:229 rwm")
+
+    Under Podman, uid/gid mounts use U=true instead of tmpfs volume options.
     """
     if isinstance(mount_spec, str):
-        print(f"Error: Mount must be an object with explicit 'type' field, got string: {mount_spec}")
-        sys.exit(1)
+        fail("mount-error", f"Mount must be an object with explicit 'type' field, got string: {mount_spec}")
 
     if not isinstance(mount_spec, dict):
-        print(f"Error: Mount must be an object, got: {type(mount_spec).__name__}")
-        sys.exit(1)
+        fail("mount-error", f"Mount must be an object, got: {type(mount_spec).__name__}")
 
     mount_type = mount_spec.get("type")
     if not mount_type:
-        print(f"Error: Mount missing required 'type' field: {mount_spec}")
-        sys.exit(1)
+        fail("mount-error", f"Mount missing required 'type' field: {mount_spec}")
+
+    # Skip mounts restricted to other host operating systems
+    mount_os = mount_spec.get("os")
+    if mount_os is not None and host_os() not in as_list(mount_os):
+        return []
+
+    optional = mount_spec.get("optional", False)
 
     target = mount_spec.get("target")
-    if not target:
-        print(f"Error: Mount missing required 'target' field: {mount_spec}")
-        sys.exit(1)
+    if not target and mount_type != "device":
+        fail("mount-error", f"Mount missing required 'target' field: {mount_spec}")
 
     read_only = mount_spec.get("read_only", False)
     selinux = mount_spec.get("selinux")  # "z" or "Z"
@@ -127,25 +962,11 @@ def parse_mount(mount_spec, project_root, container_name):
         uid = mount_spec.get("uid")
         gid = mount_spec.get("gid")
 
-        if uid is not None or gid is not None:
+        if (uid is not None or gid is not None) and get_engine_info()["podman"]:
+            return podman_owned_mount(["type=tmpfs", f"target={target}"], uid, gid, read_only)
+        elif uid is not None or gid is not None:
             # Use --mount syntax with tmpfs-backed volume for uid/gid support
-            mount_opts = []
-            if uid is not None:
-                mount_opts.append(f"uid={uid}")
-            if gid is not None:
-                mount_opts.append(f"gid={gid}")
-            driver_opts = f"o={','.join(mount_opts)}"
-
-            mount_parts = [
-                "type=volume",
-                f"target={target}",
-                "volume-opt=type=tmpfs",
-                "volume-opt=device=tmpfs",
-                f'"volume-opt={driver_opts}"',
-            ]
-            if read_only:
-                mount_parts.append("readonly")
-            return ["--mount", ",".join(mount_parts)]
+            return volume_mount_args(None, target, None, tmpfs_ownership_opts(uid, gid), read_only)
         else:
             return ["-v", target]
 
@@ -153,19 +974,38 @@ def parse_mount(mount_spec, project_root, container_name):
         # Bind mount - requires source path
         source = mount_spec.get("source")
         if not source:
-            print(f"Error: Bind mount missing required 'source' field: {mount_spec}")
-            sys.exit(1)
+            fail("mount-error", f"Bind mount missing required 'source' field: {mount_spec}")
 
         # Resolve source path
         resolved = os.path.expanduser(source)
         if not os.path.isabs(resolved):
             resolved = os.path.normpath(os.path.join(project_root, resolved))
         if not os.path.exists(resolved):
-            print(f"Warning: bind mount source does not exist: {resolved}")
+            if optional:
+                return []
+            warn(f"bind mount source does not exist: {resolved}")
 
         # uid/gid not supported for bind mounts
         if mount_spec.get("uid") or mount_spec.get("gid"):
-            print(f"Warning: uid/gid options ignored for bind mount (not supported by Docker)")
+            warn(f"uid/gid options ignored for bind mount (not supported by Docker)")
+
+        propagation = mount_spec.get("propagation")
+        if propagation and propagation not in BIND_PROPAGATION_MODES:
+            fail("mount-error", f"Invalid bind propagation '{propagation}'. Must be one of: {', '.join(BIND_PROPAGATION_MODES)}")
+        consistency = mount_spec.get("consistency")
+        if consistency and consistency not in BIND_CONSISTENCY_MODES:
+            fail("mount-error", f"Invalid bind consistency '{consistency}'. Must be one of: {', '.join(BIND_CONSISTENCY_MODES)}")
+
+        if (propagation or consistency) and not selinux:
+            # --mount syntax (SELinux relabeling is only available with -v)
+            mount_parts = ["type=bind", f"source={resolved}", f"target={target}"]
+            if read_only:
+                mount_parts.append("readonly")
+            if propagation:
+                mount_parts.append(f"bind-propagation={propagation}")
+            if consistency:
+                mount_parts.append(f"consistency={consistency}")
+            return ["--mount", ",".join(mount_parts)]
 
         mount_arg = f"{resolved}:{target}"
         suffix_opts = []
@@ -173,6 +1013,10 @@ def parse_mount(mount_spec, project_root, container_name):
             suffix_opts.append("ro")
         if selinux:
             suffix_opts.append(selinux)
+        if propagation:
+            suffix_opts.append(propagation)
+        if consistency:
+            suffix_opts.append(consistency)
         if suffix_opts:
             mount_arg += ":" + ",".join(suffix_opts)
         return ["-v", mount_arg]
@@ -181,8 +1025,7 @@ def parse_mount(mount_spec, project_root, container_name):
         # Named volume - requires source (volume name)
         source = mount_spec.get("source")
         if not source:
-            print(f"Error: Volume mount missing required 'source' field: {mount_spec}")
-            sys.exit(1)
+            fail("mount-error", f"Volume mount missing required 'source' field: {mount_spec}")
 
         # Determine volume name based on global flag
         if mount_spec.get("global", False):
@@ -194,26 +1037,19 @@ def parse_mount(mount_spec, project_root, container_name):
         uid = mount_spec.get("uid")
         gid = mount_spec.get("gid")
 
-        # If uid/gid specified, use --mount syntax with tmpfs-backed volume
+        if (uid is not None or gid is not None) and get_engine_info()["podman"]:
+            return podman_owned_mount(["type=volume", f"source={volume_name}", f"target={target}"], uid, gid, read_only)
+
+        driver = mount_spec.get("driver")
+        driver_opts = mount_spec.get("driver_opts", {})
+        if not isinstance(driver_opts, dict):
+            fail("mount-error", f"Volume 'driver_opts' must be an object: {mount_spec}")
+        # If uid/gid specified, back the volume with tmpfs (explicit driver_opts win)
         if uid is not None or gid is not None:
-            mount_opts = []
-            if uid is not None:
-                mount_opts.append(f"uid={uid}")
-            if gid is not None:
-                mount_opts.append(f"gid={gid}")
-            driver_opts = f"o={','.join(mount_opts)}"
-
-            mount_parts = [
-                "type=volume",
-                f"source={volume_name}",
-                f"target={target}",
-                "volume-opt=type=tmpfs",
-                "volume-opt=device=tmpfs",
-                f'"volume-opt={driver_opts}"',
-            ]
-            if read_only:
-                mount_parts.append("readonly")
-            return ["--mount", ",".join(mount_parts)]
+            driver_opts = {**tmpfs_ownership_opts(uid, gid), **driver_opts}
+
+        if driver or driver_opts:
+            return volume_mount_args(volume_name, target, driver, driver_opts, read_only)
         else:
             # Simple -v syntax
             mount_arg = f"{volume_name}:{target}"
@@ -226,678 +1062,7148 @@ def parse_mount(mount_spec, project_root, container_name):
                 mount_arg += ":" + ",".join(suffix_opts)
             return ["-v", mount_arg]
 
+    elif mount_type == "tmpfs":
+        # tmpfs - options are passed as tmpfs mount options
+        tmpfs_opts = []
+        if read_only:
+            tmpfs_opts.append("ro")
+        if mount_spec.get("size"):
+            tmpfs_opts.append(f"size={mount_spec['size']}")
+        if mount_spec.get("mode"):
+            tmpfs_opts.append(f"mode={mount_spec['mode']}")
+        tmpfs_arg = target
+        if tmpfs_opts:
+            tmpfs_arg += ":" + ",".join(tmpfs_opts)
+        return ["--tmpfs", tmpfs_arg]
+
+    elif mount_type == "device":
+        # Device - requires source device path on the host
+        source = mount_spec.get("source")
+        if not source:
+            fail("mount-error", f"Device mount missing required 'source' field: {mount_spec}")
+        if not os.path.exists(source):
+            if optional:
+                return []
+            warn(f"device does not exist on host: {source}")
+
+        device_arg = f"{source}:{target or source}"
+        permissions = mount_spec.get("permissions")
+        if permissions:
+            device_arg += f":{permissions}"
+        device_args = ["--device", device_arg]
+        if mount_spec.get("cgroup_rule"):
+            device_args.extend(["--device-cgroup-rule", mount_spec["cgroup_rule"]])
+        return device_args
+
     else:
-        print(f"Error: Unknown mount type '{mount_type}'. Must be 'bind', 'volume', or 'anonymous'")
-        sys.exit(1)
+        fail("mount-error", f"Unknown mount type '{mount_type}'. Must be 'bind', 'volume', 'anonymous', 'tmpfs', or 'device'")
 
 
-# DEFAULT_COMMAND = ["zsh"]
-DEFAULT_COMMAND = ["claude", "--dangerously-skip-permissions"]
+# Default healthcheck: a trivial exec that fails when the container is wedged
+DEFAULT_HEALTHCHECK = {
+    "cmd": "true",
+    "interval": "5s",
+    "timeout": "5s",
+    "retries": 3,
+    "start_period": "0s",
+    "wait_timeout": 60,
+}
 
-def install_symlink(simulate_path_missing=False):
-    """Install symlink to ~/.local/bin/vibecon"""
-    # ANSI color codes
-    RESET = "\033[0m"
-    BOLD = "\033[1m"
-    RED = "\033[91m"
-    GREEN = "\033[92m"
-    YELLOW = "\033[93m"
-    BLUE = "\033[94m"
-    MAGENTA = "\033[95m"
-    CYAN = "\033[96m"
 
-    script_path = Path(__file__).resolve()
-    install_dir = Path.home() / ".local" / "bin"
-    symlink_path = install_dir / "vibecon"
+def get_healthcheck_config(config):
+    """Return the effective healthcheck settings, or None if disabled.
 
-    # Create a display version with $HOME substitution
-    home_str = str(Path.home())
-    install_dir_str = str(install_dir)
-    if install_dir_str.startswith(home_str):
-        install_dir_display = "$HOME" + install_dir_str[len(home_str):]
-    else:
-        install_dir_display = install_dir_str
+    The 'healthcheck' config key may be false to disable the healthcheck, or an
+    object overriding any of the DEFAULT_HEALTHCHECK fields.
+    """
+    healthcheck = config.get("healthcheck", {})
+    if healthcheck is False:
+        return None
+    if not isinstance(healthcheck, dict):
+        fail("config-invalid", f"'healthcheck' must be an object or false, got: {type(healthcheck).__name__}")
+    return {**DEFAULT_HEALTHCHECK, **healthcheck}
+
+
+def healthcheck_args(config):
+    """Build docker run arguments for the container healthcheck."""
+    healthcheck = get_healthcheck_config(config)
+    if healthcheck is None:
+        return ["--no-healthcheck"]
+    return [
+        "--health-cmd", healthcheck["cmd"],
+        "--health-interval", healthcheck["interval"],
+        "--health-timeout", healthcheck["timeout"],
+        "--health-retries", str(healthcheck["retries"]),
+        "--health-start-period", healthcheck["start_period"],
+    ]
 
-    # Create install directory if it doesn't exist
-    install_dir.mkdir(parents=True, exist_ok=True)
 
-    # Check if symlink already exists and points to the correct target
-    already_installed = False
-    if symlink_path.is_symlink() and symlink_path.resolve() == script_path:
-        already_installed = True
-        print(f"{GREEN}{BOLD}Already installed:{RESET} {CYAN}{symlink_path}{RESET} -> {BLUE}{script_path}{RESET}")
-    else:
-        # Remove existing symlink if it exists but points elsewhere
-        if symlink_path.exists() or symlink_path.is_symlink():
-            symlink_path.unlink()
+# Docker built-in network modes; any other name is a user-defined network
+BUILTIN_NETWORKS = ("bridge", "host", "none")
 
-        # Create symlink
-        symlink_path.symlink_to(script_path)
-        print(f"{GREEN}Installed:{RESET} {CYAN}{symlink_path}{RESET} -> {BLUE}{script_path}{RESET}")
 
-    # Check if install directory is in PATH
-    path_env = os.environ.get("PATH", "")
-    if simulate_path_missing or install_dir_str not in path_env.split(os.pathsep):
-        # Detect user's shell
-        shell_path = os.environ.get("SHELL", "")
-        shell_name = os.path.basename(shell_path) if shell_path else "unknown"
+def get_network_name(config, container_name):
+    """Return the docker network for the container, or None for Docker's default.
 
-        # Determine config file and export syntax based on shell
-        if shell_name == "zsh":
-            config_file = "~/.zshrc"
-            export_cmd = f'export PATH="{install_dir_display}:$PATH"'
-        elif shell_name == "bash":
-            config_file = "~/.bashrc"
-            export_cmd = f'export PATH="{install_dir_display}:$PATH"'
-        elif shell_name == "fish":
-            config_file = "~/.config/fish/config.fish"
-            export_cmd = f'set -gx PATH "{install_dir_display}" $PATH'
-        elif shell_name in ["tcsh", "csh"]:
-            config_file = "~/.cshrc"
-            export_cmd = f'setenv PATH "{install_dir_display}:$PATH"'
-        else:
-            config_file = "~/.profile"
-            export_cmd = f'export PATH="{install_dir_display}:$PATH"'
+    The 'network' config key accepts a built-in mode (bridge/host/none),
+    "project" for a per-project user-defined network, or any custom network name.
+    """
+    network = config.get("network")
+    if not network and config.get("compose"):
+        # Join the compose services' default network
+        network = compose_network_name(container_name)
+    if get_docker_access(config) == "dind":
+        # The dind sidecar must share a user-defined network with the workspace
+        if not network:
+            network = "project"
+        elif network in BUILTIN_NETWORKS:
+            fail("config-invalid", f"docker_access 'dind' requires a user-defined network, not '{network}'")
+    if not network:
+        return None
+    if not isinstance(network, str):
+        fail("config-invalid", f"'network' must be a string, got: {type(network).__name__}")
+    if network == "project":
+        return project_network_name(container_name)
+    return network
 
-        # Print large banner warning with colors
-        print(f"\n{RED}{BOLD}{'=' * 70}")
-        print(f"  ⚠️  WARNING: PATH CUSTOMIZATION REQUIRED")
-        print(f"{'=' * 70}{RESET}")
-        print(f"\n  {YELLOW}{BOLD}{install_dir_display}{RESET} {RED}{BOLD}is NOT in your PATH!{RESET}\n")
-        print(f"  You must add it to your PATH to use {CYAN}{BOLD}'vibecon'{RESET} by name.")
-        print(f"\n{BLUE}{'─' * 70}{RESET}")
-        print(f"  {MAGENTA}Detected shell:{RESET} {BOLD}{shell_name}{RESET}")
-        print(f"{BLUE}{'─' * 70}{RESET}")
-        print(f"\n  Add to PATH {GREEN}permanently{RESET}:")
-        print(f"    {GREEN}echo '{export_cmd}' >> {config_file}{RESET}")
-        print(f"    {GREEN}source {config_file}{RESET}")
-        print(f"\n{RED}{BOLD}{'=' * 70}{RESET}\n")
-    else:
-        print(f"\n{GREEN}{BOLD}✓{RESET} {GREEN}You can now use vibecon by its name:{RESET} {CYAN}{BOLD}vibecon{RESET}")
 
-def uninstall_symlink():
-    """Uninstall symlink from ~/.local/bin/vibecon"""
-    symlink_path = Path.home() / ".local" / "bin" / "vibecon"
+def network_alias_args(config, network_name):
+    """Build --network-alias and --ip arguments for user-defined networks.
 
-    if symlink_path.exists() or symlink_path.is_symlink():
-        symlink_path.unlink()
-        print(f"Uninstalled: {symlink_path}")
-    else:
-        print(f"Symlink not found: {symlink_path}")
+    Other containers on the network always reach the workspace as "workspace",
+    plus any 'network_aliases'. 'ip' pins the address, which Docker only allows
+    on networks with a configured subnet ('network_subnet' for ones vibecon creates).
+    """
+    user_defined = network_name is not None and network_name not in BUILTIN_NETWORKS
+    for key in ("network_aliases", "ip", "network_subnet"):
+        if config.get(key) and not user_defined:
+            fail("config-invalid", f"'{key}' requires a user-defined network, e.g. \"network\": \"project\"")
+    if not user_defined:
+        return []
+    args = []
+    for alias in ["workspace"] + as_list(config.get("network_aliases")):
+        args.extend(["--network-alias", alias])
+    if config.get("ip"):
+        args.extend(["--ip", config["ip"]])
+    return args
+
+
+def project_network_name(container_name):
+    """Name of the per-project user-defined network"""
+    return f"{container_name}-net"
+
+
+def get_compose_config(config, project_root):
+    """Return {"file", "services"} from 'compose', or None without a compose file.
+
+    'compose' is a path relative to the project root, or {"file", "services"}
+    to start only some services (and their dependencies).
+    """
+    value = config.get("compose")
+    if not value:
+        return None
+    if isinstance(value, str):
+        value = {"file": value}
+    compose_file = Path(project_root) / Path(value.get("file", "docker-compose.yml")).expanduser()
+    if not compose_file.is_file():
+        fail("config-invalid", f"Compose file not found: {compose_file}")
+    return {"file": str(compose_file), "services": value.get("services", [])}
 
 
-def init_config(target_path):
-    """Initialize .vibecon.json with root field in target directory.
+def compose_project_name(container_name):
+    """Compose project of the workspace, one per container so workspaces don't share services"""
+    return re.sub(r"[^a-z0-9_-]", "-", container_name.lower())
 
-    Creates the file if it doesn't exist, or adds root field to existing file.
-    Fails if root field already exists.
-    """
-    target_dir = Path(target_path).resolve()
-    if not target_dir.is_dir():
-        print(f"Error: '{target_path}' is not a directory")
-        sys.exit(1)
 
-    config_path = target_dir / ".vibecon.json"
+def compose_network_name(container_name):
+    return f"{compose_project_name(container_name)}_default"
 
-    if config_path.exists():
-        # Load existing config
-        try:
-            with open(config_path) as f:
-                config = json.load(f)
-        except json.JSONDecodeError as e:
-            print(f"Error: Invalid JSON in {config_path}: {e}")
-            sys.exit(1)
 
-        # Check if root already exists
-        if "root" in config:
-            print(f"Error: '{config_path}' already has a 'root' field defined")
-            sys.exit(1)
-
-        # Add root field
-        config["root"] = "/workspace"
-    else:
-        # Create new config
-        config = {"root": "/workspace"}
-
-    # Write config
-    with open(config_path, "w") as f:
-        json.dump(config, f, indent=2)
-        f.write("\n")
+def compose_env(project_root, config):
+    """{SERVICE}_HOST variables naming the compose services, which are reachable by service name"""
+    compose = get_compose_config(config, project_root)
+    if compose is None:
+        return {}
+    services = compose["services"]
+    if not services:
+        result = run_command(
+            ["docker", "compose", "-f", compose["file"], "config", "--services"],
+            stdout=subprocess.PIPE,
+            stderr=subprocess.DEVNULL,
+            text=True
+        )
+        services = result.stdout.split()
+    return {re.sub(r"[^A-Z0-9]", "_", service.upper()) + "_HOST": service for service in services}
+
+
+def compose_up(project_root, container_name, config):
+    """Start the compose services before the container joins their network"""
+    compose = get_compose_config(config, project_root)
+    if compose is None:
+        return
+    print(f"Starting compose services from {compose['file']}...")
+    result = run_command(
+        ["docker", "compose", "-p", compose_project_name(container_name), "-f", compose["file"], "up", "-d"] + compose["services"]
+    )
+    if result.returncode != 0:
+        fail(None, f"Failed to start the compose services from {compose['file']}",
+             "Check the file with 'docker compose config', and that the compose plugin is installed ('docker compose version')")
 
-    print(f"Initialized: {config_path}")
-    print('  Added: "root": "/workspace"')
 
-def is_container_running(container_name):
-    """Check if container is running"""
-    result = subprocess.run(
-        ["docker", "inspect", "-f", "{{.State.Running}}", container_name],
+def compose_down(container_name, remove_volumes=True):
+    """Remove the compose services started for the container, if there are any"""
+    project = compose_project_name(container_name)
+    result = run_command(
+        ["docker", "ps", "-aq", "--filter", f"label=com.docker.compose.project={project}"],
         stdout=subprocess.PIPE,
         stderr=subprocess.DEVNULL,
         text=True
     )
-    return result.returncode == 0 and result.stdout.strip() == "true"
-
-def container_exists(container_name):
-    """Check if container exists (in any state: running, stopped, dead, etc.)"""
-    result = subprocess.run(
-        ["docker", "inspect", container_name],
+    if not result.stdout.strip():
+        return
+    print(f"Removing compose services of '{container_name}'...")
+    run_command(
+        ["docker", "compose", "-p", project, "down"] + (["-v"] if remove_volumes else []),
         stdout=subprocess.DEVNULL,
         stderr=subprocess.DEVNULL
     )
-    return result.returncode == 0
 
-def restart_container(container_name):
-    """Attempt to restart a stopped/dead container. Returns True if successful."""
-    print(f"Found stopped container '{container_name}', attempting to restart...")
-    result = subprocess.run(
-        ["docker", "start", container_name],
-        stdout=subprocess.PIPE,
-        stderr=subprocess.PIPE
-    )
-    if result.returncode == 0:
-        print(f"Container '{container_name}' restarted successfully.")
-        return True
-    else:
-        print(f"Failed to restart container: {result.stderr.decode().strip()}")
-        return False
 
-def stop_container(container_name):
-    """Stop the container (can be restarted later)"""
-    print(f"Stopping container '{container_name}'...")
-    result = subprocess.run(
-        ["docker", "stop", container_name],
-        stdout=subprocess.DEVNULL,
-        stderr=subprocess.DEVNULL
-    )
-    if result.returncode == 0:
-        print("Container stopped.")
-    else:
-        print("Container was not running.")
+# Domains always reachable under a network_policy so the AI tools keep working
+DEFAULT_ALLOWED_DOMAINS = [
+    "api.anthropic.com",
+    "statsig.anthropic.com",
+    "sentry.io",
+    "generativelanguage.googleapis.com",
+    "oauth2.googleapis.com",
+    "api.openai.com",
+    "registry.npmjs.org",
+]
 
-def destroy_container(container_name):
-    """Destroy and remove the container permanently"""
-    print(f"Destroying container '{container_name}'...")
-    subprocess.run(
-        ["docker", "rm", "-f", container_name],
-        stdout=subprocess.DEVNULL,
-        stderr=subprocess.DEVNULL
-    )
-    print("Container destroyed.")
 
-def find_vibecon_root():
-    """Find the vibecon root directory (parent of vibecon.py where Dockerfile is)"""
-    # Resolve symlink to find actual script location
-    script_path = Path(__file__).resolve()
-    script_dir = script_path.parent
-    dockerfile_path = script_dir / "Dockerfile"
+# Dot-separated DNS labels of letters, digits and hyphens
+HOSTNAME_PATTERN = re.compile(r"^(?=.{1,253}$)([A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)(\.[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*\.?$")
 
-    if dockerfile_path.exists():
-        return str(script_dir)
-    return None
 
-def generate_container_name(workspace_path):
-    """Generate container name based on workspace path"""
-    # Create full hash from the workspace path
-    path_hash = hashlib.md5(workspace_path.encode()).hexdigest()[:8]
+def get_network_policy(config):
+    """Return the egress allowlist as (domains, cidrs), or None if unrestricted.
 
-    # Sanitize the path for use in container name
-    # Remove leading slash and replace special chars with hyphens
-    sanitized_path = workspace_path.lstrip('/').replace('/', '-').replace('_', '-').lower()
+    The 'network_policy' config key is an object:
+      allow: list of domain names and/or CIDRs (e.g. "github.com", "10.0.0.0/8")
+      allow_defaults: bool, include DEFAULT_ALLOWED_DOMAINS (default true)
+    """
+    policy = config.get("network_policy")
+    if not policy:
+        return None
+    if not isinstance(policy, dict):
+        fail("config-invalid", f"'network_policy' must be an object, got: {type(policy).__name__}")
 
-    return f"vibecon-{path_hash}-{sanitized_path}"
+    domains = list(DEFAULT_ALLOWED_DOMAINS) if policy.get("allow_defaults", True) else []
+    cidrs = []
+    for entry in policy.get("allow", []):
+        if all(c.isdigit() or c in "./" for c in entry):
+            try:
+                cidrs.append(str(ipaddress.IPv4Network(entry, strict=False)))
+            except ValueError as e:
+                fail("config-invalid", f"Invalid CIDR in 'network_policy.allow': {e}")
+        elif HOSTNAME_PATTERN.match(entry):
+            domains.append(entry)
+        else:
+            fail("config-invalid", f"Invalid domain in 'network_policy.allow': {entry}")
+    return domains, cidrs
 
-def image_exists(image_name):
-    """Check if Docker image exists"""
-    result = subprocess.run(
-        ["docker", "image", "inspect", image_name],
-        stdout=subprocess.DEVNULL,
-        stderr=subprocess.PIPE,
-        text=True
-    )
-    if result.returncode != 0:
-        # Only return False if it's actually "not found", not other errors
-        if "no such image" in result.stderr.lower():
-            return False
-        # Some other error occurred - print it and exit
-        print(f"Error checking image: {result.stderr.strip()}")
-        sys.exit(1)
-    return True
 
-async def get_npm_package_version_async(package_name, short_name):
-    """Get the latest version of an npm package asynchronously"""
-    proc = await asyncio.create_subprocess_exec(
-        "npm", "view", package_name, "version",
-        stdout=asyncio.subprocess.PIPE,
-        stderr=asyncio.subprocess.PIPE
-    )
-    stdout, stderr = await proc.communicate()
-    if proc.returncode == 0:
-        return stdout.decode().strip()
-    else:
-        print(f"Warning: Failed to get {short_name} version from npm")
-        return None
+def network_policy_script(domains, cidrs):
+    """Generate a shell script that restricts outbound traffic to the allowlist"""
+    lines = [
+        "set -e",
+        "iptables -F OUTPUT",
+        "ipset destroy vibecon-allow 2>/dev/null || true",
+        "ipset create vibecon-allow hash:net",
+    ]
+    for cidr in cidrs:
+        lines.append(f"ipset add -exist vibecon-allow {shlex.quote(cidr)}")
+    for domain in domains:
+        lines.append(
+            f"for ip in $(dig +short A {shlex.quote(domain)} | grep -E '^[0-9.]+$'); do "
+            f"ipset add -exist vibecon-allow $ip; done"
+        )
+    lines += [
+        # Docker's embedded DNS (127.0.0.11) is reached over lo
+        "iptables -A OUTPUT -o lo -j ACCEPT",
+        # DNS only to the container's own resolvers, not to any server
+        "for ns in $(awk '$1 == \"nameserver\" {print $2}' /etc/resolv.conf | grep -E '^[0-9.]+$'); do "
+        "iptables -A OUTPUT -d $ns -p udp --dport 53 -j ACCEPT; "
+        "iptables -A OUTPUT -d $ns -p tcp --dport 53 -j ACCEPT; done",
+        "iptables -A OUTPUT -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT",
+        "iptables -A OUTPUT -m set --match-set vibecon-allow dst -j ACCEPT",
+        "iptables -A OUTPUT -j REJECT --reject-with icmp-admin-prohibited",
+        # No IPv6 allowlist - block it outright if the container has IPv6
+        "ip6tables -F OUTPUT 2>/dev/null || true",
+        "ip6tables -A OUTPUT -o lo -j ACCEPT 2>/dev/null || true",
+        "ip6tables -A OUTPUT -j REJECT 2>/dev/null || true",
+    ]
+    return "\n".join(lines) + "\n"
 
 
-async def get_go_version_async():
-    """Get the latest stable Go version from golang.org"""
-    proc = await asyncio.create_subprocess_exec(
-        "curl", "-s", "https://go.dev/dl/?mode=json",
-        stdout=asyncio.subprocess.PIPE,
-        stderr=asyncio.subprocess.PIPE
-    )
-    stdout, stderr = await proc.communicate()
-    if proc.returncode == 0:
-        try:
-            releases = json.loads(stdout.decode())
-            # First stable release in the list
-            for release in releases:
-                if release.get("stable", False):
-                    # Version is like "go1.24.2", strip the "go" prefix
-                    return release["version"].lstrip("go")
-        except (json.JSONDecodeError, KeyError, IndexError):
-            pass
-    print("Warning: Failed to get Go version from golang.org")
-    return None
+# Proxy variables propagated from the host (both spellings are in common use)
+PROXY_ENV_VARS = ["HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "ALL_PROXY"]
 
 
-def get_all_versions():
-    """Get versions of all AI CLI tools from npm and Go from golang.org concurrently"""
-    print("Checking latest versions...")
+def get_proxy_env(config):
+    """Return proxy environment variables to pass into builds and containers.
 
-    packages = [
-        ("@google/gemini-cli", "g", "Gemini CLI"),
-        ("@openai/codex", "oac", "OpenAI Codex"),
-    ]
+    Values come from the host environment. The 'proxy' config key may be false
+    to disable passthrough, or an object such as {"https_proxy": "..."} whose
+    entries override the host values (an empty string removes a variable).
+    """
+    proxy = config.get("proxy", {})
+    if proxy is False:
+        return {}
+    if not isinstance(proxy, dict):
+        fail("config-invalid", f"'proxy' must be an object or false, got: {type(proxy).__name__}")
+
+    values = {}
+    for var in PROXY_ENV_VARS:
+        value = os.environ.get(var) or os.environ.get(var.lower())
+        if value:
+            values[var] = value
+    for key, value in proxy.items():
+        values[key.upper()] = value
+
+    env = {}
+    for var, value in values.items():
+        if value:
+            env[var] = value
+            env[var.lower()] = value
+    return env
+
+
+# Host credentials passed into exec when set, so agents find their API keys
+DEFAULT_ENV_PASSTHROUGH = [
+    "ANTHROPIC_API_KEY",
+    "ANTHROPIC_AUTH_TOKEN",
+    "ANTHROPIC_BASE_URL",
+    "OPENAI_API_KEY",
+    "OPENAI_BASE_URL",
+    "GEMINI_API_KEY",
+    "GOOGLE_API_KEY",
+    "GOOGLE_APPLICATION_CREDENTIALS",
+    "GOOGLE_CLOUD_PROJECT",
+    "OPENROUTER_API_KEY",
+    "MISTRAL_API_KEY",
+    "GROQ_API_KEY",
+    "DEEPSEEK_API_KEY",
+    "GITHUB_TOKEN",
+    "GH_TOKEN",
+]
+
+
+def passthrough_env_args(config):
+    """Build docker exec arguments for host env vars on the passthrough allowlist.
+
+    'env_passthrough' may be false to disable it, or a list of extra variable
+    names; names prefixed with '!' remove a built-in entry. Variables are passed
+    as '-e NAME' so docker reads the value from its own environment and it
+    doesn't appear in the process list.
+    """
+    passthrough = config.get("env_passthrough", [])
+    if passthrough is False:
+        return []
+
+    names = list(DEFAULT_ENV_PASSTHROUGH)
+    for name in passthrough:
+        if name.startswith("!"):
+            names = [n for n in names if n != name[1:]]
+        elif name not in names:
+            names.append(name)
+
+    args = []
+    for name in names:
+        if name in os.environ:
+            args.extend(["-e", name])
+    return args
+
+
+def env_args(env):
+    """Convert an env dict into docker -e arguments"""
+    args = []
+    for key, value in env.items():
+        args.extend(["-e", f"{key}={value}"])
+    return args
+
+
+def as_list(value):
+    """Normalize a config value that may be a single string or a list"""
+    if value is None:
+        return []
+    if isinstance(value, list):
+        return value
+    return [value]
+
+
+def dns_args(config, network_name):
+    """Build docker run arguments for extra_hosts, dns and dns_search.
+
+    extra_hosts may be an object {"hostname": "ip"} or a list of "hostname:ip".
+    On Linux host.docker.internal is mapped to the host gateway automatically,
+    matching Docker Desktop on macOS/Windows.
+    """
+    args = []
 
-    async def fetch_all():
-        npm_tasks = [get_npm_package_version_async(pkg, short) for pkg, short, _ in packages]
-        go_task = get_go_version_async()
-        all_tasks = npm_tasks + [go_task]
-        return await asyncio.gather(*all_tasks)
+    extra_hosts = config.get("extra_hosts", [])
+    if isinstance(extra_hosts, dict):
+        extra_hosts = [f"{host}:{ip}" for host, ip in extra_hosts.items()]
+    hostnames = [entry.split(":", 1)[0] for entry in extra_hosts]
+    if sys.platform.startswith("linux") and network_name != "host" and "host.docker.internal" not in hostnames:
+        extra_hosts = ["host.docker.internal:host-gateway"] + extra_hosts
+    for entry in extra_hosts:
+        args.extend(["--add-host", entry])
 
-    results = asyncio.run(fetch_all())
+    for server in as_list(config.get("dns")):
+        args.extend(["--dns", server])
+    for domain in as_list(config.get("dns_search")):
+        args.extend(["--dns-search", domain])
+    return args
 
-    # npm package results
-    npm_results = results[:-1]
-    go_result = results[-1]
 
-    versions = {}
-    for (_, short_name, display_name), version in zip(packages, npm_results):
-        if version:
-            versions[short_name] = version
-            print(f"  {display_name}: {version}")
-        else:
-            versions[short_name] = "latest"
-            print(f"  {display_name}: latest (failed to fetch)")
+def ulimit_args(config):
+    """Build --ulimit arguments from 'ulimits'.
 
-    # Go version
-    if go_result:
-        versions["go"] = go_result
-        print(f"  Go: {go_result}")
-    else:
-        versions["go"] = "1.24.2"  # fallback
-        print(f"  Go: 1.24.2 (failed to fetch, using fallback)")
+    Each limit is a number for both soft and hard limit, "soft:hard", or
+    {"soft": n, "hard": n}; -1 means unlimited.
+    """
+    args = []
+    for name, value in config.get("ulimits", {}).items():
+        if isinstance(value, dict):
+            if "soft" not in value or "hard" not in value:
+                fail("config-invalid", f"ulimits.{name} needs both 'soft' and 'hard'")
+            value = f"{value['soft']}:{value['hard']}"
+        args.extend(["--ulimit", f"{name}={value}"])
+    return args
 
-    return versions
 
+# Rotation used when 'logging' is not set, so long-lived containers don't fill the disk
+DEFAULT_LOGGING = {"driver": "json-file", "options": {"max-size": "10m", "max-file": "3"}}
 
-def make_composite_tag(versions):
-    """Create composite tag from versions: g{ver}_oac{ver}_go{ver}"""
-    return f"g{versions['g']}_oac{versions['oac']}_go{versions['go']}"
 
-def get_host_timezone():
-    """Get the host system timezone"""
-    # First, try the TZ environment variable
-    tz = os.environ.get("TZ")
-    if tz:
-        return tz
+def logging_args(config):
+    """Build --log-driver/--log-opt arguments from 'logging'.
 
-    # Try reading /etc/timezone (common on Debian/Ubuntu)
-    try:
-        with open("/etc/timezone", "r") as f:
-            tz = f.read().strip()
-            if tz:
-                return tz
-    except (FileNotFoundError, PermissionError):
-        pass
+    Unset means json-file logs rotated at DEFAULT_LOGGING; false leaves the
+    daemon's default driver and options. The default rotation options also
+    apply when 'logging' names the json-file driver without options.
+    """
+    logging_config = config.get("logging", True)
+    if logging_config is False:
+        return []
+    if logging_config is True:
+        logging_config = DEFAULT_LOGGING
+    driver = logging_config.get("driver", DEFAULT_LOGGING["driver"])
+    options = logging_config.get("options")
+    if options is None:
+        options = DEFAULT_LOGGING["options"] if driver == DEFAULT_LOGGING["driver"] else {}
+    args = ["--log-driver", driver]
+    for key, value in options.items():
+        args.extend(["--log-opt", f"{key}={value}"])
+    return args
+
+
+def get_container_hostname(config, project_root):
+    """The 'hostname' config, by default vibecon-{project directory name}.
+
+    Names are reduced to what hostnames allow (letters, digits, hyphens, at
+    most 63 characters), so tools keyed on the hostname can tell workspaces apart.
+    """
+    hostname = config.get("hostname") or f"vibecon-{os.path.basename(project_root)}"
+    hostname = re.sub(r"[^a-z0-9-]+", "-", hostname.lower()).strip("-")[:63].rstrip("-")
+    return hostname or "vibecon"
 
-    # Try to get timezone from timedatectl (systemd-based systems)
-    try:
-        result = subprocess.run(
-            ["timedatectl", "show", "-p", "Timezone", "--value"],
-            stdout=subprocess.PIPE,
-            stderr=subprocess.DEVNULL,
-            text=True
-        )
-        if result.returncode == 0:
-            tz = result.stdout.strip()
-            if tz:
-                return tz
-    except FileNotFoundError:
-        pass
 
-    # Fallback: try to determine from /etc/localtime symlink
-    try:
-        localtime_path = Path("/etc/localtime")
-        if localtime_path.is_symlink():
-            target = localtime_path.resolve()
-            # Extract timezone from path like /usr/share/zoneinfo/America/New_York
-            parts = target.parts
-            if "zoneinfo" in parts:
-                zoneinfo_idx = parts.index("zoneinfo")
-                if len(parts) > zoneinfo_idx + 1:
-                    tz = "/".join(parts[zoneinfo_idx + 1:])
-                    return tz
-    except (FileNotFoundError, PermissionError):
-        pass
+DOCKER_SOCKET = "/var/run/docker.sock"
+DIND_IMAGE = "docker:dind-rootless"
 
-    # If all else fails, return UTC as default
-    return "UTC"
 
-def get_git_user_info():
-    """Get git user.name and user.email from host"""
-    user_name = ""
-    user_email = ""
+def get_docker_access(config):
+    """Return the 'docker_access' mode: None, "socket" or "dind"."""
+    docker_access = config.get("docker_access")
+    if docker_access not in (None, "socket", "dind"):
+        fail("config-invalid", f"'docker_access' must be 'socket' or 'dind', got: {docker_access}")
+    return docker_access
 
-    # Get git user.name
-    result = subprocess.run(
-        ["git", "config", "--global", "user.name"],
-        stdout=subprocess.PIPE,
-        stderr=subprocess.DEVNULL,
-        text=True
-    )
-    if result.returncode == 0:
-        user_name = result.stdout.strip()
 
-    # Get git user.email
-    result = subprocess.run(
-        ["git", "config", "--global", "user.email"],
-        stdout=subprocess.PIPE,
-        stderr=subprocess.DEVNULL,
-        text=True
-    )
-    if result.returncode == 0:
-        user_email = result.stdout.strip()
+def dind_container_name(container_name):
+    """Name of the docker-in-docker sidecar for a workspace container"""
+    return f"{container_name}-dind"
 
-    return user_name, user_email
 
-def build_image(vibecon_root, image_name, versions=None):
-    """Build the Docker image with all AI CLI tools and Go"""
-    if versions is None:
-        versions = {"g": "latest", "oac": "latest", "go": "1.24.2"}
+def docker_access_args(config, container_name):
+    """Build docker run arguments giving the container access to a Docker engine.
 
-    composite_tag = make_composite_tag(versions)
-    print(f"Building image with composite tag: {composite_tag}")
+    "socket" mounts the host's Docker socket (full control of the host engine).
+    "dind" points DOCKER_HOST at a rootless docker-in-docker sidecar.
+    """
+    docker_access = get_docker_access(config)
+    if docker_access == "socket":
+        warn("docker_access 'socket' gives the container full control of the host Docker engine")
+        args = ["-v", f"{DOCKER_SOCKET}:{DOCKER_SOCKET}"]
+        # The node user needs the socket's group to talk to the engine; without
+        # a socket on the host its group is unknown, so none is added
+        if os.path.exists(DOCKER_SOCKET):
+            args.extend(["--group-add", str(os.stat(DOCKER_SOCKET).st_gid)])
+        return args
+    if docker_access == "dind":
+        return ["-e", "DOCKER_HOST=tcp://docker:2375"]
+    return []
 
-    # Build command with all version build args
-    build_cmd = [
-        "docker", "build",
-        "--build-arg", f"GEMINI_CLI_VERSION={versions['g']}",
-        "--build-arg", f"OPENAI_CODEX_VERSION={versions['oac']}",
-        "--build-arg", f"GO_VERSION={versions['go']}",
-        "-t", image_name,
-        "-t", f"vibecon:{composite_tag}"
-    ]
 
-    print(f"Tagging as: {image_name} and vibecon:{composite_tag}")
+# Capabilities that effectively give the container root on the host
+DANGEROUS_CAPABILITIES = ("ALL", "SYS_ADMIN", "SYS_MODULE", "SYS_RAWIO", "SYS_PTRACE", "DAC_READ_SEARCH")
 
-    build_cmd.append(".")
 
-    build_result = subprocess.run(build_cmd, cwd=vibecon_root)
-    if build_result.returncode != 0:
-        print("Failed to build image")
-        sys.exit(1)
+def security_args(config):
+    """Build docker run arguments for privileged, cap_add, cap_drop, security_opt and read_only.
 
-    return composite_tag
+    Prints a warning for every setting that weakens container isolation.
+    """
+    args = []
 
-def sync_claude_config(container_name):
-    """Sync Claude config to container: statusLine section + referenced files + CLAUDE.md + commands dir"""
-    claude_dir = Path.home() / ".claude"
-    container_claude_dir = "/home/node/.claude"
-    settings_file = claude_dir / "settings.json"
-    claude_md_file = claude_dir / "CLAUDE.md"
+    if config.get("privileged", False):
+        warn("'privileged' is enabled - the container has full access to the host's devices and kernel")
+        args.append("--privileged")
 
-    # Track files to copy and whether we need to do anything
-    files_to_copy = []
-    container_settings = {}
+    for cap in as_list(config.get("cap_add")):
+        if cap.upper().removeprefix("CAP_") in DANGEROUS_CAPABILITIES:
+            warn(f"cap_add '{cap}' weakens container isolation significantly")
+        args.extend(["--cap-add", cap])
 
-    # Parse settings.json if it exists
-    if settings_file.exists():
-        try:
-            with open(settings_file, "r") as f:
-                settings = json.load(f)
+    for cap in as_list(config.get("cap_drop")):
+        args.extend(["--cap-drop", cap])
 
-            # Extract statusLine section if present
-            if "statusLine" in settings:
-                container_settings["statusLine"] = settings["statusLine"]
+    for opt in as_list(config.get("security_opt")):
+        if "unconfined" in opt:
+            warn(f"security_opt '{opt}' disables a kernel security profile")
+        args.extend(["--security-opt", opt])
+
+    if config.get("read_only", False):
+        # A read-only rootfs still needs a writable /tmp for most tools
+        print("Note: 'read_only' rootfs enabled - only mounts and /tmp are writable")
+        args.extend(["--read-only", "--tmpfs", "/tmp"])
+
+    return args
+
+
+def podman_owned_mount(mount_parts, uid, gid, read_only):
+    """Build a Podman --mount owned by the container user.
+
+    Podman's local driver has no tmpfs uid/gid volume options; instead U=true
+    chowns the mount to the user the container runs as (node).
+    """
+    if (uid, gid) not in ((1000, 1000), (1000, None), (None, 1000)):
+        warn(f"Podman mounts are owned by the container user, ignoring uid={uid} gid={gid}")
+    mount_parts = mount_parts + ["U=true"]
+    if read_only:
+        mount_parts.append("readonly")
+    return ["--mount", ",".join(mount_parts)]
+
+
+# DEFAULT_COMMAND = ["zsh"]
+DEFAULT_COMMAND = ["claude", "--dangerously-skip-permissions"]
+
+
+def get_default_command(config):
+    """Command run when none is given: 'default_command' (string or list) or DEFAULT_COMMAND"""
+    default_command = config.get("default_command")
+    if not default_command:
+        return DEFAULT_COMMAND
+    if isinstance(default_command, str):
+        return shlex.split(default_command)
+    return default_command
+
+
+SECRETS_DIR = "/run/secrets"
+SECRET_NAME_PATTERN = re.compile(r"^[A-Za-z0-9_.-]+$")
+# Secrets exported as env vars (as_env) become shell variable names
+ENV_SECRET_NAME_PATTERN = re.compile(r"^[A-Za-z_][A-Za-z0-9_]*$")
+
+
+def resolve_secret(name, spec):
+    """Read a secret's value on the host, or None if unavailable.
+
+    spec is an object with exactly one of from_env (host variable name),
+    from_file (host path), from_command (shell command printing the value) or
+    from_keychain (stored with "vibecon secret set").
+    """
+    if "from_env" in spec:
+        value = os.environ.get(spec["from_env"])
+        if value is None:
+            warn(f"secret '{name}': host variable {spec['from_env']} is not set")
+        return value
+    if "from_file" in spec:
+        path = os.path.expanduser(spec["from_file"])
+        try:
+            with open(path) as f:
+                return f.read()
+        except OSError as e:
+            warn(f"secret '{name}': cannot read {path}: {e}")
+            return None
+    if spec.get("from_keychain"):
+        value = keychain_get(name)
+        if value is None:
+            warn(f"secret '{name}' is not in the keychain")
+        return value
+    if "from_command" in spec:
+        result = run_command(spec["from_command"], shell=True, stdout=subprocess.PIPE, text=True)
+        if result.returncode != 0:
+            warn(f"secret '{name}': command failed with exit code {result.returncode}")
+            return None
+        return result.stdout.rstrip("\n")
+    fail("config-invalid", f"secret '{name}' needs one of from_env, from_file, from_command or from_keychain")
+
+
+# Stored secrets ("vibecon secret set"): values live in the OS keychain, or in
+# an openssl-encrypted file where no keychain is available. The index only
+# records names, so vibecon knows what to inject without unlocking anything.
+KEYCHAIN_SERVICE = "vibecon"
+SECRETS_STORE_DIR = Path.home() / ".config" / "vibecon"
+SECRETS_INDEX = SECRETS_STORE_DIR / "secrets.json"
+SECRETS_FILE = SECRETS_STORE_DIR / "secrets.enc"
+
+
+def keychain_backend():
+    """Secret storage backend: "macos" (Keychain), "secret-service" (Linux) or "file" """
+    if sys.platform == "darwin" and shutil.which("security"):
+        return "macos"
+    if shutil.which("secret-tool") and os.environ.get("DBUS_SESSION_BUS_ADDRESS"):
+        return "secret-service"
+    return "file"
+
+
+@functools.lru_cache(maxsize=None)
+def secrets_passphrase():
+    """Passphrase for the encrypted secrets file: $VIBECON_SECRETS_PASSPHRASE or a prompt.
+
+    Cached to ask only once per run, but kept out of os.environ so other
+    subprocesses (from_command secrets, hooks, docker) don't inherit it.
+    """
+    return os.environ.get("VIBECON_SECRETS_PASSPHRASE") or getpass.getpass("vibecon secrets passphrase: ")
+
+
+def read_secrets_file():
+    """Decrypt the fallback secrets file into a dict"""
+    if not SECRETS_FILE.exists():
+        return {}
+    result = run_command(
+        ["openssl", "enc", "-d", "-aes-256-cbc", "-pbkdf2", "-pass", "env:VIBECON_SECRETS_PASSPHRASE", "-in", str(SECRETS_FILE)],
+        env={**os.environ, "VIBECON_SECRETS_PASSPHRASE": secrets_passphrase()},
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    if result.returncode != 0:
+        fail(None, f"Failed to decrypt {SECRETS_FILE} (wrong passphrase?)")
+    return json.loads(result.stdout)
+
+
+def write_secrets_file(secrets):
+    """Encrypt a dict of secrets into the fallback secrets file"""
+    SECRETS_STORE_DIR.mkdir(parents=True, exist_ok=True)
+    result = run_command(
+        ["openssl", "enc", "-aes-256-cbc", "-pbkdf2", "-pass", "env:VIBECON_SECRETS_PASSPHRASE", "-out", str(SECRETS_FILE)],
+        input=json.dumps(secrets),
+        env={**os.environ, "VIBECON_SECRETS_PASSPHRASE": secrets_passphrase()},
+        stderr=subprocess.PIPE,
+        text=True
+    )
+    if result.returncode != 0:
+        fail(None, f"Failed to write {SECRETS_FILE}: {result.stderr.strip()}")
+    SECRETS_FILE.chmod(0o600)
+
+
+def keychain_get(name):
+    """Read a stored secret, or None if it isn't stored"""
+    backend = keychain_backend()
+    if backend == "macos":
+        cmd = ["security", "find-generic-password", "-s", KEYCHAIN_SERVICE, "-a", name, "-w"]
+    elif backend == "secret-service":
+        cmd = ["secret-tool", "lookup", "service", KEYCHAIN_SERVICE, "account", name]
+    else:
+        return read_secrets_file().get(name)
+    result = run_command(cmd, stdout=subprocess.PIPE, stderr=subprocess.DEVNULL, text=True)
+    return result.stdout.rstrip("\n") if result.returncode == 0 else None
+
+
+def keychain_set(name, value):
+    """Store a secret in the keychain backend"""
+    backend = keychain_backend()
+    if backend == "macos":
+        # Sent as a command on stdin, hex-encoded, so the value stays out of argv and the log
+        result = run_command(
+            ["security", "-i"],
+            input=f"add-generic-password -U -s {KEYCHAIN_SERVICE} -a {name} -X {value.encode().hex()}\n",
+            stdout=subprocess.DEVNULL, stderr=subprocess.PIPE, text=True
+        )
+    elif backend == "secret-service":
+        result = run_command(
+            ["secret-tool", "store", f"--label=vibecon {name}", "service", KEYCHAIN_SERVICE, "account", name],
+            input=value, stderr=subprocess.PIPE, text=True
+        )
+    else:
+        secrets = read_secrets_file()
+        secrets[name] = value
+        write_secrets_file(secrets)
+        return
+    if result.returncode != 0:
+        fail(None, f"Failed to store secret '{name}': {result.stderr.strip()}")
+
+
+def keychain_delete(name):
+    """Remove a secret from the keychain backend"""
+    backend = keychain_backend()
+    if backend == "macos":
+        cmd = ["security", "delete-generic-password", "-s", KEYCHAIN_SERVICE, "-a", name]
+    elif backend == "secret-service":
+        cmd = ["secret-tool", "clear", "service", KEYCHAIN_SERVICE, "account", name]
+    else:
+        secrets = read_secrets_file()
+        secrets.pop(name, None)
+        write_secrets_file(secrets)
+        return
+    run_command(cmd, stdout=subprocess.DEVNULL, stderr=subprocess.DEVNULL)
+
+
+def stored_secret_names():
+    """Names of secrets stored with "vibecon secret set" """
+    if not SECRETS_INDEX.exists():
+        return []
+    with open(SECRETS_INDEX) as f:
+        return json.load(f).get("names", [])
+
+
+def save_secret_names(names):
+    SECRETS_STORE_DIR.mkdir(parents=True, exist_ok=True)
+    with open(SECRETS_INDEX, "w") as f:
+        json.dump({"names": sorted(set(names))}, f, indent=2)
+        f.write("\n")
+
+
+def stored_secrets_config():
+    """Secrets config entries for all stored secrets, exported as env vars"""
+    secrets = {}
+    for name in stored_secret_names():
+        if not ENV_SECRET_NAME_PATTERN.match(name):
+            warn(f"stored secret '{name}' is not a valid variable name and is skipped; "
+                 f"remove it with 'vibecon secret rm {name}' and store it under another name")
+            continue
+        secrets[name] = {"from_keychain": True, "as_env": True}
+    return secrets
+
+
+def secret_command(argv):
+    """vibecon secret <action> - manage secrets stored in the OS keychain"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon secret",
+        description="Store secrets in the OS keychain; they are injected into every container as env vars"
+    )
+    actions = parser.add_subparsers(dest="action", required=True)
+    set_parser = actions.add_parser("set", help="store a secret (value read from a prompt or stdin)")
+    set_parser.add_argument("name")
+    rm_parser = actions.add_parser("rm", help="remove a stored secret")
+    rm_parser.add_argument("name")
+    actions.add_parser("list", help="list stored secret names")
+    args = parser.parse_args(argv)
+
+    if args.action == "list":
+        print(f"Backend: {keychain_backend()}")
+        for name in stored_secret_names():
+            print(f"  {name}")
+        return 0
+
+    # Stored secrets are exported as env vars, so new ones need variable names;
+    # rm still takes older names that don't qualify
+    pattern = ENV_SECRET_NAME_PATTERN if args.action == "set" else SECRET_NAME_PATTERN
+    if not pattern.match(args.name):
+        fail(None, f"invalid secret name '{args.name}' (letters, digits and '_', not starting with a digit)")
+
+    if args.action == "set":
+        if sys.stdin.isatty():
+            value = getpass.getpass(f"Value for {args.name}: ")
+        else:
+            value = sys.stdin.read().rstrip("\n")
+        if not value:
+            fail(None, "Empty secret value")
+        keychain_set(args.name, value)
+        save_secret_names(stored_secret_names() + [args.name])
+        print(f"Stored secret '{args.name}' ({keychain_backend()})")
+        return 0
+
+    if args.action == "rm":
+        keychain_delete(args.name)
+        save_secret_names([name for name in stored_secret_names() if name != args.name])
+        print(f"Removed secret '{args.name}'")
+        return 0
+
+
+def inject_secrets(container_name, config):
+    """Write secrets from config to files under SECRETS_DIR in the container.
+
+    Secrets are written at exec time through stdin, so they never show up in
+    'docker inspect'. Returns names of secrets to export as env vars (as_env).
+    """
+    env_names = []
+    # Stored secrets go to every container; config entries with the same name win
+    secrets = {**stored_secrets_config(), **config.get("secrets", {})}
+    for name, spec in secrets.items():
+        if not SECRET_NAME_PATTERN.match(name):
+            fail("config-invalid", f"invalid secret name '{name}' (letters, digits, '_', '.', '-')")
+        if spec.get("as_env", False) and not ENV_SECRET_NAME_PATTERN.match(name):
+            fail("config-invalid", f"secret '{name}' has as_env, but is not a valid environment variable name",
+                 "Use letters, digits and '_' only, not starting with a digit, or drop as_env to only write the file")
+        value = resolve_secret(name, spec)
+        if value is None:
+            continue
+        result = run_command(
+            ["docker", "exec", "-i", "-u", "root", container_name, "sh", "-c",
+             f"mkdir -p {SECRETS_DIR} && umask 077 && cat > {SECRETS_DIR}/{name} && chown node:node {SECRETS_DIR}/{name}"],
+            input=value,
+            stdout=subprocess.DEVNULL,
+            stderr=subprocess.PIPE,
+            text=True
+        )
+        if result.returncode != 0:
+            warn(f"Failed to inject secret '{name}': {result.stderr.strip()}")
+            continue
+        if spec.get("as_env", False):
+            env_names.append(name)
+    return env_names
+
+
+def wrap_with_secret_env(command, env_names):
+    """Wrap a command so it starts with secrets exported from their files"""
+    if not env_names:
+        return command
+    exports = "; ".join(f'export {name}="$(cat {SECRETS_DIR}/{name})"' for name in env_names)
+    return ["sh", "-c", f'{exports}; exec "$@"', "vibecon"] + command
+
+
+def wrap_with_terminal_size(command):
+    """Wrap a command so its terminal starts at the size of the host's.
+
+    docker exec -t sizes the container's terminal only after the process has
+    started, so full-screen programs could lay out for 80x24 first. Later
+    resizes reach the docker CLI as SIGWINCH, which passes them on.
+    """
+    try:
+        columns, lines = os.get_terminal_size(sys.stdout.fileno())
+    except OSError:
+        return command
+    return ["sh", "-c", f'stty cols {columns} rows {lines} 2>/dev/null; exec "$@"', "vibecon"] + command
+
+
+# Container path prefix of the files recording each exec's PID and exit code
+EXEC_STATE_PREFIX = "/tmp/vibecon-exec-"
+
+
+def exec_state_path():
+    """Container path prefix of the .pid and .status files of this vibecon process's command"""
+    return f"{EXEC_STATE_PREFIX}{os.getpid()}"
+
+
+def wrap_with_exec_state(command, state_path):
+    """Wrap a command so it records its PID, and its exit code once it is done.
+
+    The shell stays the command's parent to write the exit code. Signals
+    reach it along with the command (they share a process group), so it
+    traps them with a no-op instead of exiting first.
+    """
+    script = (f'echo $$ > {state_path}.pid; trap : INT TERM HUP; "$@"; '
+              f'status=$?; echo $status > {state_path}.status; exit $status')
+    return ["sh", "-c", script, "vibecon"] + command
+
+
+def exec_state(container_name, state_path):
+    """What became of the command: ("done", exit code), ("running", PID) or ("gone", None)"""
+    result = run_command(
+        ["docker", "exec", container_name, "sh", "-c",
+         f'if [ -f {state_path}.status ]; then echo done $(cat {state_path}.status); '
+         f'elif kill -0 "$(cat {state_path}.pid)" 2>/dev/null; then echo running $(cat {state_path}.pid); fi'],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    fields = result.stdout.split() if result.returncode == 0 else []
+    if len(fields) == 2 and fields[1].isdigit():
+        return fields[0], int(fields[1])
+    return "gone", None
+
+
+def clear_exec_state(container_name, state_path):
+    """Remove the command's .pid and .status files from the container.
+
+    Commands left running after a lost connection write their .status after
+    vibecon is gone; those are removed once they are an hour old.
+    """
+    state_dir, prefix = posixpath.split(EXEC_STATE_PREFIX)
+    run_command(
+        ["docker", "exec", container_name, "sh", "-c",
+         f"rm -f {state_path}.pid {state_path}.status; "
+         f"find {state_dir} -maxdepth 1 -name '{prefix}*.status' -mmin +60 "
+         "-exec sh -c 'rm -f \"$1\" \"${1%.status}.pid\"' vibecon {} \\;"],
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.DEVNULL
+    )
+
+
+# Signals passed on to the command in the container (SIGHUP doesn't exist on Windows)
+FORWARDED_SIGNALS = tuple(getattr(signal, name) for name in ("SIGINT", "SIGTERM", "SIGHUP") if hasattr(signal, name))
+
+
+def forward_signal(container_name, state_path, signum):
+    """Send a signal to the command's process group in the container.
+
+    Without a TTY the command doesn't lead a process group of its own, so
+    the wrapper's children get the signal instead.
+    """
+    name = signal.Signals(signum).name[3:]
+    run_command(
+        ["docker", "exec", container_name, "sh", "-c",
+         f'pid=$(cat {state_path}.pid) && {{ kill -{name} "-$pid" || pkill -{name} -P "$pid"; }} 2>/dev/null'],
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.DEVNULL
+    )
+
+
+@contextlib.contextmanager
+def forwarding_signals(container_name, state_path):
+    """Pass SIGINT, SIGTERM and SIGHUP received by vibecon on to the command in the container"""
+    def forward(signum, frame):
+        LOG.info("forwarding %s to the command in %s", signal.Signals(signum).name, container_name)
+        forward_signal(container_name, state_path, signum)
+
+    previous_handlers = {signum: signal.signal(signum, forward) for signum in FORWARDED_SIGNALS}
+    try:
+        yield
+    finally:
+        for signum, handler in previous_handlers.items():
+            signal.signal(signum, handler)
+
+
+def run_forwarding_signals(args, env, container_name, state_path, interactive):
+    """Run docker exec, passing SIGINT, SIGTERM and SIGHUP on to the command in the container.
+
+    docker exec doesn't forward signals, and a killed docker CLI leaves the
+    command running in the container. Without a TTY, the CLI runs in its own
+    session so Ctrl-C reaches only vibecon, which passes it on and waits for
+    the command to exit. With a TTY, Ctrl-C is a keystroke for the container's
+    terminal, so only signals sent to vibecon itself (e.g. by timeout) are
+    forwarded. Returns the command's exit code.
+    """
+    LOG.debug("%s", format_command(args))
+    process = subprocess.Popen(args, env=env, start_new_session=not interactive)
+    with forwarding_signals(container_name, state_path):
+        return process.wait()
+
+
+# Seconds between checks while waiting for a command vibecon lost the connection to
+LOST_EXEC_POLL_INTERVAL = 2
+
+# Exit code of docker exec failing itself, rather than passing on the command's
+DOCKER_EXEC_ERROR = 125
+
+
+def exec_connection_lost(returncode):
+    """Whether a failed docker exec may have lost its command instead of the command failing.
+
+    That's the case when docker exec itself failed, or the daemon can't be
+    reached anymore; a plain failing command costs one 'docker version'.
+    """
+    if returncode == DOCKER_EXEC_ERROR:
+        return True
+    result = run_command(
+        ["docker", "version", "--format", "{{.Server.Version}}"],
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.DEVNULL
+    )
+    return result.returncode != 0
+
+
+def recover_lost_exec(container_name, state_path, returncode, tmux_session=None):
+    """Handle a docker exec that failed while its command may still be running.
+
+    The docker CLI exits when its connection to the daemon drops (daemon
+    restart, Docker Desktop update), while the command can keep running in
+    the container. tmux sessions can be reattached; other commands can't, so
+    vibecon offers to wait for them, in scripts without asking. Returns the
+    command's exit code, or returncode when it isn't running anymore.
+    """
+    wait_for_docker()
+    state, value = exec_state(container_name, state_path)
+    if state == "done":
+        return value
+    if state == "gone":
+        return returncode
+
+    warn(f"Lost the connection to the container, but the command is still running in it (PID {value})")
+    interactive = sys.stdin.isatty()
+    if tmux_session:
+        if not interactive or not ask_yes(f"Reattach to tmux session '{tmux_session}'?"):
+            print("Reattach later with 'vibecon attach'")
+            return returncode
+        attach_tmux_session(container_name, tmux_session)
+        state, value = exec_state(container_name, state_path)
+        return value if state == "done" else 0
+    if interactive and not ask_yes("Its output can't be reattached. Wait for it to finish?"):
+        print("It keeps running; stop it with 'vibecon stop' (use --tmux for runs you want to reattach to)")
+        return returncode
+
+    print("Waiting for the command to finish...")
+    with forwarding_signals(container_name, state_path):
+        while state == "running":
+            time.sleep(LOST_EXEC_POLL_INTERVAL)
+            state, value = exec_state(container_name, state_path)
+    return value if state == "done" else returncode
+
+
+# tmux sessions run on their own server socket (tmux -L vibecon-{session}), so a
+# new session starts with the environment of the exec that created it
+TMUX_SOCKET_PREFIX = "vibecon-"
+
+
+def tmux_session_name(value, command):
+    """Session name from the 'tmux' config (true derives it from the command)"""
+    name = value if isinstance(value, str) else posixpath.basename(command[0])
+    # tmux reserves ':' and '.' in target names
+    return re.sub(r"[^A-Za-z0-9_-]", "-", name) or "vibecon"
+
+
+def wrap_with_tmux(command, session_name, workdir):
+    """Run the command in a tmux session, or attach to the session if it already exists"""
+    return [
+        "tmux", "-L", TMUX_SOCKET_PREFIX + session_name,
+        "new-session", "-A", "-s", session_name, "-c", workdir, "--",
+    ] + command
+
+
+def list_tmux_sessions(container_name):
+    """vibecon tmux sessions in the container as dicts, most recently active first"""
+    script = (
+        f'for s in /tmp/tmux-$(id -u)/{TMUX_SOCKET_PREFIX}*; do [ -S "$s" ] && '
+        "tmux -S \"$s\" list-sessions -F '#{session_name} #{session_activity} #{session_attached}' 2>/dev/null; done"
+    )
+    result = run_command(
+        ["docker", "exec", container_name, "sh", "-c", script],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    sessions = []
+    for line in result.stdout.splitlines():
+        fields = line.split()
+        if len(fields) == 3:
+            sessions.append({"name": fields[0], "activity": int(fields[1]), "attached": int(fields[2])})
+    return sorted(sessions, key=lambda session: session["activity"], reverse=True)
+
+
+def container_has_command(container_name, name):
+    """Check whether a command is available in the container"""
+    result = run_command(
+        ["docker", "exec", container_name, "sh", "-c", f"command -v {shlex.quote(name)}"],
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.DEVNULL
+    )
+    return result.returncode == 0
+
+
+def runtime_args(config):
+    """Build docker run arguments for an alternative OCI runtime"""
+    runtime = config.get("runtime")
+    return ["--runtime", runtime] if runtime else []
+
+
+# How to install the common alternative runtimes, by their usual names
+RUNTIME_INSTALL_HINTS = {
+    "runsc": "Install gVisor and register it with 'sudo runsc install' (https://gvisor.dev/docs/user_guide/install/)",
+    "kata": "Install Kata Containers and register it in /etc/docker/daemon.json (https://katacontainers.io)",
+    "kata-runtime": "Install Kata Containers and register it in /etc/docker/daemon.json (https://katacontainers.io)",
+    "nvidia": "Install the NVIDIA Container Toolkit and run 'sudo nvidia-ctk runtime configure --runtime=docker'",
+}
+
+
+def check_runtime_available(config):
+    """Fail early when the configured 'runtime' is not registered with the Docker daemon.
+
+    Podman resolves runtimes itself, so it is not checked there.
+    """
+    runtime = config.get("runtime")
+    if not runtime or get_engine_info()["podman"]:
+        return
+    result = run_command(
+        ["docker", "info", "--format", "{{json .Runtimes}}"],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    try:
+        runtimes = json.loads(result.stdout) if result.returncode == 0 else None
+    except json.JSONDecodeError:
+        runtimes = None
+    if not isinstance(runtimes, dict) or runtime in runtimes:
+        return
+    fail("config-invalid", f"OCI runtime '{runtime}' is not available (Docker has: {', '.join(sorted(runtimes)) or 'none'})",
+         RUNTIME_INSTALL_HINTS.get(runtime, "Register the runtime in /etc/docker/daemon.json and restart Docker"))
+
+def install_symlink(simulate_path_missing=False):
+    """Install symlink to ~/.local/bin/vibecon"""
+    # ANSI color codes, empty when colors are off
+    RESET, BOLD, RED, GREEN, YELLOW, BLUE, MAGENTA, CYAN = (
+        STYLES[name] if use_color() else ""
+        for name in ("reset", "bold", "red", "green", "yellow", "blue", "magenta", "cyan")
+    )
+
+    script_path = Path(__file__).resolve()
+    install_dir = Path.home() / ".local" / "bin"
+    symlink_path = install_dir / "vibecon"
+
+    # Create a display version with $HOME substitution
+    home_str = str(Path.home())
+    install_dir_str = str(install_dir)
+    if install_dir_str.startswith(home_str):
+        install_dir_display = "$HOME" + install_dir_str[len(home_str):]
+    else:
+        install_dir_display = install_dir_str
+
+    # Create install directory if it doesn't exist
+    install_dir.mkdir(parents=True, exist_ok=True)
+
+    # Check if symlink already exists and points to the correct target
+    already_installed = False
+    if symlink_path.is_symlink() and symlink_path.resolve() == script_path:
+        already_installed = True
+        print(f"{GREEN}{BOLD}Already installed:{RESET} {CYAN}{symlink_path}{RESET} -> {BLUE}{script_path}{RESET}")
+    else:
+        # Remove existing symlink if it exists but points elsewhere
+        if symlink_path.exists() or symlink_path.is_symlink():
+            symlink_path.unlink()
+
+        # Create symlink
+        symlink_path.symlink_to(script_path)
+        print(f"{GREEN}Installed:{RESET} {CYAN}{symlink_path}{RESET} -> {BLUE}{script_path}{RESET}")
+
+    # Check if install directory is in PATH
+    path_env = os.environ.get("PATH", "")
+    if simulate_path_missing or install_dir_str not in path_env.split(os.pathsep):
+        # Detect user's shell
+        shell_path = os.environ.get("SHELL", "")
+        shell_name = os.path.basename(shell_path) if shell_path else "unknown"
+
+        # Determine config file and export syntax based on shell
+        if shell_name == "zsh":
+            config_file = "~/.zshrc"
+            export_cmd = f'export PATH="{install_dir_display}:$PATH"'
+        elif shell_name == "bash":
+            config_file = "~/.bashrc"
+            export_cmd = f'export PATH="{install_dir_display}:$PATH"'
+        elif shell_name == "fish":
+            config_file = "~/.config/fish/config.fish"
+            export_cmd = f'set -gx PATH "{install_dir_display}" $PATH'
+        elif shell_name in ["tcsh", "csh"]:
+            config_file = "~/.cshrc"
+            export_cmd = f'setenv PATH "{install_dir_display}:$PATH"'
+        else:
+            config_file = "~/.profile"
+            export_cmd = f'export PATH="{install_dir_display}:$PATH"'
+
+        # Print large banner warning with colors
+        print(f"\n{RED}{BOLD}{'=' * 70}")
+        print(f"  ⚠️  WARNING: PATH CUSTOMIZATION REQUIRED")
+        print(f"{'=' * 70}{RESET}")
+        print(f"\n  {YELLOW}{BOLD}{install_dir_display}{RESET} {RED}{BOLD}is NOT in your PATH!{RESET}\n")
+        print(f"  You must add it to your PATH to use {CYAN}{BOLD}'vibecon'{RESET} by name.")
+        print(f"\n{BLUE}{'─' * 70}{RESET}")
+        print(f"  {MAGENTA}Detected shell:{RESET} {BOLD}{shell_name}{RESET}")
+        print(f"{BLUE}{'─' * 70}{RESET}")
+        print(f"\n  Add to PATH {GREEN}permanently{RESET}:")
+        print(f"    {GREEN}echo '{export_cmd}' >> {config_file}{RESET}")
+        print(f"    {GREEN}source {config_file}{RESET}")
+        print(f"\n{RED}{BOLD}{'=' * 70}{RESET}\n")
+    else:
+        print(f"\n{GREEN}{BOLD}✓{RESET} {GREEN}You can now use vibecon by its name:{RESET} {CYAN}{BOLD}vibecon{RESET}")
+
+def uninstall_symlink():
+    """Uninstall symlink from ~/.local/bin/vibecon"""
+    symlink_path = Path.home() / ".local" / "bin" / "vibecon"
+
+    if symlink_path.exists() or symlink_path.is_symlink():
+        symlink_path.unlink()
+        print(f"Uninstalled: {symlink_path}")
+    else:
+        print(f"Symlink not found: {symlink_path}")
+
+
+def init_config(target_path):
+    """Initialize .vibecon.json with root field in target directory.
+
+    Creates the file if it doesn't exist, or adds root field to existing file.
+    Fails if root field already exists.
+    """
+    target_dir = Path(target_path).resolve()
+    if not target_dir.is_dir():
+        fail(None, f"'{target_path}' is not a directory")
+
+    config_path = target_dir / ".vibecon.json"
+
+    if config_path.exists():
+        # Load existing config
+        try:
+            with open(config_path) as f:
+                config = json.load(f)
+        except json.JSONDecodeError as e:
+            fail("config-invalid", f"Invalid JSON in {config_path}: {e}")
+
+        # Check if root already exists
+        if "root" in config:
+            fail("config-invalid", f"'{config_path}' already has a 'root' field defined")
+
+        # Add root field
+        config["root"] = "/workspace"
+    else:
+        # Create new config
+        config = {"root": "/workspace"}
+
+    # Write config
+    with open(config_path, "w") as f:
+        json.dump(config, f, indent=2)
+        f.write("\n")
+
+    print(f"Initialized: {config_path}")
+    print('  Added: "root": "/workspace"')
+
+@functools.lru_cache(maxsize=None)
+def get_engine_info():
+    """Detect the container engine behind the docker CLI.
+
+    Returns dict with "podman" (Podman's docker-compatible CLI), "rootless"
+    (engine runs without root, so UID mapping differs from rootful Docker)
+    and "vm" (engine runs in a VM, like Docker Desktop, with its own clock).
+    """
+    result = run_command(
+        ["docker", "info", "--format", "{{json .}}"],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    try:
+        info = json.loads(result.stdout) if result.returncode == 0 else {}
+    except json.JSONDecodeError:
+        info = {}
+
+    # Engines on macOS and Windows always run in a VM; Docker Desktop does on Linux too
+    vm = not sys.platform.startswith("linux") or "Docker Desktop" in str(info.get("OperatingSystem", ""))
+
+    if "host" in info and "security" in info.get("host", {}):
+        # Podman reports its own info structure
+        return {"podman": True, "rootless": bool(info["host"]["security"].get("rootless")), "vm": vm}
+
+    security_options = info.get("SecurityOptions") or []
+    return {"podman": False, "rootless": any("rootless" in opt for opt in security_options), "vm": vm}
+
+# Starter configs for "vibecon init"; "$comment" fields document the choices
+INIT_TEMPLATES = {
+    "base": {
+        "$comment": "Project config for vibecon. See README.md for all options.",
+        "root": "/workspace",
+        "mounts": [],
+    },
+    "node": {
+        "$comment": "Node.js project: node_modules stays inside the container for speed.",
+        "root": "/workspace",
+        "mounts": [
+            {"$comment": "Isolated from the host's node_modules", "type": "anonymous", "target": "/workspace/node_modules"},
+            {"type": "volume", "source": "npm_cache", "target": "/home/node/.npm", "global": True},
+        ],
+    },
+    "go": {
+        "$comment": "Go project: module and build caches shared across projects.",
+        "root": "/workspace",
+        "mounts": [
+            {"type": "volume", "source": "go_mod_cache", "target": "/home/node/go/pkg/mod", "global": True},
+            {"type": "volume", "source": "go_build_cache", "target": "/home/node/.cache/go-build", "global": True},
+        ],
+    },
+    "python": {
+        "$comment": "Python project: the virtualenv lives in the container, pip cache is shared.",
+        "root": "/workspace",
+        "mounts": [
+            {"$comment": "Host and container Python differ, so keep .venv separate", "type": "anonymous", "target": "/workspace/.venv"},
+            {"type": "volume", "source": "pip_cache", "target": "/home/node/.cache/pip", "global": True},
+        ],
+    },
+    "fullstack": {
+        "$comment": "Node frontend + Python backend on a per-project network for sidecars.",
+        "root": "/workspace",
+        "network": "project",
+        "mounts": [
+            {"type": "anonymous", "target": "/workspace/frontend/node_modules"},
+            {"type": "anonymous", "target": "/workspace/backend/.venv"},
+            {"type": "volume", "source": "npm_cache", "target": "/home/node/.npm", "global": True},
+            {"type": "volume", "source": "pip_cache", "target": "/home/node/.cache/pip", "global": True},
+        ],
+    },
+    "global": {
+        "$comment": "Global vibecon config: mounts here apply to every project. No 'root' field.",
+        "mounts": [
+            {"type": "volume", "source": "npm_cache", "target": "/home/node/.npm", "global": True},
+        ],
+    },
+}
+
+
+def scaffold_config(config_path, template, force=False):
+    """Write a starter config from INIT_TEMPLATES. Returns exit code."""
+    if config_path.exists() and not force:
+        fail(None, f"'{config_path}' already exists (use --force to overwrite)")
+
+    config = {}
+    if template == "global":
+        # Local schema path is fine here; project configs are shared, so they don't get one
+        config["$schema"] = str(Path(__file__).resolve().parent / "vibecon.schema.json")
+    config.update(INIT_TEMPLATES[template])
+
+    with open(config_path, "w") as f:
+        json.dump(config, f, indent=2)
+        f.write("\n")
+
+    print(f"Created: {config_path} (template: {template})")
+    return 0
+
+
+def init_command(argv):
+    """vibecon init - write a starter .vibecon.json"""
+    parser = argparse.ArgumentParser(prog="vibecon init", description="Create a starter vibecon config")
+    parser.add_argument("path", nargs="?", default=".", help="project directory (default: current directory)")
+    parser.add_argument(
+        "-t", "--template",
+        choices=[name for name in INIT_TEMPLATES if name != "global"],
+        default="base",
+        help="starter template (default: base)"
+    )
+    parser.add_argument("-g", "--global", dest="global_config", action="store_true", help="create ~/.vibecon.json instead")
+    parser.add_argument("-f", "--force", action="store_true", help="overwrite an existing config file")
+    args = parser.parse_args(argv)
+
+    if args.global_config:
+        return scaffold_config(Path.home() / ".vibecon.json", "global", args.force)
+
+    target_dir = Path(args.path).resolve()
+    if not target_dir.is_dir():
+        fail(None, f"'{args.path}' is not a directory")
+    return scaffold_config(target_dir / ".vibecon.json", args.template, args.force)
+
+
+def is_container_running(container_name):
+    """Check if container is running"""
+    result = run_command(
+        ["docker", "inspect", "-f", "{{.State.Running}}", container_name],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    return result.returncode == 0 and result.stdout.strip() == "true"
+
+def container_exists(container_name):
+    """Check if container exists (in any state: running, stopped, dead, etc.)"""
+    result = run_command(
+        ["docker", "inspect", container_name],
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.DEVNULL
+    )
+    return result.returncode == 0
+
+def get_container_network_mode(container_name):
+    """Get the container's network mode ("bridge", "host", a network name...), or None"""
+    result = run_command(
+        ["docker", "inspect", "-f", "{{.HostConfig.NetworkMode}}", container_name],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    if result.returncode != 0:
+        return None
+    return result.stdout.strip() or None
+
+def get_container_health(container_name):
+    """Get container health status: "starting", "healthy", "unhealthy", or None if no healthcheck"""
+    result = run_command(
+        ["docker", "inspect", "-f", "{{if .State.Health}}{{.State.Health.Status}}{{end}}", container_name],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    if result.returncode != 0:
+        return None
+    return result.stdout.strip() or None
+
+def wait_for_healthy(container_name, timeout):
+    """Wait up to timeout seconds for the container to become healthy.
+
+    Returns "healthy" (also without a healthcheck), "unhealthy", or "starting"
+    if the healthcheck hasn't decided yet when the time is up.
+    """
+    deadline = time.monotonic() + timeout
+    announced = False
+    while True:
+        status = get_container_health(container_name)
+        if status is None or status == "healthy":
+            return "healthy"
+        if status == "unhealthy":
+            return "unhealthy"
+        if time.monotonic() >= deadline:
+            if timeout:
+                print(f"Timed out after {timeout}s waiting for container '{container_name}' to become healthy.")
+            return "starting"
+        if not announced:
+            print(f"Waiting for container '{container_name}' to become healthy...")
+            announced = True
+        time.sleep(1)
+
+def restart_container(container_name):
+    """Attempt to restart a stopped/dead container. Returns True if successful."""
+    print(f"Found stopped container '{container_name}', attempting to restart...")
+    result = run_docker(["docker", "start", container_name], stdout=subprocess.PIPE)
+    if result.returncode == 0:
+        success(f"Container '{container_name}' restarted successfully.")
+        return True
+    else:
+        print(f"Failed to restart container: {result.stderr.decode().strip()}")
+        return False
+
+def stop_container(container_name):
+    """Stop the container (can be restarted later)"""
+    print(f"Stopping container '{container_name}'...")
+    result = run_command(
+        ["docker", "stop", container_name],
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.DEVNULL
+    )
+    if result.returncode == 0:
+        success("Container stopped.")
+    else:
+        print("Container was not running.")
+    # Stop the docker-in-docker sidecar too, if there is one
+    run_command(
+        ["docker", "stop", dind_container_name(container_name)],
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.DEVNULL
+    )
+
+def remove_container(container_name):
+    """Force-remove the container and its anonymous volumes without any output"""
+    run_command(
+        ["docker", "rm", "-f", "-v", container_name],
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.DEVNULL
+    )
+
+def container_volumes(container_name):
+    """Named volumes created for the container by volume mounts ({container}_{name})"""
+    result = run_command(
+        ["docker", "volume", "ls", "-q"],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    return [name for name in result.stdout.split() if name.startswith(container_name + "_")]
+
+def destroy_container(container_name, keep_volumes=False):
+    """Destroy and remove the container permanently, with its volumes unless keep_volumes"""
+    print(f"Destroying container '{container_name}'...")
+    remove_container(container_name)
+    remove_container(dind_container_name(container_name))
+    compose_down(container_name, not keep_volumes)
+    # Per-workspace volumes (shell history, overlays) are named {container}-{name} and kept
+    if not keep_volumes:
+        volumes = container_volumes(container_name)
+        if volumes:
+            print(f"Removing volumes: {', '.join(volumes)}")
+            run_command(["docker", "volume", "rm"] + volumes, stdout=subprocess.DEVNULL)
+    # Remove the per-project network if one was created (fails harmlessly otherwise)
+    run_command(
+        ["docker", "network", "rm", project_network_name(container_name)],
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.DEVNULL
+    )
+    success("Container destroyed.")
+
+def ensure_network(network_name, subnet=None):
+    """Create a user-defined docker network if it doesn't exist yet, optionally with a subnet"""
+    if network_name in BUILTIN_NETWORKS:
+        return
+    result = run_command(
+        ["docker", "network", "inspect", network_name],
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.DEVNULL
+    )
+    if result.returncode == 0:
+        return
+    print(f"Creating network '{network_name}'...")
+    subnet_args = ["--subnet", subnet] if subnet else []
+    result = run_docker(["docker", "network", "create"] + subnet_args + [network_name], stdout=subprocess.DEVNULL, text=True)
+    if result.returncode != 0:
+        fail(docker_error_category(result.stderr), f"Failed to create network: {result.stderr.strip()}")
+
+def check_network_policy_enforceable(config):
+    """Exit if the agent could get root in the container or route around its netns.
+
+    The rules only hold while the agent is the unprivileged node user: rootless
+    Docker maps node to root, 'privileged' grants every capability, and a
+    Docker engine lets the agent start containers with their own networking.
+    """
+    engine = get_engine_info()
+    if engine["rootless"] and not engine["podman"]:
+        reason = "rootless Docker, where the agent runs as container root"
+    elif config.get("privileged", False):
+        reason = "'privileged'"
+    elif get_docker_access(config):
+        reason = "'docker_access', which lets the agent start containers outside the policy"
+    else:
+        return
+    fail("config-invalid", f"'network_policy' can't be enforced with {reason}",
+         "Drop 'network_policy' or the conflicting setting; with rootless Docker, use rootful Docker or Podman")
+
+
+def apply_network_policy(container_name, config):
+    """Apply the egress firewall from 'network_policy' inside the container.
+
+    Runs as root on every invocation so that domain IPs are refreshed. The
+    agent runs as the unprivileged node user and cannot modify the rules.
+    """
+    policy = get_network_policy(config)
+    if policy is None:
+        return
+    domains, cidrs = policy
+    print(f"Applying network policy ({len(domains)} domains, {len(cidrs)} CIDRs)...")
+    result = run_command(
+        ["docker", "exec", "-i", "-u", "root", container_name, "sh", "-s"],
+        input=network_policy_script(domains, cidrs),
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.PIPE,
+        text=True
+    )
+    if result.returncode != 0:
+        fail(None, f"Failed to apply network policy: {result.stderr.strip()}",
+             "If network_policy was added after the container was created, recreate it with 'vibecon -K'")
+
+def ensure_dind_sidecar(container_name, network_name, config):
+    """Start the rootless docker-in-docker sidecar if it isn't running.
+
+    The sidecar is reachable from the workspace container as "docker" on the
+    shared network and keeps its images in a per-project volume. Its logs
+    follow the container's 'logging' setting.
+    """
+    sidecar_name = dind_container_name(container_name)
+    if is_container_running(sidecar_name):
+        return
+    if container_exists(sidecar_name):
+        if restart_container(sidecar_name):
+            return
+        remove_container(sidecar_name)
+
+    print(f"Starting docker-in-docker sidecar '{sidecar_name}'...")
+    result = run_command(
+        [
+            "docker", "run", "-d",
+            "--name", sidecar_name,
+            # Rootless dind still needs privileged for its user namespace setup
+            "--privileged",
+            "--network", network_name,
+            "--network-alias", "docker",
+            "-e", "DOCKER_TLS_CERTDIR=",
+            "-v", f"{container_name}_dind:/home/rootless/.local/share/docker",
+            *logging_args(config),
+            DIND_IMAGE,
+        ],
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.PIPE,
+        text=True
+    )
+    if result.returncode != 0:
+        fail(docker_error_category(result.stderr), f"Failed to start docker-in-docker sidecar: {result.stderr.strip()}")
+
+def find_vibecon_root():
+    """Find the vibecon root directory (parent of vibecon.py where Dockerfile is)"""
+    # Resolve symlink to find actual script location
+    script_path = Path(__file__).resolve()
+    script_dir = script_path.parent
+    dockerfile_path = script_dir / "Dockerfile"
+
+    if dockerfile_path.exists():
+        return str(script_dir)
+    return None
+
+def generate_container_name(workspace_path, profile_names=None):
+    """Generate container name based on workspace path
+
+    Explicitly selected profiles get their own container, since they may
+    change how the container is created.
+    """
+    # Create full hash from the workspace path
+    path_hash = hashlib.md5(workspace_path.encode()).hexdigest()[:8]
+
+    # Sanitize the path for use in container name
+    # Remove leading slash and replace special chars with hyphens
+    sanitized_path = workspace_path.lstrip('/').replace('/', '-').replace('_', '-').lower()
+
+    container_name = f"vibecon-{path_hash}-{sanitized_path}"
+    if profile_names:
+        container_name += "--" + "-".join(profile_names).lower()
+    return container_name
+
+def image_exists(image_name):
+    """Check if Docker image exists"""
+    result = run_docker(["docker", "image", "inspect", image_name], stdout=subprocess.DEVNULL, text=True)
+    if result.returncode != 0:
+        # Only return False if it's actually "not found", not other errors
+        if "no such image" in result.stderr.lower():
+            return False
+        # Some other error occurred - print it and exit
+        fail(docker_error_category(result.stderr), f"Could not check image '{image_name}': {result.stderr.strip()}")
+    return True
+
+def pull_image(image_name):
+    """Pull a Docker image, exit if it fails"""
+    print(f"Image '{image_name}' not found, pulling...")
+    result = run_docker(["docker", "pull", image_name], timeout=DOCKER_RETRY["pull_timeout"], text=True)
+    if result.returncode != 0:
+        fail("image-missing", f"Failed to pull image '{image_name}': {result.stderr.strip()}")
+
+async def get_npm_package_version_async(package_name, short_name):
+    """Get the latest version of an npm package asynchronously"""
+    proc = await asyncio.create_subprocess_exec(
+        "npm", "view", package_name, "version",
+        stdout=asyncio.subprocess.PIPE,
+        stderr=asyncio.subprocess.PIPE
+    )
+    stdout, stderr = await proc.communicate()
+    if proc.returncode == 0:
+        return stdout.decode().strip()
+    else:
+        warn(f"Failed to get {short_name} version from npm")
+        return None
+
+
+async def get_go_version_async():
+    """Get the latest stable Go version from golang.org"""
+    proc = await asyncio.create_subprocess_exec(
+        "curl", "-s", "https://go.dev/dl/?mode=json",
+        stdout=asyncio.subprocess.PIPE,
+        stderr=asyncio.subprocess.PIPE
+    )
+    stdout, stderr = await proc.communicate()
+    if proc.returncode == 0:
+        try:
+            releases = json.loads(stdout.decode())
+            # First stable release in the list
+            for release in releases:
+                if release.get("stable", False):
+                    # Version is like "go1.24.2", strip the "go" prefix
+                    return release["version"].lstrip("go")
+        except (json.JSONDecodeError, KeyError, IndexError):
+            pass
+    warn("Failed to get Go version from golang.org")
+    return None
+
+
+async def get_json_version_async(url, field, short_name):
+    """Get a version from a JSON API: PyPI (info.version) or GitHub releases (tag_name)"""
+    proc = await asyncio.create_subprocess_exec(
+        "curl", "-sfL", url,
+        stdout=asyncio.subprocess.PIPE,
+        stderr=asyncio.subprocess.PIPE
+    )
+    stdout, stderr = await proc.communicate()
+    if proc.returncode == 0:
+        try:
+            value = json.loads(stdout.decode())
+            for key in field.split("."):
+                value = value[key]
+            return value
+        except (json.JSONDecodeError, KeyError, TypeError):
+            pass
+    warn(f"Failed to get {short_name} version")
+    return None
+
+
+# Agents the image can contain, selected by 'tools' in the global config.
+# Versioned ones: (short name in the composite tag, Dockerfile build arg,
+# display name, coroutine factory fetching the latest version). Claude Code
+# updates itself, so its version isn't tracked.
+TOOLS = {
+    "claude": None,
+    "gemini": ("g", "GEMINI_CLI_VERSION", "Gemini CLI",
+               lambda: get_npm_package_version_async("@google/gemini-cli", "g")),
+    "codex": ("oac", "OPENAI_CODEX_VERSION", "OpenAI Codex",
+              lambda: get_npm_package_version_async("@openai/codex", "oac")),
+    "opencode": ("oc", "OPENCODE_VERSION", "opencode",
+                 lambda: get_npm_package_version_async("opencode-ai", "oc")),
+    "aider": ("ai", "AIDER_VERSION", "Aider",
+              lambda: get_json_version_async("https://pypi.org/pypi/aider-chat/json", "info.version", "ai")),
+    "goose": ("gs", "GOOSE_VERSION", "Goose",
+              lambda: get_json_version_async("https://api.github.com/repos/block/goose/releases/latest", "tag_name", "gs")),
+}
+DEFAULT_TOOLS = ["claude", "gemini", "codex"]
+GO_FALLBACK_VERSION = "1.24.2"
+
+
+def get_tools(config):
+    """Agents to install in the image, from 'tools' (default: claude, gemini, codex)"""
+    tools = config.get("tools", DEFAULT_TOOLS)
+    unknown = [tool for tool in tools if tool not in TOOLS]
+    if unknown:
+        fail("config-invalid", f"unknown tool '{unknown[0]}' in 'tools' (available: {', '.join(TOOLS)})")
+    return tools
+
+
+def get_all_versions(tools=None):
+    """Get the latest versions of the selected tools and Go concurrently"""
+    print("Checking latest versions...")
+
+    packages = [TOOLS[tool] for tool in (tools or DEFAULT_TOOLS) if TOOLS[tool]]
+
+    async def fetch_all():
+        tool_tasks = [fetch() for _, _, _, fetch in packages]
+        go_task = get_go_version_async()
+        return await asyncio.gather(*(tool_tasks + [go_task]))
+
+    results = asyncio.run(fetch_all())
+    tool_results = results[:-1]
+    go_result = results[-1]
+
+    versions = {}
+    for (short_name, _, display_name, _), version in zip(packages, tool_results):
+        if version:
+            versions[short_name] = version
+            print(f"  {display_name}: {version}")
+        else:
+            versions[short_name] = "latest"
+            print(f"  {display_name}: latest (failed to fetch)")
+
+    # Go version
+    if go_result:
+        versions["go"] = go_result
+        print(f"  Go: {go_result}")
+    else:
+        versions["go"] = GO_FALLBACK_VERSION
+        print(f"  Go: {GO_FALLBACK_VERSION} (failed to fetch, using fallback)")
+
+    return versions
+
+
+def make_composite_tag(versions, tools):
+    """Create composite tag from the selected tools and versions: cl_g{ver}_oac{ver}_go{ver}.
+
+    Unversioned tools (claude) appear by name, so changing 'tools' always changes the tag.
+    """
+    parts = [tool[:2] for tool in TOOLS if tool in tools and not TOOLS[tool]]
+    parts.extend(f"{TOOLS[tool][0]}{versions[TOOLS[tool][0]]}" for tool in TOOLS if tool in tools and TOOLS[tool])
+    return "_".join(parts + [f"go{versions['go']}"])
+
+def get_host_timezone():
+    """Get the host system timezone"""
+    # First, try the TZ environment variable
+    tz = os.environ.get("TZ")
+    if tz:
+        return tz
+
+    # Try reading /etc/timezone (common on Debian/Ubuntu)
+    try:
+        with open("/etc/timezone", "r") as f:
+            tz = f.read().strip()
+            if tz:
+                return tz
+    except (FileNotFoundError, PermissionError):
+        pass
+
+    # Try to get timezone from timedatectl (systemd-based systems)
+    try:
+        result = run_command(
+            ["timedatectl", "show", "-p", "Timezone", "--value"],
+            stdout=subprocess.PIPE,
+            stderr=subprocess.DEVNULL,
+            text=True
+        )
+        if result.returncode == 0:
+            tz = result.stdout.strip()
+            if tz:
+                return tz
+    except FileNotFoundError:
+        pass
+
+    # Fallback: try to determine from /etc/localtime symlink
+    try:
+        localtime_path = Path("/etc/localtime")
+        if localtime_path.is_symlink():
+            target = localtime_path.resolve()
+            # Extract timezone from path like /usr/share/zoneinfo/America/New_York
+            parts = target.parts
+            if "zoneinfo" in parts:
+                zoneinfo_idx = parts.index("zoneinfo")
+                if len(parts) > zoneinfo_idx + 1:
+                    tz = "/".join(parts[zoneinfo_idx + 1:])
+                    return tz
+    except (FileNotFoundError, PermissionError):
+        pass
+
+    # If all else fails, return UTC as default
+    return "UTC"
+
+
+# Seconds the container clock may differ from the host's before it is resynced
+CLOCK_SKEW_TOLERANCE = 5
+
+
+def check_clock(container_name, config, image_name):
+    """Resync the engine VM's clock with the host when the container's is off.
+
+    VMs like Docker Desktop's drift after the host sleeps, and token
+    validation (OAuth logins, signed URLs) in the container then fails.
+    Containers share the VM's clock, so a short-lived container with
+    CAP_SYS_TIME sets it for all of them. Native Linux engines use the
+    host's clock and are not checked. Also warns when the container has no
+    zoneinfo for the configured timezone.
+    """
+    if not config.get("clock_sync", True) or not get_engine_info()["vm"]:
+        return
+    timezone = get_timezone(config)
+    before = time.time()
+    result = run_command(
+        ["docker", "exec", "-e", f"TZ={timezone}", container_name, "sh", "-c",
+         f"date +%s.%N; test -f /usr/share/zoneinfo/{shlex.quote(timezone)} && echo zoneinfo"],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    after = time.time()
+    lines = result.stdout.split()
+    try:
+        container_time = float(lines[0])
+    except (IndexError, ValueError):
+        return
+    if "zoneinfo" not in lines and timezone != "UTC":
+        warn(f"The container has no timezone data for '{timezone}', so it uses UTC; set 'timezone' or install tzdata in the image")
+
+    # The container read its clock somewhere during the round trip
+    skew = container_time - (before + after) / 2
+    if abs(skew) <= CLOCK_SKEW_TOLERANCE + (after - before) / 2:
+        return
+    print(f"Container clock is {abs(skew):.0f}s {'ahead of' if skew > 0 else 'behind'} the host, resyncing...")
+    result = run_command(
+        ["docker", "run", "--rm", "--cap-add", "SYS_TIME", "--network", "none", "--user", "root",
+         "--entrypoint", "date", image_name, "-u", "-s", f"@{time.time():.3f}"],
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.PIPE,
+        text=True
+    )
+    if result.returncode != 0:
+        warn(f"Could not resync the clock: {result.stderr.strip()}. Restarting Docker resyncs it too")
+
+
+def get_timezone(config):
+    """The 'timezone' config, by default the host's timezone"""
+    return config.get("timezone") or get_host_timezone()
+
+
+def get_locale(config):
+    """The 'locale' config, by default the host's LC_ALL or LANG, falling back to C.UTF-8"""
+    return config.get("locale") or os.environ.get("LC_ALL") or os.environ.get("LANG") or "C.UTF-8"
+
+
+def locale_env(config):
+    """LANG and LC_ALL for the container, set at docker run and every docker exec"""
+    locale = get_locale(config)
+    return {"LANG": locale, "LC_ALL": locale}
+
+
+def ensure_locale(container_name, locale):
+    """Compile a locale in the container, which only has C.UTF-8 built in.
+
+    Needs the locale sources from the 'locales' package, which the vibecon
+    image has; other images without it get a warning.
+    """
+    if locale in ("C", "POSIX") or locale.startswith("C."):
+        return
+    # language_TERRITORY.charset@modifier is built from the language_TERRITORY@modifier source
+    name, _, rest = locale.partition(".")
+    charset, _, modifier = rest.partition("@")
+    source = f"{name}@{modifier}" if modifier else name
+    result = run_command(
+        ["docker", "exec", "-u", "root", container_name,
+         "localedef", "-i", source, "-f", charset or "UTF-8", locale],
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.PIPE,
+        text=True
+    )
+    if result.returncode != 0:
+        warn(f"Locale '{locale}' is not available in the container: {result.stderr.strip() or 'localedef failed'}. "
+             "Set 'locale' to one the image supports, e.g. \"C.UTF-8\"")
+
+def get_git_user_info():
+    """Get git user.name and user.email from host"""
+    user_name = ""
+    user_email = ""
+
+    # Get git user.name
+    result = run_command(
+        ["git", "config", "--global", "user.name"],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    if result.returncode == 0:
+        user_name = result.stdout.strip()
+
+    # Get git user.email
+    result = run_command(
+        ["git", "config", "--global", "user.email"],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    if result.returncode == 0:
+        user_email = result.stdout.strip()
+
+    return user_name, user_email
+
+def build_image(vibecon_root, image_name, versions=None, config=None):
+    """Build the Docker image with the selected AI CLI tools and Go"""
+    if config is None:
+        config = {}
+    tools = get_tools(config)
+    if versions is None:
+        versions = {TOOLS[tool][0]: "latest" for tool in tools if TOOLS[tool]}
+        versions["go"] = GO_FALLBACK_VERSION
+
+    composite_tag = make_composite_tag(versions, tools)
+    print(f"Building image with composite tag: {composite_tag}")
+
+    # Build command with all version build args
+    build_cmd = [
+        "docker", "build",
+        "--build-arg", f"TOOLS={' '.join(tools)}",
+    ]
+    for tool in tools:
+        if TOOLS[tool]:
+            short_name, build_arg, _, _ = TOOLS[tool]
+            build_cmd.extend(["--build-arg", f"{build_arg}={versions.get(short_name, 'latest')}"])
+    build_cmd.extend([
+        "--build-arg", f"GO_VERSION={versions['go']}",
+        "-t", image_name,
+        "-t", f"vibecon:{composite_tag}"
+    ])
+
+    # Proxy variables are predefined build args in Docker, no ARG needed
+    for key, value in get_proxy_env(config).items():
+        build_cmd.extend(["--build-arg", f"{key}={value}"])
+
+    print(f"Tagging as: {image_name} and vibecon:{composite_tag}")
+
+    build_cmd.append(".")
+
+    build_result = run_command(build_cmd, cwd=vibecon_root)
+    if build_result.returncode != 0:
+        fail("image-missing", "Failed to build image")
+
+    return composite_tag
+
+# settings.json keys synced unless 'claude_settings' lists others
+DEFAULT_CLAUDE_SETTINGS_KEYS = ["statusLine", "hooks"]
+
+# ~/.claude directories copied into the container as a whole
+CLAUDE_SYNCED_DIRS = ("commands", "agents", "output-styles", "hooks")
+
+# 'claude_config_mode': copy parts of ~/.claude before every exec, or bind-mount it
+CLAUDE_CONFIG_MODES = ("copy", "mount", "mount:ro")
+
+
+def get_claude_config_mode(config):
+    """Return 'copy', 'mount' or 'mount:ro' from the 'claude_config_mode' config"""
+    mode = config.get("claude_config_mode", "copy")
+    if mode not in CLAUDE_CONFIG_MODES:
+        fail("config-invalid", f"'claude_config_mode' must be one of {', '.join(CLAUDE_CONFIG_MODES)}, got: {mode}")
+    if mode != "copy" and "claude" in get_shared_auth_agents(config):
+        fail("config-invalid", "'claude_config_mode' mount can't be combined with 'shared_auth' for claude",
+             "Both put something at ~/.claude; the mount already shares the host's state with every container")
+    return mode
+
+
+def claude_config_mount_args(config):
+    """Build docker run arguments bind-mounting the host's ~/.claude in mount mode"""
+    mode = get_claude_config_mode(config)
+    if mode == "copy":
+        return []
+    suffix = ":ro" if mode == "mount:ro" else ""
+    claude_dir = Path.home() / ".claude"
+    return ["-v", f"{claude_dir}:{CONTAINER_HOME}/.claude{suffix}"] + hook_script_mount_args(claude_dir)
+
+
+def hook_script_mount_args(claude_dir):
+    """Bind-mount scripts named by hook and statusLine commands in mount mode.
+
+    The mounted settings.json can't be rewritten like in copy mode, so each
+    script is mounted where the unchanged command finds it: absolute paths at
+    the same path, ~ and $HOME paths under the container's home directory
+    (those in ~/.claude already are).
+    """
+    try:
+        with open(claude_dir / "settings.json") as f:
+            settings = json.load(f)
+    except (json.JSONDecodeError, IOError):
+        return []
+    commands = [settings.get("statusLine", {}).get("command", "")]
+    for matchers in settings.get("hooks", {}).values():
+        for matcher in matchers:
+            commands.extend(hook.get("command", "") for hook in matcher.get("hooks", []) if hook.get("type") == "command")
+
+    targets = {}
+    for command in commands:
+        try:
+            tokens = shlex.split(command)
+        except ValueError:
+            continue
+        for token in tokens:
+            path = Path(os.path.expandvars(os.path.expanduser(token)))
+            if not path.is_absolute() or not path.is_file():
+                continue
+            if token.startswith("/"):
+                targets[path.as_posix()] = path
+            elif path.is_relative_to(Path.home()) and not path.is_relative_to(claude_dir):
+                targets[f"{CONTAINER_HOME}/{path.relative_to(Path.home()).as_posix()}"] = path
+    args = []
+    for target, path in sorted(targets.items()):
+        args.extend(["-v", f"{path}:{target}:ro"])
+    return args
+
+
+def sync_hook_commands(hooks, claude_dir, files_to_copy):
+    """Rewrite hook commands to reference container copies of their scripts.
+
+    Scripts inside a synced ~/.claude directory keep their relative path; any
+    other existing file named in a command is added to files_to_copy and
+    referenced as ~/.claude/<name>. Returns the rewritten hooks section.
+    """
+    def rewrite(command):
+        try:
+            tokens = shlex.split(command)
+        except ValueError:
+            return command
+        for token in tokens:
+            path = Path(os.path.expandvars(os.path.expanduser(token)))
+            if not path.is_absolute() or not path.is_file():
+                continue
+            try:
+                relative = path.relative_to(claude_dir)
+            except ValueError:
+                relative = None
+            if relative is None or relative.parts[0] not in CLAUDE_SYNCED_DIRS:
+                files_to_copy.append(path)
+                relative = Path(path.name)
+            command = command.replace(token, f"~/.claude/{relative.as_posix()}")
+        return command
+
+    result = {}
+    for event, matchers in hooks.items():
+        result[event] = []
+        for matcher in matchers:
+            matcher = dict(matcher)
+            matcher["hooks"] = [
+                {**hook, "command": rewrite(hook["command"])} if hook.get("type") == "command" and "command" in hook else hook
+                for hook in matcher.get("hooks", [])
+            ]
+            result[event].append(matcher)
+    return result
+
+
+SYNC_MARKER = ".vibecon-sync"
+
+
+def claude_config_digest(container_settings, files_to_copy, claude_dir):
+    """Hash everything sync_claude_config would copy into the container"""
+    hasher = hashlib.sha256()
+    hasher.update(json.dumps(container_settings, sort_keys=True).encode())
+    sources = [(src.name, src) for src in files_to_copy]
+    sources.append(("CLAUDE.md", claude_dir / "CLAUDE.md"))
+    for dir_name in CLAUDE_SYNCED_DIRS:
+        source_dir = claude_dir / dir_name
+        if source_dir.is_dir():
+            for path in sorted(source_dir.resolve().rglob("*")):
+                sources.append((f"{dir_name}/{path.relative_to(source_dir.resolve()).as_posix()}", path))
+    for name, path in sources:
+        hasher.update(name.encode() + b"\0")
+        if path.is_symlink():
+            hasher.update(b"link:" + os.readlink(path).encode())
+        elif path.is_file():
+            hasher.update(oct(path.stat().st_mode).encode() + hashlib.sha256(path.read_bytes()).digest())
+        hasher.update(b"\0")
+    return hasher.hexdigest()
+
+
+def sync_claude_config(container_name, config):
+    """Sync Claude config to container: selected settings.json keys + referenced files + CLAUDE.md + directories"""
+    if get_claude_config_mode(config) != "copy":
+        return  # The container sees the host's ~/.claude itself
+    settings_keys = config.get("claude_settings", DEFAULT_CLAUDE_SETTINGS_KEYS)
+    claude_dir = Path.home() / ".claude"
+    container_claude_dir = "/home/node/.claude"
+    settings_file = claude_dir / "settings.json"
+    claude_md_file = claude_dir / "CLAUDE.md"
+
+    # Track files to copy and whether we need to do anything
+    files_to_copy = []
+    container_settings = {}
+
+    # Parse settings.json if it exists
+    if settings_file.exists():
+        try:
+            with open(settings_file, "r") as f:
+                settings = json.load(f)
+
+            # Copy the selected top-level keys as they are
+            for key in settings_keys:
+                if key in settings and key not in ("statusLine", "hooks"):
+                    container_settings[key] = settings[key]
+
+            # Extract statusLine section if present
+            if "statusLine" in settings and "statusLine" in settings_keys:
+                container_settings["statusLine"] = settings["statusLine"]
+
+                # If statusLine has a command, add that file to copy list
+                if "command" in settings["statusLine"]:
+                    cmd_path = settings["statusLine"]["command"]
+                    # Expand ~ to home directory
+                    if cmd_path.startswith("~"):
+                        cmd_path = str(Path.home()) + cmd_path[1:]
+                    cmd_file = Path(cmd_path)
+                    if cmd_file.exists():
+                        files_to_copy.append(cmd_file)
+
+            # Copy scripts referenced by hooks and point the hooks at the copies
+            if "hooks" in settings and "hooks" in settings_keys:
+                container_settings["hooks"] = sync_hook_commands(settings["hooks"], claude_dir, files_to_copy)
+
+        except (json.JSONDecodeError, IOError) as e:
+            warn(f"Failed to parse settings.json: {e}")
+
+    # Skip everything if the host side hasn't changed since the last sync
+    digest = claude_config_digest(container_settings, files_to_copy, claude_dir)
+    marker = f"{container_claude_dir}/{SYNC_MARKER}"
+    result = run_command(
+        ["docker", "exec", container_name, "cat", marker],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    if result.returncode == 0 and result.stdout.strip() == digest:
+        return
+
+    # Stage everything in one directory so it goes over in a single tar stream
+    with tempfile.TemporaryDirectory() as tmpdir:
+        tmpdir_path = Path(tmpdir)
+
+        # CLAUDE.md and referenced files go to the top level, by file name
+        if claude_md_file.exists():
+            files_to_copy.append(claude_md_file)
+        for src_file in files_to_copy:
+            dest = tmpdir_path / src_file.name
+            dest.write_bytes(src_file.read_bytes())
+            # Preserve executable bit
+            if os.access(src_file, os.X_OK):
+                dest.chmod(dest.stat().st_mode | 0o111)
+
+        # Directories may be symlinks; their contents are copied as they are
+        for dir_name in CLAUDE_SYNCED_DIRS:
+            source_dir = claude_dir / dir_name
+            if source_dir.exists() and source_dir.resolve().is_dir():
+                shutil.copytree(source_dir.resolve(), tmpdir_path / dir_name, symlinks=True)
+
+        if container_settings:
+            (tmpdir_path / "settings.json").write_text(json.dumps(container_settings, indent=2) + "\n")
+
+        # Replace directories and CLAUDE.md so removed ones disappear
+        stale = " ".join(shlex.quote(name) for name in CLAUDE_SYNCED_DIRS + ("CLAUDE.md",))
+        run_command(
+            ["docker", "exec", "-u", "root", container_name, "sh", "-c",
+             f"mkdir -p {container_claude_dir} && cd {container_claude_dir} && rm -rf {stale}"],
+            stdout=subprocess.DEVNULL,
+            stderr=subprocess.DEVNULL
+        )
+        error = docker_cp_dir(container_name, tmpdir_path, container_claude_dir)
+        if error:
+            warn(f"Failed to sync Claude config: {error}")
+            return
+
+    # Fix ownership for node (whatever UID it was mapped to) and record the digest
+    result = run_command(
+        ["docker", "exec", "-u", "root", container_name, "sh", "-c",
+         f"chown -R node:node {container_claude_dir} && echo {digest} > {marker}"],
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.PIPE,
+        text=True
+    )
+    if result.returncode != 0:
+        warn(f"Failed to fix ownership of {container_claude_dir}: {result.stderr.strip()}")
+
+
+# Container paths written by the host config syncs of this run, for --report
+SYNCED_FILES = []
+
+
+def record_synced(path):
+    SYNCED_FILES.append(path)
+
+
+def docker_cp_dir(container_name, source_dir, target_dir):
+    """Copy the contents of a host directory into an existing container directory.
+
+    The tar stream is built in-process and unpacked by 'docker cp', so neither
+    the host nor the image needs a tar binary. Permissions and symlinks are
+    kept; files end up owned by root. Returns an error message or None.
+    """
+    buffer = io.BytesIO()
+    with tarfile.open(fileobj=buffer, mode="w") as tar:
+        for entry in sorted(Path(source_dir).iterdir()):
+            tar.add(entry, arcname=entry.name)
+    result = run_command(
+        ["docker", "cp", "-", f"{container_name}:{target_dir}"],
+        input=buffer.getvalue(),
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.PIPE
+    )
+    if result.returncode != 0:
+        return result.stderr.decode().strip()
+    for path in sorted(Path(source_dir).rglob("*")):
+        if not path.is_dir():
+            record_synced(posixpath.join(target_dir, path.relative_to(source_dir).as_posix()))
+    return None
+
+
+def copy_dir_to_container(container_name, source_dir, target_dir):
+    """Replace target_dir in the container with a copy of a host directory"""
+    run_command(
+        ["docker", "exec", "-u", "root", container_name, "sh", "-c",
+         f"rm -rf {shlex.quote(target_dir)} && mkdir -p {shlex.quote(target_dir)}"],
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.DEVNULL
+    )
+    error = docker_cp_dir(container_name, source_dir, target_dir)
+    if error:
+        warn(f"Failed to copy {source_dir}: {error}")
+        return False
+    run_command(
+        ["docker", "exec", "-u", "root", container_name, "chown", "-R", "node:node", target_dir],
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.DEVNULL
+    )
+    return True
+
+
+# Cloud CLI credential directories, relative to the home directory
+CLOUD_CREDENTIAL_DIRS = {
+    "aws": ".aws",
+    "gcp": ".config/gcloud",
+    "azure": ".azure",
+}
+CLOUD_CREDENTIAL_MODES = ("mount", "sync")
+
+
+def get_cloud_credentials(config):
+    """Return {provider: {"mode": ..., "refresh": ...}} from the 'cloud_credentials' config.
+
+    Each provider may be true (read-only mount), "mount", "sync" or an object
+    {"mode": "mount"|"sync", "refresh": bool}. Providers whose host directory
+    doesn't exist are skipped.
+    """
+    result = {}
+    for provider, value in config.get("cloud_credentials", {}).items():
+        if value is False:
+            continue
+        if value is True:
+            value = {}
+        elif isinstance(value, str):
+            value = {"mode": value}
+        mode = value.get("mode", "mount")
+        if mode not in CLOUD_CREDENTIAL_MODES:
+            fail("config-invalid", f"cloud_credentials.{provider}.mode must be one of {', '.join(CLOUD_CREDENTIAL_MODES)}, got: {mode}")
+        if not (Path.home() / CLOUD_CREDENTIAL_DIRS[provider]).is_dir():
+            continue
+        result[provider] = {"mode": mode, "refresh": value.get("refresh", False)}
+    return result
+
+
+def cloud_credential_mount_args(config):
+    """Build docker run arguments for read-only cloud credential mounts"""
+    args = []
+    for provider, settings in get_cloud_credentials(config).items():
+        if settings["mode"] == "mount":
+            rel_path = CLOUD_CREDENTIAL_DIRS[provider]
+            args.extend(["-v", f"{Path.home() / rel_path}:{CONTAINER_HOME}/{rel_path}:ro"])
+    return args
+
+
+def sync_cloud_credentials(container_name, config):
+    """Copy cloud credential directories in sync mode into the container"""
+    for provider, settings in get_cloud_credentials(config).items():
+        if settings["mode"] == "sync":
+            rel_path = CLOUD_CREDENTIAL_DIRS[provider]
+            copy_dir_to_container(container_name, Path.home() / rel_path, f"{CONTAINER_HOME}/{rel_path}")
+
+
+def cloud_token_env(config):
+    """Mint short-lived tokens on the host for providers with refresh enabled.
+
+    Returns env vars for the exec; failures only warn so an expired host login
+    doesn't block the session.
+    """
+    env = {}
+    for provider, settings in get_cloud_credentials(config).items():
+        if not settings["refresh"]:
+            continue
+        if provider == "aws":
+            result = run_command(
+                ["aws", "configure", "export-credentials", "--format", "process"],
+                stdout=subprocess.PIPE, stderr=subprocess.PIPE, text=True
+            ) if shutil.which("aws") else None
+            if result and result.returncode == 0:
+                creds = json.loads(result.stdout)
+                env["AWS_ACCESS_KEY_ID"] = creds["AccessKeyId"]
+                env["AWS_SECRET_ACCESS_KEY"] = creds["SecretAccessKey"]
+                if creds.get("SessionToken"):
+                    env["AWS_SESSION_TOKEN"] = creds["SessionToken"]
+                continue
+        elif provider == "gcp":
+            result = run_command(
+                ["gcloud", "auth", "print-access-token"],
+                stdout=subprocess.PIPE, stderr=subprocess.PIPE, text=True
+            ) if shutil.which("gcloud") else None
+            if result and result.returncode == 0:
+                token = result.stdout.strip()
+                env["CLOUDSDK_AUTH_ACCESS_TOKEN"] = token
+                env["GOOGLE_OAUTH_ACCESS_TOKEN"] = token
+                continue
+        else:
+            warn(f"token refresh is not supported for '{provider}', use sync mode instead")
+            continue
+        warn(f"Failed to refresh {provider} credentials on the host")
+    return env
+
+
+# Host git settings that point at host-only programs or are set up by
+# git_credentials / git_signing instead
+GITCONFIG_SKIPPED_PREFIXES = (
+    "include.", "includeif.", "credential.", "gpg.", "user.signingkey",
+    "commit.gpgsign", "tag.gpgsign", "core.sshcommand",
+)
+# Settings naming files that are copied along, to ~/.config/git/<name>
+GITCONFIG_FILE_KEYS = {
+    "core.excludesfile": "ignore",
+    "core.attributesfile": "attributes",
+    "commit.template": "commit-template",
+}
+
+
+def gitconfig_quote(value):
+    """Quote a value for a git config file"""
+    return '"' + value.replace("\\", "\\\\").replace('"', '\\"').replace("\n", "\\n").replace("\t", "\\t") + '"'
+
+
+def render_gitconfig(entries):
+    """Render (key, value) pairs as git config file text, keeping their order"""
+    sections = {}
+    for key, value in entries:
+        section, _, rest = key.partition(".")
+        subsection, _, name = rest.rpartition(".")
+        header = f'[{section} {gitconfig_quote(subsection)}]' if subsection else f"[{section}]"
+        sections.setdefault(header, []).append(f"\t{name} = {gitconfig_quote(value)}")
+    return "".join(header + "\n" + "\n".join(lines) + "\n" for header, lines in sections.items())
+
+
+def sync_gitconfig(container_name, config):
+    """Replace the container's ~/.gitconfig with the host's effective global config.
+
+    Includes are resolved on the host; files named by GITCONFIG_FILE_KEYS are
+    copied into the container and the settings rewritten to point at them.
+    """
+    if not config.get("gitconfig", False):
+        return
+    result = run_command(
+        ["git", "config", "--global", "--includes", "--list", "-z"],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    if result.returncode != 0:
+        warn("Failed to read host git config, skipping gitconfig sync")
+        return
+
+    entries = []
+    for item in result.stdout.split("\0"):
+        if not item:
+            continue
+        key, _, value = item.partition("\n")
+        if key.startswith(GITCONFIG_SKIPPED_PREFIXES):
+            continue
+        if key in GITCONFIG_FILE_KEYS:
+            host_file = Path(value).expanduser()
+            if not host_file.is_file():
+                continue
+            value = f"{CONTAINER_HOME}/.config/git/{GITCONFIG_FILE_KEYS[key]}"
+            result = run_command(
+                ["docker", "exec", "-i", container_name, "sh", "-c",
+                 f"mkdir -p {CONTAINER_HOME}/.config/git && cat > {value}"],
+                input=host_file.read_bytes(),
+                stdout=subprocess.DEVNULL,
+                stderr=subprocess.DEVNULL
+            )
+            if result.returncode == 0:
+                record_synced(value)
+        entries.append((key, value))
+
+    result = run_command(
+        ["docker", "exec", "-i", container_name, "sh", "-c", f"cat > {CONTAINER_HOME}/.gitconfig"],
+        input=render_gitconfig(entries),
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.PIPE,
+        text=True
+    )
+    if result.returncode != 0:
+        warn(f"Failed to write .gitconfig: {result.stderr.strip()}")
+    else:
+        record_synced(f"{CONTAINER_HOME}/.gitconfig")
+
+
+DEFAULT_GIT_CREDENTIAL_HOSTS = ["github.com"]
+GIT_CREDENTIALS_FILE = f"{SECRETS_DIR}/git-credentials"
+
+
+def get_git_credential_hosts(config):
+    """Hosts whose HTTPS credentials are bridged from the host, from 'git_credentials'"""
+    value = config.get("git_credentials", False)
+    if value is False:
+        return []
+    if value is True:
+        return list(DEFAULT_GIT_CREDENTIAL_HOSTS)
+    return as_list(value)
+
+
+def host_git_credential(host):
+    """Ask the host's git credential helper for a host's credentials without prompting"""
+    result = run_command(
+        ["git", "credential", "fill"],
+        input=f"protocol=https\nhost={host}\n\n",
+        env={**os.environ, "GIT_TERMINAL_PROMPT": "0", "GCM_INTERACTIVE": "never"},
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    if result.returncode != 0:
+        return None
+    fields = dict(line.split("=", 1) for line in result.stdout.splitlines() if "=" in line)
+    if not fields.get("username") or not fields.get("password"):
+        return None
+    return fields["username"], fields["password"]
+
+
+def sync_git_credentials(container_name, config):
+    """Bridge host git credentials into the container before each exec.
+
+    Credentials come from the host's helper (osxkeychain, manager, gh, ...) and
+    are written to a git-credential-store file on the secrets tmpfs, which the
+    container's git is configured to read.
+    """
+    hosts = get_git_credential_hosts(config)
+    if not hosts:
+        return
+
+    lines = []
+    for host in hosts:
+        credential = host_git_credential(host)
+        if credential is None:
+            warn(f"No git credentials for '{host}' from the host credential helper")
+            continue
+        username, password = credential
+        quote = functools.partial(urllib.parse.quote, safe="")
+        lines.append(f"https://{quote(username)}:{quote(password)}@{host}")
+
+    result = run_command(
+        ["docker", "exec", "-i", "-u", "root", container_name, "sh", "-c",
+         f"mkdir -p {SECRETS_DIR} && umask 077 && cat > {GIT_CREDENTIALS_FILE} && chown node:node {GIT_CREDENTIALS_FILE}"],
+        input="".join(line + "\n" for line in lines),
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.PIPE,
+        text=True
+    )
+    if result.returncode != 0:
+        warn(f"Failed to write git credentials: {result.stderr.strip()}")
+        return
+    record_synced(GIT_CREDENTIALS_FILE)
+    run_command(
+        ["docker", "exec", container_name, "git", "config", "--global",
+         "credential.helper", f"store --file={GIT_CREDENTIALS_FILE}"],
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.DEVNULL
+    )
+
+
+GIT_SIGNING_FORMATS = ("ssh", "openpgp")
+GIT_SIGNING_KEY_FILE = f"{SECRETS_DIR}/git-signing-key"
+CONTAINER_GPG_AGENT_SOCKET = f"{CONTAINER_HOME}/.gnupg/S.gpg-agent"
+
+
+def host_git_config(key):
+    """Read a value from the host's git config, or None"""
+    result = run_command(
+        ["git", "config", "--get", key],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    return result.stdout.strip() if result.returncode == 0 and result.stdout.strip() else None
+
+
+def get_git_signing(config):
+    """Return {"format", "key"} from 'git_signing', or None if disabled.
+
+    true takes gpg.format and user.signingkey from the host's git config; an
+    object may set format ("ssh" or "openpgp") and key explicitly.
+    """
+    signing = config.get("git_signing", False)
+    if signing is False:
+        return None
+    if signing is True:
+        signing = {}
+    fmt = signing.get("format") or host_git_config("gpg.format") or "openpgp"
+    if fmt not in GIT_SIGNING_FORMATS:
+        fail("config-invalid", f"git_signing.format must be one of {', '.join(GIT_SIGNING_FORMATS)}, got: {fmt}")
+    key = signing.get("key") or host_git_config("user.signingkey")
+    if not key:
+        warn("git_signing is enabled but no signing key is configured (user.signingkey)")
+        return None
+    return {"format": fmt, "key": key}
+
+
+def host_gpg_agent_socket():
+    """Path of the host gpg-agent's restricted (extra) socket, or None"""
+    if not shutil.which("gpgconf"):
+        return None
+    result = run_command(
+        ["gpgconf", "--list-dirs", "agent-extra-socket"],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    socket_path = result.stdout.strip()
+    return socket_path if result.returncode == 0 and os.path.exists(socket_path) else None
+
+
+def git_signing_mount_args(config):
+    """Build docker run arguments forwarding the host gpg-agent for openpgp signing"""
+    signing = get_git_signing(config)
+    if not signing or signing["format"] != "openpgp":
+        return []
+    socket_path = host_gpg_agent_socket()
+    if socket_path is None:
+        warn("No host gpg-agent socket found, commits can't be signed in the container")
+        return []
+    return ["-v", f"{socket_path}:{CONTAINER_GPG_AGENT_SOCKET}"]
+
+
+def setup_git_signing(container_name, config):
+    """Make the signing key usable in the container and configure git to sign.
+
+    ssh: the private key (and allowed_signers) is copied to the secrets tmpfs.
+    openpgp: the public key is imported; signing goes through the forwarded agent.
+    """
+    signing = get_git_signing(config)
+    if not signing:
+        return
+
+    if signing["format"] == "ssh":
+        key_path = Path(signing["key"].removeprefix("key::")).expanduser()
+        private_key = key_path.with_suffix("") if key_path.suffix == ".pub" else key_path
+        if not private_key.is_file():
+            warn(f"SSH signing key '{private_key}' not found, skipping git signing")
+            return
+        result = run_command(
+            ["docker", "exec", "-i", "-u", "root", container_name, "sh", "-c",
+             f"mkdir -p {SECRETS_DIR} && umask 077 && cat > {GIT_SIGNING_KEY_FILE} && chown node:node {GIT_SIGNING_KEY_FILE}"],
+            input=private_key.read_bytes(),
+            stdout=subprocess.DEVNULL,
+            stderr=subprocess.PIPE
+        )
+        if result.returncode != 0:
+            warn(f"Failed to copy SSH signing key: {result.stderr.decode().strip()}")
+            return
+        record_synced(GIT_SIGNING_KEY_FILE)
+        git_settings = {"gpg.format": "ssh", "user.signingkey": GIT_SIGNING_KEY_FILE}
+
+        allowed_signers = host_git_config("gpg.ssh.allowedSignersFile")
+        if allowed_signers and Path(allowed_signers).expanduser().is_file():
+            container_path = f"{CONTAINER_HOME}/.config/git/allowed_signers"
+            run_command(
+                ["docker", "exec", "-i", container_name, "sh", "-c",
+                 f"mkdir -p {CONTAINER_HOME}/.config/git && cat > {container_path}"],
+                input=Path(allowed_signers).expanduser().read_bytes(),
+                stdout=subprocess.DEVNULL,
+                stderr=subprocess.DEVNULL
+            )
+            git_settings["gpg.ssh.allowedSignersFile"] = container_path
+    else:
+        public_key = run_command(
+            ["gpg", "--export", signing["key"]],
+            stdout=subprocess.PIPE,
+            stderr=subprocess.DEVNULL
+        ) if shutil.which("gpg") else None
+        if not public_key or not public_key.stdout:
+            warn(f"Failed to export GPG key '{signing['key']}' from the host")
+            return
+        result = run_command(
+            ["docker", "exec", "-i", container_name, "gpg", "--batch", "--import"],
+            input=public_key.stdout,
+            stdout=subprocess.DEVNULL,
+            stderr=subprocess.PIPE
+        )
+        if result.returncode != 0:
+            warn(f"Failed to import GPG key '{signing['key']}' into the container: "
+                 f"{result.stderr.decode(errors='replace').strip()}. Images from before ~/.gnupg was created "
+                 "in the image need 'vibecon -B' and 'vibecon -K'")
+        git_settings = {"gpg.format": "openpgp", "user.signingkey": signing["key"]}
+
+    git_settings.update({"commit.gpgsign": "true", "tag.gpgsign": "true"})
+    for key, value in git_settings.items():
+        run_command(
+            ["docker", "exec", container_name, "git", "config", "--global", key, value],
+            stdout=subprocess.DEVNULL,
+            stderr=subprocess.DEVNULL
+        )
+
+
+KUBECONFIG_MODES = ("sync", "mount")
+LOOPBACK_HOSTS = ("127.0.0.1", "localhost", "0.0.0.0", "[::1]")
+
+
+def get_kubeconfig_config(config):
+    """Return normalized 'kubeconfig' settings, or None if disabled.
+
+    The value may be true or an object with mode ("sync" or "mount"),
+    contexts (contexts to keep, sync only) and rewrite_server (default true).
+    """
+    kube = config.get("kubeconfig", False)
+    if kube is False:
+        return None
+    if kube is True:
+        kube = {}
+    mode = kube.get("mode", "sync")
+    if mode not in KUBECONFIG_MODES:
+        fail("config-invalid", f"kubeconfig.mode must be one of {', '.join(KUBECONFIG_MODES)}, got: {mode}")
+    return {
+        "mode": mode,
+        "contexts": as_list(kube.get("contexts")),
+        "rewrite_server": kube.get("rewrite_server", True),
+    }
+
+
+def host_kubeconfig_path():
+    """First file of the host's $KUBECONFIG, or ~/.kube/config"""
+    paths = [p for p in os.environ.get("KUBECONFIG", "").split(os.pathsep) if p]
+    return Path(paths[0]).expanduser() if paths else Path.home() / ".kube" / "config"
+
+
+def kubeconfig_mount_args(config):
+    """Build docker run arguments for mounting the host kubeconfig read-only"""
+    kube = get_kubeconfig_config(config)
+    if not kube or kube["mode"] != "mount" or not host_kubeconfig_path().exists():
+        return []
+    return ["-v", f"{host_kubeconfig_path()}:{CONTAINER_HOME}/.kube/config:ro"]
+
+
+def loopback_to_host_gateway(url):
+    """Rewrite a URL on a loopback address to host.docker.internal, or None if it isn't one"""
+    parsed = urllib.parse.urlsplit(url)
+    userinfo, _, host = parsed.netloc.rpartition("@")
+    host = host.rsplit(":", 1)[0] if parsed.port else host
+    if host not in LOOPBACK_HOSTS:
+        return None
+    netloc = (f"{userinfo}@" if userinfo else "") + "host.docker.internal" + (f":{parsed.port}" if parsed.port else "")
+    return parsed._replace(netloc=netloc).geturl()
+
+
+def filter_kubeconfig(kubeconfig, contexts):
+    """Keep only the given contexts and the clusters and users they reference"""
+    kept = [c for c in kubeconfig.get("contexts") or [] if c["name"] in contexts]
+    missing = set(contexts) - {c["name"] for c in kept}
+    if missing:
+        warn(f"kubeconfig contexts not found: {', '.join(sorted(missing))}")
+    clusters = {c["context"].get("cluster") for c in kept}
+    users = {c["context"].get("user") for c in kept}
+    kubeconfig["contexts"] = kept
+    kubeconfig["clusters"] = [c for c in kubeconfig.get("clusters") or [] if c["name"] in clusters]
+    kubeconfig["users"] = [u for u in kubeconfig.get("users") or [] if u["name"] in users]
+    if kubeconfig.get("current-context") not in contexts:
+        kubeconfig["current-context"] = kept[0]["name"] if kept else ""
+    return kubeconfig
+
+
+def rewrite_kubeconfig_servers(kubeconfig):
+    """Point loopback API servers at the host gateway, keeping TLS verification"""
+    for cluster in kubeconfig.get("clusters") or []:
+        server = cluster["cluster"].get("server", "")
+        rewritten = loopback_to_host_gateway(server)
+        if rewritten is None:
+            continue
+        cluster["cluster"]["server"] = rewritten
+        # The API server certificate is issued for the original address
+        cluster["cluster"].setdefault("tls-server-name", urllib.parse.urlsplit(server).hostname)
+    return kubeconfig
+
+
+def sync_kubeconfig(container_name, config):
+    """Write the host kubeconfig (flattened, filtered, rewritten) into the container"""
+    kube = get_kubeconfig_config(config)
+    if not kube or kube["mode"] != "sync":
+        return
+    if not shutil.which("kubectl"):
+        warn("kubectl not found on the host, skipping kubeconfig sync")
+        return
+
+    # --flatten inlines certificate files, which don't exist in the container
+    result = run_command(
+        ["kubectl", "config", "view", "--raw", "--flatten", "-o", "json"],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.PIPE,
+        text=True
+    )
+    if result.returncode != 0:
+        warn(f"Failed to read host kubeconfig: {result.stderr.strip()}")
+        return
+    kubeconfig = json.loads(result.stdout)
+
+    if kube["contexts"]:
+        kubeconfig = filter_kubeconfig(kubeconfig, kube["contexts"])
+    if kube["rewrite_server"] and get_network_name(config, container_name) != "host":
+        kubeconfig = rewrite_kubeconfig_servers(kubeconfig)
+
+    # kubectl reads JSON kubeconfigs as well as YAML
+    result = run_command(
+        ["docker", "exec", "-i", container_name, "sh", "-c",
+         f"mkdir -p {CONTAINER_HOME}/.kube && umask 077 && cat > {CONTAINER_HOME}/.kube/config"],
+        input=json.dumps(kubeconfig, indent=2),
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.PIPE,
+        text=True
+    )
+    if result.returncode != 0:
+        warn(f"Failed to write kubeconfig: {result.stderr.strip()}")
+    else:
+        record_synced(f"{CONTAINER_HOME}/.kube/config")
+
+
+# Installers for stdio MCP server launchers missing from the image
+MCP_PREREQUISITES = {
+    "uv": "curl -LsSf https://astral.sh/uv/install.sh | sh",
+    "uvx": "curl -LsSf https://astral.sh/uv/install.sh | sh",
+    "bun": "curl -fsSL https://bun.sh/install | bash",
+    "bunx": "curl -fsSL https://bun.sh/install | bash",
+    "deno": "curl -fsSL https://deno.land/install.sh | sh -s -- -y",
+}
+
+
+def get_mcp_config(config):
+    """Return normalized 'mcp' settings, or None if MCP sync is disabled.
+
+    Disabled by default; the value may be true or an object with servers
+    (names to sync), rewrite_localhost (default true) and install_prerequisites.
+    """
+    mcp = config.get("mcp", False)
+    if mcp is False:
+        return None
+    if mcp is True:
+        mcp = {}
+    return {
+        "servers": as_list(mcp.get("servers")),
+        "rewrite_localhost": mcp.get("rewrite_localhost", True),
+        "install_prerequisites": mcp.get("install_prerequisites", False),
+    }
+
+
+def prepare_mcp_servers(servers, mcp, container_name, config):
+    """Filter MCP server definitions and make their endpoints reachable from the container"""
+    result = {}
+    rewrite = mcp["rewrite_localhost"] and get_network_name(config, container_name) != "host"
+    for name, server in servers.items():
+        if mcp["servers"] and name not in mcp["servers"]:
+            continue
+        server = dict(server)
+        if rewrite and server.get("url"):
+            server["url"] = loopback_to_host_gateway(server["url"]) or server["url"]
+        result[name] = server
+    return result
+
+
+# Merges MCP servers into the container's ~/.claude.json: only servers vibecon
+# added before (tracked in a side file) are replaced or removed, so servers
+# added inside the container survive. Retries if Claude rewrote the file
+# between the read and the rename.
+MCP_MERGE_SCRIPT = (
+    'const fs=require("fs");const [file,track,dir]=process.argv.slice(1);'
+    'const want=JSON.parse(fs.readFileSync(0,"utf8"));'
+    'const load=(f,d)=>{try{return JSON.parse(fs.readFileSync(f,"utf8"))}catch(e){if(e.code==="ENOENT")return d;throw e}};'
+    'const mtime=()=>{try{return fs.statSync(file).mtimeMs}catch(e){return 0}};'
+    'const merge=(cur,old,add)=>{const out={...cur};for(const n of old)if(!(n in add))delete out[n];return Object.assign(out,add)};'
+    'const prev=load(track,{user:[],projects:{}});'
+    'for(let i=0;;i++){const t=mtime();const s=load(file,{});const p=(s.projects||{})[dir]||{};'
+    'const user=merge(s.mcpServers||{},prev.user||[],want.user);'
+    'const proj=merge(p.mcpServers||{},(prev.projects||{})[dir]||[],want.project);'
+    'if(JSON.stringify(user)===JSON.stringify(s.mcpServers||{})&&JSON.stringify(proj)===JSON.stringify(p.mcpServers||{}))break;'
+    's.mcpServers=user;s.projects={...s.projects,[dir]:{...p,mcpServers:proj}};'
+    'fs.writeFileSync(file+".vibecon",JSON.stringify(s,null,2),{mode:0o600});'
+    'if(mtime()!==t&&i<5)continue;fs.renameSync(file+".vibecon",file);console.log("changed");break}'
+    'prev.user=Object.keys(want.user);prev.projects={...prev.projects,[dir]:Object.keys(want.project)};'
+    'fs.writeFileSync(track,JSON.stringify(prev))'
+)
+
+
+def sync_mcp_config(container_name, config, container_workdir):
+    """Merge MCP server definitions from the host's ~/.claude.json into the container.
+
+    User-scope servers go to the container's top level; local-scope servers
+    of the current directory go to the project entry for container_workdir.
+    Servers added inside the container are left alone.
+    """
+    mcp = get_mcp_config(config)
+    host_file = Path.home() / ".claude.json"
+    if mcp is None or not host_file.exists():
+        return
+    try:
+        with open(host_file) as f:
+            host_state = json.load(f)
+    except (json.JSONDecodeError, IOError) as e:
+        warn(f"Failed to parse {host_file}: {e}")
+        return
+
+    state_file = claude_state_file(config)
+    user_servers = prepare_mcp_servers(host_state.get("mcpServers", {}), mcp, container_name, config)
+    project_state = host_state.get("projects", {}).get(os.getcwd(), {})
+    project_servers = prepare_mcp_servers(project_state.get("mcpServers", {}), mcp, container_name, config)
+
+    # The lock serializes concurrent vibecon commands against the same container
+    result = run_command(
+        ["docker", "exec", "-i", container_name, "flock", f"{state_file}.vibecon-lock",
+         "node", "-e", MCP_MERGE_SCRIPT, state_file, f"{state_file}.vibecon-mcp", container_workdir],
+        input=json.dumps({"user": user_servers, "project": project_servers}),
+        stdout=subprocess.PIPE,
+        stderr=subprocess.PIPE,
+        text=True
+    )
+    if result.returncode != 0:
+        warn(f"Failed to write MCP config: {result.stderr.strip()}")
+        return
+    if "changed" in result.stdout:
+        record_synced(state_file)
+
+    if mcp["install_prerequisites"]:
+        launchers = {server.get("command") for server in {**user_servers, **project_servers}.values()}
+        install_mcp_prerequisites(container_name, launchers & MCP_PREREQUISITES.keys())
+
+
+def install_mcp_prerequisites(container_name, launchers):
+    """Install launchers used by stdio MCP servers if the container lacks them"""
+    for launcher in sorted(launchers):
+        check = run_command(
+            ["docker", "exec", container_name, "sh", "-c", f"command -v {launcher} || test -x ~/.local/bin/{launcher}"],
+            stdout=subprocess.DEVNULL,
+            stderr=subprocess.DEVNULL
+        )
+        if check.returncode == 0:
+            continue
+        print(f"Installing {launcher} for MCP servers...")
+        result = run_command(
+            ["docker", "exec", container_name, "sh", "-c", MCP_PREREQUISITES[launcher]],
+            stdout=subprocess.DEVNULL,
+            stderr=subprocess.PIPE,
+            text=True
+        )
+        if result.returncode != 0:
+            warn(f"Failed to install {launcher}: {result.stderr.strip()}")
+
+
+# Agent login state, relative to the home directory; saved to the host store
+# after a login in one container and copied into containers that lack it
+AGENT_CREDENTIAL_FILES = {
+    "claude": [".claude/.credentials.json"],
+    "codex": [".codex/auth.json"],
+    "gemini": [".gemini/oauth_creds.json", ".gemini/google_accounts.json"],
+}
+CREDENTIALS_STORE_DIR = SECRETS_STORE_DIR / "credentials"
+CREDENTIAL_SYNC_MODES = ("ask", "always")
+
+
+# Agent state directories that 'shared_auth' backs with machine-wide volumes
+SHARED_AUTH_DIRS = {
+    "claude": ".claude",
+    "codex": ".codex",
+    "gemini": ".gemini",
+}
+
+
+SHELL_HISTORY_DIR = f"{CONTAINER_HOME}/.shell_history"
+
+
+def shell_history_args(project_root, config):
+    """Build docker run arguments for a per-workspace shell history volume.
+
+    The volume is named after the workspace (not the container), so history is
+    kept across 'vibecon -K', image upgrades and profiles.
+    """
+    if not config.get("shell_history", True):
+        return []
+    volume_name = f"{generate_container_name(str(project_root))}-history"
+    return volume_mount_args(volume_name, SHELL_HISTORY_DIR, None, {}, False) + [
+        "-e", f"HISTFILE={SHELL_HISTORY_DIR}/zsh_history",
+    ]
+
+
+# Package manager cache presets: volume name (vibecon-cache-<name>) -> container path
+CACHE_PRESETS = {
+    "go": {"go-build": f"{CONTAINER_HOME}/.cache/go-build", "go-mod": f"{CONTAINER_HOME}/go/pkg/mod"},
+    "npm": {"npm": f"{CONTAINER_HOME}/.npm"},
+    "pnpm": {"pnpm": f"{CONTAINER_HOME}/.local/share/pnpm/store"},
+    "yarn": {"yarn": f"{CONTAINER_HOME}/.cache/yarn"},
+    "pip": {"pip": f"{CONTAINER_HOME}/.cache/pip"},
+    "uv": {"uv": f"{CONTAINER_HOME}/.cache/uv"},
+    "cargo": {"cargo-registry": f"{CONTAINER_HOME}/.cargo/registry", "cargo-git": f"{CONTAINER_HOME}/.cargo/git"},
+}
+
+
+def cache_paths(config):
+    """Container paths backed by shared cache volumes, keyed by volume name"""
+    paths = {}
+    for preset in config.get("caches", []):
+        if preset not in CACHE_PRESETS:
+            fail("config-invalid", f"unknown cache preset '{preset}' (available: {', '.join(CACHE_PRESETS)})")
+        for name, path in CACHE_PRESETS[preset].items():
+            paths[f"vibecon-cache-{name}"] = path
+    return paths
+
+
+def cache_args(config):
+    """Build docker run arguments mounting machine-wide package cache volumes"""
+    args = []
+    for volume_name, path in cache_paths(config).items():
+        args.extend(volume_mount_args(volume_name, path, None, {}, False))
+    return args
+
+
+def workspace_relative_paths(config, key):
+    """Normalized workspace-relative paths from a list config value"""
+    paths = []
+    for path in as_list(config.get(key)):
+        path = posixpath.normpath(path)
+        if posixpath.isabs(path) or path.startswith(".."):
+            fail("config-invalid", f"{key} paths must be relative to the workspace, got: {path}")
+        paths.append(path)
+    return paths
+
+
+def get_volume_overlays(config):
+    """Workspace-relative paths backed by named volumes, from 'volume_overlays'"""
+    value = config.get("volume_overlays", False)
+    if value is False:
+        return []
+    if value is True:
+        return ["node_modules"]
+    return workspace_relative_paths(config, "volume_overlays")
+
+
+def workspace_exclude_args(container_mount_root, config):
+    """Build docker run arguments hiding workspace directories behind empty anonymous volumes"""
+    args = []
+    for path in workspace_relative_paths(config, "workspace_excludes"):
+        args.extend(volume_mount_args(None, posixpath.join(container_mount_root, path), None, {}, False))
+    return args
+
+
+def volume_overlay_paths(project_root, container_mount_root, config):
+    """Container paths of volume overlays, keyed by volume name"""
+    base_name = generate_container_name(str(project_root))
+    return {
+        f"{base_name}-{re.sub(r'[^a-zA-Z0-9_.-]', '-', path).lower()}": posixpath.join(container_mount_root, path)
+        for path in get_volume_overlays(config)
+    }
+
+
+def volume_overlay_args(project_root, container_mount_root, config):
+    """Build docker run arguments that put named volumes over parts of the workspace"""
+    args = []
+    for volume_name, path in volume_overlay_paths(project_root, container_mount_root, config).items():
+        args.extend(volume_mount_args(volume_name, path, None, {}, False))
+    return args
+
+
+def get_shared_auth_agents(config):
+    """Agents whose state directory is shared across containers, from 'shared_auth'"""
+    value = config.get("shared_auth", False)
+    if value is False:
+        return []
+    if value is True:
+        return list(SHARED_AUTH_DIRS)
+    return as_list(value)
+
+
+def shared_auth_args(config):
+    """Build docker run arguments mounting shared agent state volumes.
+
+    Claude keeps part of its state (.claude.json) outside ~/.claude, so
+    CLAUDE_CONFIG_DIR points it inside the shared directory.
+    """
+    args = []
+    for agent in get_shared_auth_agents(config):
+        args.extend(volume_mount_args(f"vibecon-auth-{agent}", f"{CONTAINER_HOME}/{SHARED_AUTH_DIRS[agent]}", None, {}, False))
+        if agent == "claude":
+            args.extend(["-e", f"CLAUDE_CONFIG_DIR={CONTAINER_HOME}/.claude"])
+    return args
+
+
+def claude_state_file(config):
+    """Path of Claude's .claude.json state file inside the container"""
+    if "claude" in get_shared_auth_agents(config):
+        return f"{CONTAINER_HOME}/.claude/.claude.json"
+    return f"{CONTAINER_HOME}/.claude.json"
+
+
+def get_credential_sync_mode(config):
+    """Return 'ask', 'always' or None from the 'credential_sync' config (default ask)"""
+    mode = config.get("credential_sync", "ask")
+    if mode is False:
+        return None
+    if mode not in CREDENTIAL_SYNC_MODES:
+        fail("config-invalid", f"'credential_sync' must be one of {', '.join(CREDENTIAL_SYNC_MODES)} or false, got: {mode}")
+    return mode
+
+
+def container_file_hashes(container_name, rel_paths):
+    """sha256 of files under the container home that exist, keyed by relative path"""
+    script = "; ".join(f"[ -f {CONTAINER_HOME}/{rel} ] && sha256sum {CONTAINER_HOME}/{rel}" for rel in rel_paths) + "; true"
+    result = run_command(
+        ["docker", "exec", container_name, "sh", "-c", script],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    hashes = {}
+    for line in result.stdout.splitlines():
+        digest, _, path = line.partition("  ")
+        hashes[posixpath.relpath(path, CONTAINER_HOME)] = digest
+    return hashes
+
+
+def credential_sync_agents(config):
+    """Agents whose logins are synced through the host store: not claude when ~/.claude is mounted"""
+    mounted = get_claude_config_mode(config) != "copy"
+    return {agent: files for agent, files in AGENT_CREDENTIAL_FILES.items() if not (agent == "claude" and mounted)}
+
+
+def push_stored_credentials(container_name, config, agents=None):
+    """Copy stored agent logins (of the given agents, or all) into the container where it has none"""
+    if get_credential_sync_mode(config) is None or not CREDENTIALS_STORE_DIR.exists():
+        return
+    rel_paths = [
+        rel for agent, files in credential_sync_agents(config).items()
+        if agents is None or agent in agents
+        for rel in files
+    ]
+    existing = container_file_hashes(container_name, rel_paths)
+    for rel in rel_paths:
+        stored = CREDENTIALS_STORE_DIR / rel
+        if rel in existing or not stored.is_file():
+            continue
+        target = f"{CONTAINER_HOME}/{rel}"
+        result = run_command(
+            ["docker", "exec", "-i", container_name, "sh", "-c",
+             f"mkdir -p {posixpath.dirname(target)} && umask 077 && cat > {target}"],
+            input=stored.read_bytes(),
+            stdout=subprocess.DEVNULL,
+            stderr=subprocess.PIPE
+        )
+        if result.returncode != 0:
+            warn(f"Failed to copy stored credentials {rel}: {result.stderr.decode().strip()}")
+        else:
+            record_synced(target)
+
+
+def pull_new_credentials(container_name, config):
+    """Offer to save agent logins made in the container for other containers"""
+    mode = get_credential_sync_mode(config)
+    if mode is None:
+        return
+    for agent, rel_paths in credential_sync_agents(config).items():
+        declined = f".vibecon-no-login-save-{agent}"
+        hashes = container_file_hashes(container_name, rel_paths + [declined])
+        if hashes.pop(declined, None):
+            continue
+        changed = [
+            rel for rel in hashes
+            if not (CREDENTIALS_STORE_DIR / rel).is_file()
+            or hashlib.sha256((CREDENTIALS_STORE_DIR / rel).read_bytes()).hexdigest() != hashes[rel]
+        ]
+        if not changed:
+            continue
+        # Only ask for the first login; refreshed tokens of a saved login are kept up to date
+        is_new = not any((CREDENTIALS_STORE_DIR / rel).is_file() for rel in rel_paths)
+        if mode == "ask" and is_new:
+            if not sys.stdin.isatty():
+                continue
+            answer = input(f"Save the {agent} login from this container for new containers? [Y/n] ").strip().lower()
+            if answer not in ("", "y", "yes"):
+                run_command(
+                    ["docker", "exec", container_name, "touch", f"{CONTAINER_HOME}/{declined}"],
+                    stdout=subprocess.DEVNULL,
+                    stderr=subprocess.DEVNULL
+                )
+                continue
+        for rel in changed:
+            result = run_command(
+                ["docker", "exec", container_name, "cat", f"{CONTAINER_HOME}/{rel}"],
+                stdout=subprocess.PIPE,
+                stderr=subprocess.DEVNULL
+            )
+            if result.returncode != 0:
+                continue
+            stored = CREDENTIALS_STORE_DIR / rel
+            stored.parent.mkdir(parents=True, exist_ok=True, mode=0o700)
+            stored.write_bytes(result.stdout)
+            stored.chmod(0o600)
+        if is_new:
+            print(f"Saved {agent} login to {CREDENTIALS_STORE_DIR}")
+
+
+def sync_git_setup(container_name, config):
+    """Sync git settings in order: .gitconfig is replaced first, credential and signing settings go on top"""
+    sync_gitconfig(container_name, config)
+    sync_git_credentials(container_name, config)
+    setup_git_signing(container_name, config)
+
+
+def host_sync_enabled(config):
+    """Whether host config is synced before every exec ('sync.enabled', default true)"""
+    return config.get("sync", {}).get("enabled", True)
+
+
+def sync_claude(container_name, config, container_workdir):
+    """Claude settings and files, MCP servers and the stored Claude login"""
+    sync_claude_config(container_name, config)
+    sync_mcp_config(container_name, config, container_workdir)
+    push_stored_credentials(container_name, config, ["claude"])
+
+
+def sync_codex(container_name, config, container_workdir):
+    """The stored Codex login"""
+    push_stored_credentials(container_name, config, ["codex"])
+
+
+def sync_gemini(container_name, config, container_workdir):
+    """The stored Gemini login"""
+    push_stored_credentials(container_name, config, ["gemini"])
+
+
+# Host config files of agents without a dedicated sync, relative to the home directory
+AGENT_CONFIG_FILES = {
+    "aider": [".aider.conf.yml", ".aider.model.settings.yml", ".aider.model.metadata.json"],
+    "opencode": [".config/opencode/opencode.json", ".config/opencode/AGENTS.md"],
+    "goose": [".config/goose/config.yaml", ".config/goose/.goosehints"],
+}
+
+
+def copy_home_files(container_name, rel_paths):
+    """Copy files that exist in the host home directory to the same place in the container's"""
+    for rel in rel_paths:
+        source = Path.home() / rel
+        if not source.is_file():
+            continue
+        target = f"{CONTAINER_HOME}/{rel}"
+        result = run_command(
+            ["docker", "exec", "-i", container_name, "sh", "-c",
+             f"mkdir -p {posixpath.dirname(target)} && cat > {target}"],
+            input=source.read_bytes(),
+            stdout=subprocess.DEVNULL,
+            stderr=subprocess.PIPE
+        )
+        if result.returncode != 0:
+            warn(f"Failed to copy ~/{rel}: {result.stderr.decode().strip()}")
+        else:
+            record_synced(target)
+
+
+def sync_aider(container_name, config, container_workdir):
+    copy_home_files(container_name, AGENT_CONFIG_FILES["aider"])
+
+
+def sync_opencode(container_name, config, container_workdir):
+    copy_home_files(container_name, AGENT_CONFIG_FILES["opencode"])
+
+
+def sync_goose(container_name, config, container_workdir):
+    copy_home_files(container_name, AGENT_CONFIG_FILES["goose"])
+
+
+def sync_cloud(container_name, config, container_workdir):
+    sync_cloud_credentials(container_name, config)
+
+
+def sync_kube(container_name, config, container_workdir):
+    sync_kubeconfig(container_name, config)
+
+
+def sync_git(container_name, config, container_workdir):
+    sync_git_setup(container_name, config)
+
+
+# Pre-exec syncs by name: (agent command it is for, or None if for every
+# command; sync function taking container name, config and workdir).
+# A new agent only needs an entry here and its function.
+SYNCERS = {
+    "claude": ("claude", sync_claude),
+    "codex": ("codex", sync_codex),
+    "gemini": ("gemini", sync_gemini),
+    "aider": ("aider", sync_aider),
+    "opencode": ("opencode", sync_opencode),
+    "goose": ("goose", sync_goose),
+    "cloud": (None, sync_cloud),
+    "kubeconfig": (None, sync_kube),
+    "git": (None, sync_git),
+}
+
+
+def select_syncers(config, command=None):
+    """Names of the syncs to run before command.
+
+    'sync.syncers' limits them (default: all), and agents missing from
+    'tools' are left out. When the command is one of the agents, the other
+    agents' syncs are skipped; they run before those agents are started.
+    """
+    names = config.get("sync", {}).get("syncers", list(SYNCERS))
+    unknown = [name for name in names if name not in SYNCERS]
+    if unknown:
+        fail("config-invalid", f"unknown syncer '{unknown[0]}' in 'sync.syncers' (available: {', '.join(SYNCERS)})")
+    # Agents that aren't in the image have nothing to sync
+    tools = get_tools(config)
+    names = [name for name in names if SYNCERS[name][0] is None or SYNCERS[name][0] in tools]
+    program = posixpath.basename(command[0]) if command else None
+    agents = {agent for agent, _ in SYNCERS.values() if agent}
+    if program in agents:
+        names = [name for name in names if SYNCERS[name][0] in (None, program)]
+    return names
+
+
+def sync_to_container(container_name, config, container_workdir, command=None):
+    """Run the pre-exec syncs for command concurrently; they touch separate files.
+
+    Returns env vars with short-lived cloud tokens for the exec.
+    """
+    with concurrent.futures.ThreadPoolExecutor() as executor:
+        futures = [
+            executor.submit(SYNCERS[name][1], container_name, config, container_workdir)
+            for name in select_syncers(config, command)
+        ]
+        token_env = executor.submit(cloud_token_env, config)
+        for future in futures:
+            future.result()
+        return token_env.result()
+
+
+# Workspace sync mode: the workspace lives in a named volume and is reconciled
+# with the host before and after every command, instead of being bind-mounted
+WORKSPACE_MODES = ("bind", "sync")
+DEFAULT_WORKSPACE_SYNC_IGNORE = ["node_modules", ".DS_Store"]
+WORKSPACE_SYNC_STATE_DIR = Path.home() / ".cache" / "vibecon" / "workspace-sync"
+
+
+def get_workspace_mode(config):
+    """Return 'bind' or 'sync' from the 'workspace_mode' config"""
+    mode = config.get("workspace_mode", "bind")
+    if mode not in WORKSPACE_MODES:
+        fail("config-invalid", f"'workspace_mode' must be one of {', '.join(WORKSPACE_MODES)}, got: {mode}")
+    return mode
+
+
+def workspace_volume_name(project_root):
+    return f"{generate_container_name(str(project_root))}-workspace"
+
+
+def workspace_sync_ignore(config):
+    """Name patterns (any path component, shell glob) left out of workspace sync"""
+    return config.get("workspace_sync_ignore", DEFAULT_WORKSPACE_SYNC_IGNORE)
+
+
+def list_host_workspace(project_root, ignore):
+    """Map relative paths of regular files in the host workspace to (mtime_ns, size)"""
+    files = {}
+    for dirpath, dirnames, filenames in os.walk(project_root):
+        dirnames[:] = [d for d in dirnames if not any(fnmatch.fnmatch(d, p) for p in ignore)]
+        for filename in filenames:
+            if any(fnmatch.fnmatch(filename, p) for p in ignore):
+                continue
+            path = os.path.join(dirpath, filename)
+            if not os.path.isfile(path) or os.path.islink(path):
+                continue
+            stat = os.stat(path)
+            files[os.path.relpath(path, project_root).replace(os.sep, "/")] = [stat.st_mtime_ns, stat.st_size]
+    return files
+
+
+def list_container_workspace(container_name, container_mount_root, ignore):
+    """Map relative paths of regular files in the container workspace to [mtime, size]"""
+    prune = " -o ".join(f"-name {shlex.quote(p)}" for p in ignore)
+    prune_expr = f"\\( {prune} \\) -prune -o " if ignore else ""
+    result = run_command(
+        ["docker", "exec", container_name, "sh", "-c",
+         f"cd {shlex.quote(container_mount_root)} && find . {prune_expr}-type f -printf '%P\\t%T@\\t%s\\n'"],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.PIPE,
+        text=True
+    )
+    if result.returncode != 0:
+        fail(docker_error_category(result.stderr), f"Failed to list container workspace: {result.stderr.strip()}")
+    files = {}
+    for line in result.stdout.splitlines():
+        path, mtime, size = line.rsplit("\t", 2)
+        files[path] = [mtime, int(size)]
+    return files
+
+
+def push_workspace_files(container_name, project_root, container_mount_root, paths):
+    """Copy host workspace files into the container, owned by node"""
+    ids = run_command(
+        ["docker", "exec", container_name, "sh", "-c", "id -u node; id -g node"],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    ).stdout.split()
+    uid, gid = (int(ids[0]), int(ids[1])) if len(ids) == 2 else (1000, 1000)
+
+    buffer = io.BytesIO()
+    with tarfile.open(fileobj=buffer, mode="w") as tar:
+        # Parent directories go first so docker cp doesn't create them root-owned
+        parents = set()
+        for path in paths:
+            parent = posixpath.dirname(path)
+            while parent:
+                parents.add(parent)
+                parent = posixpath.dirname(parent)
+        for parent in sorted(parents):
+            info = tarfile.TarInfo(parent)
+            info.type, info.mode, info.uid, info.gid = tarfile.DIRTYPE, 0o755, uid, gid
+            info.mtime = int(time.time())
+            tar.addfile(info)
+        for path in paths:
+            info = tar.gettarinfo(os.path.join(project_root, path), arcname=path)
+            info.uid, info.gid, info.uname, info.gname = uid, gid, "", ""
+            with open(os.path.join(project_root, path), "rb") as f:
+                tar.addfile(info, f)
+    result = run_command(
+        ["docker", "cp", "-a", "-", f"{container_name}:{container_mount_root}"],
+        input=buffer.getvalue(),
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.PIPE
+    )
+    if result.returncode != 0:
+        warn(f"Failed to copy files into the container workspace: {result.stderr.decode().strip()}")
+
+
+def pull_workspace_files(container_name, project_root, container_mount_root, paths, suffix=""):
+    """Copy container workspace files to the host (optionally renamed with a suffix)"""
+    result = run_command(
+        ["docker", "exec", "-i", container_name, "tar", "-cf", "-", "-C", container_mount_root, "--null", "-T", "-"],
+        input="\0".join(paths).encode(),
+        stdout=subprocess.PIPE,
+        stderr=subprocess.PIPE
+    )
+    if result.returncode != 0:
+        warn(f"Failed to copy files from the container workspace: {result.stderr.decode().strip()}")
+        return
+    with tarfile.open(fileobj=io.BytesIO(result.stdout)) as tar:
+        for member in tar.getmembers():
+            if not member.isfile():
+                continue
+            # Member names come from the container; refuse any that leave the workspace
+            try:
+                member = tarfile.data_filter(member, project_root)
+            except tarfile.FilterError as e:
+                warn(f"Skipping {member.name} from the container workspace: {e}")
+                continue
+            target = Path(project_root) / (member.name + suffix)
+            target.parent.mkdir(parents=True, exist_ok=True)
+            target.write_bytes(tar.extractfile(member).read())
+            target.chmod(member.mode & 0o777)
+            os.utime(target, (member.mtime, member.mtime))
+
+
+def same_file_state(host_state, container_state):
+    """Whether a host and a container file have the same size and mtime (to the second; copies keep only that)"""
+    return host_state[1] == container_state[1] and host_state[0] // 10**9 == int(float(container_state[0]))
+
+
+def workspace_volume_id(volume_name):
+    """Creation time of the workspace volume, which changes when it is recreated"""
+    result = run_command(
+        ["docker", "volume", "inspect", "-f", "{{.CreatedAt}}", volume_name],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    return result.stdout.strip() if result.returncode == 0 else None
+
+
+def sync_workspace(container_name, project_root, container_mount_root, config):
+    """Reconcile the host workspace with the container's workspace volume.
+
+    A manifest of both sides' file states after the last sync tells which side
+    changed a file. Changes and deletions are copied to the other side; if both
+    sides changed a file, or it differs and isn't in the manifest, the host
+    version wins and the container version is saved next to it as
+    <file>.vibecon-conflict.
+
+    The manifest belongs to one instance of the workspace volume; it is dropped
+    when the volume was recreated, or when the container side is empty, so a
+    lost volume never reads as every file deleted in the container.
+    """
+    if get_workspace_mode(config) != "sync":
+        return
+    ignore = workspace_sync_ignore(config)
+    volume_name = workspace_volume_name(project_root)
+    state_file = WORKSPACE_SYNC_STATE_DIR / f"{volume_name}.json"
+    volume_id = workspace_volume_id(volume_name)
+    state = json.loads(state_file.read_text()) if state_file.exists() else {}
+    manifest = state.get("files", {}) if state.get("volume") == volume_id else {}
+
+    host = list_host_workspace(project_root, ignore)
+    container = list_container_workspace(container_name, container_mount_root, ignore)
+    if manifest and not container:
+        warn("The container workspace is empty; copying the host files into it instead of deleting them on the host")
+        manifest = {}
+
+    push, pull, delete_in_container, conflicts = [], [], [], []
+    for path in set(host) | set(container) | set(manifest):
+        h, c = host.get(path), container.get(path)
+        known = manifest.get(path, {})
+        host_changed = h != known.get("host")
+        container_changed = c != known.get("container")
+        if not host_changed and not container_changed:
+            continue
+        if host_changed and not container_changed:
+            (push if h else delete_in_container).append(path)
+        elif container_changed and not host_changed:
+            if c:
+                pull.append(path)
+            elif h:
+                os.remove(os.path.join(project_root, path))
+        elif h is None and c is not None:
+            pull.append(path)
+        elif c is None and h is not None:
+            push.append(path)
+        elif h is not None and c is not None and (known or not same_file_state(h, c)):
+            # Edited on both sides, or different and unknown to the manifest
+            push.append(path)
+            conflicts.append(path)
+
+    if conflicts:
+        pull_workspace_files(container_name, project_root, container_mount_root, conflicts, ".vibecon-conflict")
+        for path in conflicts:
+            warn(f"{path} differs between host and container; kept the host version, container version saved as {path}.vibecon-conflict")
+    if push:
+        push_workspace_files(container_name, project_root, container_mount_root, push)
+    if pull:
+        pull_workspace_files(container_name, project_root, container_mount_root, pull)
+    if delete_in_container:
+        run_command(
+            ["docker", "exec", "-i", container_name, "sh", "-c", f"cd {shlex.quote(container_mount_root)} && xargs -0 rm -f"],
+            input="\0".join(delete_in_container).encode(),
+            stdout=subprocess.DEVNULL,
+            stderr=subprocess.DEVNULL
+        )
+
+    # Record both sides as they are now
+    if push or pull or delete_in_container or conflicts or not state_file.exists():
+        host = list_host_workspace(project_root, ignore)
+        container = list_container_workspace(container_name, container_mount_root, ignore)
+    manifest = {path: {"host": host[path], "container": container[path]} for path in host.keys() & container.keys()}
+    WORKSPACE_SYNC_STATE_DIR.mkdir(parents=True, exist_ok=True)
+    state_file.write_text(json.dumps({"volume": volume_id, "files": manifest}))
+
+
+# Sandbox mode: the host workspace is mounted read-only at SANDBOX_SOURCE and
+# copied into a scratch volume; a baseline commit in a separate git dir lets
+# the agent's changes be reviewed and applied back to the host
+SANDBOX_SOURCE = "/vibecon/source"
+SANDBOX_GIT_DIR = f"{CONTAINER_HOME}/.vibecon-sandbox.git"
+
+
+def sandbox_container_name(container_name):
+    return f"{container_name}--sandbox"
+
+
+def sandbox_git(container_mount_root):
+    """git command line operating on the sandbox baseline repository"""
+    return f"git --git-dir={SANDBOX_GIT_DIR} --work-tree={shlex.quote(container_mount_root)} -c user.name=vibecon -c user.email=vibecon@localhost"
+
+
+def init_sandbox(container_name, container_mount_root):
+    """Copy the read-only workspace into the scratch volume once and record a baseline"""
+    git = sandbox_git(container_mount_root)
+    result = run_command(
+        ["docker", "exec", container_name, "sh", "-c",
+         f"[ -d {SANDBOX_GIT_DIR} ] || {{ "
+         f"cp -R --preserve=mode,timestamps,links {SANDBOX_SOURCE}/. {shlex.quote(container_mount_root)}/ && "
+         f"git init -q --bare {SANDBOX_GIT_DIR} && {git} add -A && {git} commit -q --allow-empty -m baseline; }}"],
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.PIPE,
+        text=True
+    )
+    if result.returncode != 0:
+        fail(docker_error_category(result.stderr), f"Failed to set up sandbox workspace: {result.stderr.strip()}")
+
+
+def sandbox_diff(container_name, container_mount_root):
+    """Binary patch of everything changed in the sandbox since the baseline"""
+    git = sandbox_git(container_mount_root)
+    result = run_command(
+        ["docker", "exec", container_name, "sh", "-c", f"{git} add -A && {git} diff --cached --binary HEAD"],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.PIPE
+    )
+    if result.returncode != 0:
+        fail(docker_error_category(result.stderr.decode()), f"Failed to compute sandbox diff: {result.stderr.decode().strip()}")
+    return result.stdout
+
+
+def sandbox_command(argv):
+    """vibecon sandbox <action> - review and apply changes made with --sandbox"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon sandbox",
+        description="Review, apply or discard the changes made in the --sandbox container"
+    )
+    actions = parser.add_subparsers(dest="action", required=True)
+    actions.add_parser("diff", help="show the changes made in the sandbox")
+    actions.add_parser("apply", help="apply the sandbox changes to the host workspace")
+    actions.add_parser("discard", help="destroy the sandbox container and its workspace copy")
+    for action in actions.choices.values():
+        action.add_argument("-p", "--profile", action="append", default=[], metavar="NAME", help="apply a named profile")
+    args = parser.parse_args(argv)
+
+    project_root, root_config, container_mount_root = find_project_root()
+    container_name = sandbox_container_name(generate_container_name(project_root, args.profile or env_profiles()))
+    if not container_exists(container_name):
+        fail(None, f"No sandbox container '{container_name}'; start one with 'vibecon --sandbox'")
+
+    if args.action == "discard":
+        destroy_container(container_name)
+        run_command(["docker", "volume", "rm", container_name], stdout=subprocess.DEVNULL, stderr=subprocess.DEVNULL)
+        return 0
+
+    # exec needs a running container
+    if not is_container_running(container_name):
+        run_command(["docker", "start", container_name], stdout=subprocess.DEVNULL, stderr=subprocess.DEVNULL)
+    patch = sandbox_diff(container_name, container_mount_root)
+    if not patch:
+        print("No changes in the sandbox.")
+        return 0
+
+    if args.action == "diff":
+        sys.stdout.write(patch.decode(errors="replace"))
+        return 0
+
+    result = run_command(["git", "apply", "--binary", "--whitespace=nowarn", "-"], input=patch, cwd=project_root)
+    if result.returncode != 0:
+        fail(None, "The sandbox changes don't apply cleanly to the host workspace; see 'vibecon sandbox diff'")
+    # Applied changes become the new baseline, so they aren't applied twice
+    git = sandbox_git(container_mount_root)
+    run_command(
+        ["docker", "exec", container_name, "sh", "-c", f"{git} add -A && {git} commit -q -m applied"],
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.DEVNULL
+    )
+    success(f"Applied sandbox changes to {project_root}")
+    return 0
+
+
+# Auto port forwarding: connections to a host port are piped through
+# 'docker exec' into the container, so servers bound to localhost inside the
+# container are reachable too, and it works with Docker Desktop and remote engines
+FORWARD_SCRIPT = (
+    'const s=require("net").connect(+process.argv[1],"localhost");'
+    'process.stdin.pipe(s);s.pipe(process.stdout);'
+    's.on("error",()=>process.exit(1));s.on("close",()=>process.exit(0))'
+)
+
+
+def get_auto_forward(config):
+    """Return {"interval", "ignore"} from 'auto_forward', or None if disabled"""
+    value = config.get("auto_forward", False)
+    if value is False:
+        return None
+    if value is True:
+        value = {}
+    return {"interval": value.get("interval", 2), "ignore": set(value.get("ignore", []))}
+
+
+def proc_net_address(hex_addr):
+    """Decode a /proc/net/tcp{,6} address (host byte order words) to an IP string"""
+    raw = bytes.fromhex(hex_addr)
+    if len(raw) == 4:
+        return socket.inet_ntop(socket.AF_INET, raw[::-1])
+    return socket.inet_ntop(socket.AF_INET6, b"".join(raw[i:i + 4][::-1] for i in range(0, 16, 4)))
+
+
+def container_listeners(container_name):
+    """Map of TCP ports with a listening socket inside the container to their bound addresses"""
+    result = run_command(
+        ["docker", "exec", container_name, "sh", "-c", "cat /proc/net/tcp /proc/net/tcp6 2>/dev/null"],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    listeners = {}
+    for line in result.stdout.splitlines():
+        fields = line.split()
+        # st 0A is TCP_LISTEN
+        if len(fields) > 3 and fields[3] == "0A":
+            address, port = fields[1].rsplit(":", 1)
+            listeners.setdefault(int(port, 16), set()).add(proc_net_address(address))
+    return listeners
+
+
+def container_listening_ports(container_name):
+    """TCP ports with a listening socket inside the container"""
+    return set(container_listeners(container_name))
+
+
+def published_container_ports(config):
+    """Container ports published through 'ports' (docker -p syntax)"""
+    ports = set()
+    for spec in config.get("ports", []):
+        container_port = str(spec).rsplit(":", 1)[-1].split("/")[0]
+        if container_port.isdigit():
+            ports.add(int(container_port))
+    return ports
+
+
+def forward_connection(client, container_name, port):
+    """Pipe one host connection to a port inside the container"""
+    proc = subprocess.Popen(
+        ["docker", "exec", "-i", container_name, "node", "-e", FORWARD_SCRIPT, str(port)],
+        stdin=subprocess.PIPE,
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL
+    )
+
+    def upstream():
+        try:
+            while data := client.recv(65536):
+                proc.stdin.write(data)
+                proc.stdin.flush()
+        except OSError:
+            pass
+        finally:
+            proc.stdin.close()
+
+    threading.Thread(target=upstream, daemon=True).start()
+    try:
+        while data := proc.stdout.read1(65536):
+            client.sendall(data)
+    except OSError:
+        pass
+    finally:
+        client.close()
+        proc.wait()
+
+
+def serve_forward(server, container_name, port):
+    """Accept connections on a forwarded host port until the server is closed"""
+    while True:
+        try:
+            client, _ = server.accept()
+        except OSError:
+            return
+        threading.Thread(target=forward_connection, args=(client, container_name, port), daemon=True).start()
+
+
+def watch_ports(container_name, config, settings, stop_event):
+    """Forward new listening container ports to the host until stop_event is set"""
+    skip = published_container_ports(config) | settings["ignore"]
+    forwarded = {}
+    while not stop_event.wait(settings["interval"]):
+        listening = container_listening_ports(container_name)
+        for port in sorted(listening - forwarded.keys() - skip):
+            # Use the same port on the host when it's free
+            try:
+                server = socket.create_server(("127.0.0.1", port))
+            except OSError:
+                server = socket.create_server(("127.0.0.1", 0))
+            forwarded[port] = server
+            threading.Thread(target=serve_forward, args=(server, container_name, port), daemon=True).start()
+            host_port = server.getsockname()[1]
+            sys.stderr.write(f"\r\nvibecon: forwarding http://localhost:{host_port} -> container port {port}\r\n")
+        for port in list(forwarded):
+            if port not in listening:
+                forwarded.pop(port).close()
+                sys.stderr.write(f"\r\nvibecon: stopped forwarding container port {port}\r\n")
+    for server in forwarded.values():
+        server.close()
+
+
+def start_port_forwarding(container_name, config):
+    """Start forwarding listening container ports in the background; returns a stop event or None"""
+    settings = get_auto_forward(config)
+    if settings is None or get_network_name(config, container_name) == "host":
+        return None
+    stop_event = threading.Event()
+    threading.Thread(target=watch_ports, args=(container_name, config, settings, stop_event), daemon=True).start()
+    return stop_event
+
+
+# GUI forwarding: display sockets are mounted at fixed container paths, while
+# DISPLAY/WAYLAND_DISPLAY are passed on every exec since they change between host sessions
+X11_SOCKET_DIR = "/tmp/.X11-unix"
+XAUTH_DIR = Path.home() / ".cache" / "vibecon" / "xauth"
+CONTAINER_XAUTH_DIR = "/tmp/vibecon-xauth"
+DISPLAY_RUNTIME_DIR = "/tmp/vibecon-runtime"
+
+
+def get_display_config(config):
+    """Return {"x11", "wayland"} from 'display', or None if GUI forwarding is off"""
+    value = config.get("display", False)
+    if value is False:
+        return None
+    if value is True:
+        value = {}
+    return {"x11": value.get("x11", True), "wayland": value.get("wayland", True)}
+
+
+def host_wayland_socket():
+    """Path of the host Wayland socket, or None outside a Wayland session"""
+    wayland_display = os.environ.get("WAYLAND_DISPLAY")
+    runtime_dir = os.environ.get("XDG_RUNTIME_DIR")
+    if not wayland_display or not runtime_dir:
+        return None
+    socket_path = Path(runtime_dir) / wayland_display
+    return socket_path if socket_path.exists() else None
+
+
+def display_mount_args(config):
+    """Build docker run arguments mounting the host X11 and Wayland sockets"""
+    display = get_display_config(config)
+    if display is None:
+        return []
+    args = []
+    if display["x11"]:
+        # XQuartz on macOS is reached over TCP instead
+        if sys.platform.startswith("linux") and os.path.isdir(X11_SOCKET_DIR):
+            args.extend(["-v", f"{X11_SOCKET_DIR}:{X11_SOCKET_DIR}"])
+        # Mount the directory, so the cookie file can be refreshed on every exec
+        args.extend(["-v", f"{XAUTH_DIR}:{CONTAINER_XAUTH_DIR}"])
+    wayland_socket = host_wayland_socket() if display["wayland"] else None
+    if wayland_socket:
+        args.extend(["-v", f"{wayland_socket}:{DISPLAY_RUNTIME_DIR}/{wayland_socket.name}"])
+    return args
+
+
+def write_xauth(host_display):
+    """Write the host's X cookie for host_display to XAUTH_DIR; returns False if there is none"""
+    try:
+        result = run_command(["xauth", "nlist", host_display], capture_output=True, text=True)
+    except FileNotFoundError:
+        return False
+    if result.returncode != 0 or not result.stdout.strip():
+        return False
+    # Wildcard the address family so the cookie matches whatever hostname the container has
+    entries = "".join("ffff" + line[4:] + "\n" for line in result.stdout.splitlines())
+    XAUTH_DIR.mkdir(parents=True, exist_ok=True)
+    staging = XAUTH_DIR / "Xauthority.new"
+    staging.unlink(missing_ok=True)
+    merged = run_command(
+        ["xauth", "-f", str(staging), "nmerge", "-"],
+        input=entries,
+        capture_output=True,
+        text=True
+    )
+    if merged.returncode != 0:
+        return False
+    os.replace(staging, XAUTH_DIR / "Xauthority")
+    return True
+
+
+def display_env(config):
+    """DISPLAY, XAUTHORITY and WAYLAND_DISPLAY for exec, from the current host session"""
+    display = get_display_config(config)
+    if display is None:
+        return {}
+    env = {}
+    host_display = os.environ.get("DISPLAY")
+    if display["x11"] and host_display:
+        if sys.platform == "darwin":
+            # XQuartz must allow network clients; DISPLAY is a launchd socket path ending in ":N"
+            env["DISPLAY"] = f"host.docker.internal:{host_display.rpartition(':')[2]}"
+        else:
+            env["DISPLAY"] = host_display
+        if write_xauth(host_display):
+            env["XAUTHORITY"] = f"{CONTAINER_XAUTH_DIR}/Xauthority"
+    wayland_socket = host_wayland_socket() if display["wayland"] else None
+    if wayland_socket:
+        env["WAYLAND_DISPLAY"] = wayland_socket.name
+        env["XDG_RUNTIME_DIR"] = DISPLAY_RUNTIME_DIR
+    if display["x11"] and not host_display and not wayland_socket:
+        warn("'display' is enabled but DISPLAY and WAYLAND_DISPLAY are not set on the host")
+    return env
+
+
+def get_media_config(config):
+    """Return {"audio", "video"} from 'media', or None if media passthrough is off"""
+    value = config.get("media", False)
+    if value is False:
+        return None
+    if value is True:
+        value = {}
+    return {"audio": value.get("audio", True), "video": value.get("video", True)}
+
+
+def media_args(config):
+    """Build docker run arguments passing sound cards, webcams and sound server sockets through"""
+    media = get_media_config(config)
+    if media is None:
+        return []
+    if not sys.platform.startswith("linux"):
+        warn("'media' only works on Linux; Docker Desktop can't pass host devices through")
+        return []
+
+    devices = []
+    args = []
+    if media["audio"]:
+        if os.path.exists("/dev/snd"):
+            devices.append("/dev/snd")
+        runtime_dir = os.environ.get("XDG_RUNTIME_DIR")
+        pulse_socket = Path(runtime_dir or "") / "pulse" / "native"
+        if runtime_dir and pulse_socket.exists():
+            args.extend([
+                "-v", f"{pulse_socket}:{DISPLAY_RUNTIME_DIR}/pulse/native",
+                "-e", f"PULSE_SERVER=unix:{DISPLAY_RUNTIME_DIR}/pulse/native",
+            ])
+            pulse_cookie = Path.home() / ".config" / "pulse" / "cookie"
+            if pulse_cookie.exists():
+                args.extend(["-v", f"{pulse_cookie}:{CONTAINER_HOME}/.config/pulse/cookie:ro"])
+        pipewire_socket = Path(runtime_dir or "") / "pipewire-0"
+        if runtime_dir and pipewire_socket.exists():
+            args.extend([
+                "-v", f"{pipewire_socket}:{DISPLAY_RUNTIME_DIR}/pipewire-0",
+                "-e", f"PIPEWIRE_RUNTIME_DIR={DISPLAY_RUNTIME_DIR}",
+            ])
+    if media["video"]:
+        devices.extend(sorted(glob.glob("/dev/video*")))
+
+    if not devices and not args:
+        warn("'media' is enabled but no sound or video devices were found on the host")
+    # The node user needs the devices' groups (audio, video) to open them. For
+    # /dev/snd that's the group of the nodes inside, the directory is root's;
+    # never hand out the root group itself
+    nodes = [node for device in devices for node in (glob.glob(f"{device}/*") if os.path.isdir(device) else [device])]
+    groups = sorted({os.stat(node).st_gid for node in nodes} - {0})
+    for device in devices:
+        args.extend(["--device", device])
+    for gid in groups:
+        args.extend(["--group-add", str(gid)])
+    return args
+
+
+def map_node_user(container_name, uid, gid, reason):
+    """Change the container's node user to the given UID/GID.
+
+    Used to make files created in bind-mounted directories belong to the
+    invoking user on the host.
+    """
+    if (uid, gid) == (1000, 1000):
+        return
+    print(f"Mapping container user 'node' to UID/GID {uid}:{gid} ({reason})...")
+    # Edit passwd/group directly: usermod would chown the home dir recursively,
+    # crossing into bind mounts. find -xdev stays on the container filesystem.
+    result = run_command(
+        ["docker", "exec", "-u", "root", container_name, "sh", "-c",
+         f"sed -i 's/^node:x:1000:1000:/node:x:{uid}:{gid}:/' /etc/passwd && "
+         f"sed -i 's/^node:x:1000:/node:x:{gid}:/' /etc/group && "
+         f"find /home/node /usr/local/share -xdev -uid 1000 -exec chown -h {uid}:{gid} {{}} +"],
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.PIPE,
+        text=True
+    )
+    if result.returncode != 0:
+        warn(f"Failed to map node user to UID/GID {uid}:{gid}: {result.stderr.strip()}")
+
+# Flags build_run_command() and its *_args() helpers emit without a value;
+# every other flag is followed by its value or written as --flag=value. Add
+# new value-less flags here, or split_run_args() pairs them with the next arg.
+DOCKER_RUN_VALUELESS_FLAGS = {"-d", "--init", "--no-healthcheck", "--privileged", "--publish-all", "--read-only"}
+
+
+def split_run_args(args):
+    """Pair docker run flags with their values ((flag, None) for value-less ones)"""
+    args = iter(args)
+    for arg in args:
+        if arg in DOCKER_RUN_VALUELESS_FLAGS or (arg.startswith("--") and "=" in arg):
+            yield arg, None
+        else:
+            yield arg, next(args)
+
+
+def build_run_command(project_root, container_name, image_name, container_mount_root, config):
+    """Build the docker run command that creates the container.
+
+    Has no side effects besides warnings, so it can also be used to show the
+    resulting docker arguments for a config.
+    """
+    host_term = os.environ.get("TERM", "xterm-256color")
+    container_hostname = get_container_hostname(config, project_root)
+    git_user_name, git_user_email = get_git_user_info()
+    timezone = get_timezone(config)
+
+    # Build docker run command
+    docker_cmd = [
+        "docker", "run",
+        "-d",
+        "--name", container_name,
+        "-w", container_mount_root,
+        "-e", f"TERM={host_term}",
+        "-e", "COLORTERM=truecolor",
+        "-e", f"TZ={timezone}",
+        # Lets 'vibecon ui' and friends map containers back to their projects
+        "--label", f"vibecon.project={project_root}",
+    ] + env_args(locale_env(config))
+
+    # Attach to the configured network
+    network_name = get_network_name(config, container_name)
+    if network_name:
+        docker_cmd.extend(["--network", network_name])
+    docker_cmd.extend(network_alias_args(config, network_name))
+    if network_name == "host":
+        # Docker Desktop runs containers in a VM, whose network isn't the host's
+        if not sys.platform.startswith("linux"):
+            warn("host networking only reaches the host on Linux; publish ports with 'ports' or --port instead")
+        if config.get("ports") or config.get("publish_all"):
+            warn("'ports' and 'publish_all' have no effect with host networking")
+
+    # Add host entries and DNS settings
+    docker_cmd.extend(dns_args(config, network_name))
+
+    # Publish the SSH server on localhost
+    docker_cmd.extend(ssh_args(config, network_name))
+
+    # Host networking shares the host's UTS namespace, so hostname can't be set
+    if network_name != "host":
+        docker_cmd.extend(["--hostname", container_hostname])
+        for alias in as_list(config.get("host_aliases")):
+            docker_cmd.extend(["--add-host", f"{alias}:127.0.0.1"])
+
+    # Add privilege, capability and security profile settings
+    docker_cmd.extend(security_args(config))
+
+    # Give access to a Docker engine if requested
+    docker_cmd.extend(docker_access_args(config, container_name))
+
+    # Egress firewall rules are applied by root inside the container's netns
+    if get_network_policy(config) is not None:
+        if network_name == "host":
+            fail("config-invalid", "'network_policy' cannot be combined with host networking")
+        check_network_policy_enforceable(config)
+        docker_cmd.extend(["--cap-add", "NET_ADMIN", "--cap-add", "NET_RAW"])
+
+    # Run tini as PID 1 so orphaned agent child processes get reaped
+    if config.get("init", True):
+        docker_cmd.append("--init")
+
+    # Rootless Podman: map the host user to node so bind mounts stay writable
+    engine = get_engine_info()
+    if engine["podman"] and engine["rootless"]:
+        docker_cmd.append("--userns=keep-id:uid=1000,gid=1000")
+
+    # Add healthcheck so wedged containers are detected instead of hanging exec
+    docker_cmd.extend(healthcheck_args(config))
+
+    # Pass host proxy settings through
+    docker_cmd.extend(env_args(get_proxy_env(config)))
+
+    # Add git user environment variables if available
+    if git_user_name:
+        docker_cmd.extend([
+            "-e", f"GIT_USER_NAME={git_user_name}",
+            "-e", f"GIT_USER_EMAIL={git_user_email}",
+        ])
+
+    # Name the compose services, then add environment variables from config
+    # (also passed on every exec)
+    docker_cmd.extend(env_args(compose_env(project_root, config)))
+    docker_cmd.extend(env_args(config.get("env", {})))
+
+    # Keep injected secrets in memory only
+    if config.get("secrets") or stored_secret_names() or get_git_credential_hosts(config) or config.get("git_signing"):
+        docker_cmd.extend(["--tmpfs", f"{SECRETS_DIR}:mode=0755"])
+
+    # Use an alternative OCI runtime if configured
+    docker_cmd.extend(runtime_args(config))
+
+    # Add resource limits
+    if config.get("cpus"):
+        docker_cmd.extend(["--cpus", str(config["cpus"])])
+    if config.get("memory"):
+        docker_cmd.extend(["--memory", config["memory"]])
+    if config.get("shm_size"):
+        docker_cmd.extend(["--shm-size", str(config["shm_size"])])
+    docker_cmd.extend(ulimit_args(config))
+    docker_cmd.extend(logging_args(config))
+    for key, value in config.get("sysctls", {}).items():
+        docker_cmd.extend(["--sysctl", f"{key}={value}"])
+
+    # Publish ports
+    for port in config.get("ports", []):
+        docker_cmd.extend(["-p", str(port)])
+    if config.get("publish_all", False):
+        docker_cmd.append("--publish-all")
+
+    # Add main workspace mount; in sync mode the workspace lives in a volume,
+    # in sandbox mode in a scratch volume next to a read-only host copy
+    if config.get("sandbox", False):
+        docker_cmd.extend(volume_mount_args(container_name, container_mount_root, None, {}, False))
+        docker_cmd.extend(["-v", f"{project_root}:{SANDBOX_SOURCE}:ro"])
+    elif get_workspace_mode(config) == "sync":
+        docker_cmd.extend(volume_mount_args(workspace_volume_name(project_root), container_mount_root, None, {}, False))
+    else:
+        docker_cmd.extend(["-v", f"{project_root}:{container_mount_root}"])
+
+    # Back I/O heavy workspace directories (node_modules) with volumes
+    docker_cmd.extend(volume_overlay_args(project_root, container_mount_root, config))
+
+    # Mask excluded workspace directories
+    docker_cmd.extend(workspace_exclude_args(container_mount_root, config))
+
+    # Add extra mounts from config
+    for mount_spec in config.get("mounts", []):
+        mount_args = parse_mount(mount_spec, project_root, container_name)
+        docker_cmd.extend(mount_args)
+
+    # Keep shell history across container recreation
+    docker_cmd.extend(shell_history_args(project_root, config))
+
+    # Share agent logins and state across all containers
+    docker_cmd.extend(shared_auth_args(config))
+
+    # Use the host's ~/.claude directly instead of copies
+    docker_cmd.extend(claude_config_mount_args(config))
+
+    # Share package manager caches across all containers
+    docker_cmd.extend(cache_args(config))
+
+    # Mount cloud CLI credentials read-only
+    docker_cmd.extend(cloud_credential_mount_args(config))
+    docker_cmd.extend(kubeconfig_mount_args(config))
+
+    # Forward the host gpg-agent for commit signing
+    docker_cmd.extend(git_signing_mount_args(config))
+
+    # Share the host display with GUI tools
+    docker_cmd.extend(display_mount_args(config))
+
+    # Pass sound and video devices through
+    docker_cmd.extend(media_args(config))
+
+    # Shell hook that logs commands for 'vibecon audit'
+    docker_cmd.extend(audit_args(config))
+
+    # Add image name
+    docker_cmd.append(image_name)
+
+    return docker_cmd
+
+
+def start_container(project_root, container_name, image_name, container_mount_root, config=None):
+    """Start the container in detached mode
+
+    Args:
+        project_root: Host path to mount as project root
+        container_name: Name for the container
+        image_name: Docker image to use
+        container_mount_root: Path inside container where project_root is mounted
+        config: Optional config with mounts
+    """
+    if config is None:
+        config = {"mounts": []}
+
+    # Get git user info from host
+    git_user_name, git_user_email = get_git_user_info()
+    if git_user_name:
+        print(f"Configuring git user: {git_user_name} <{git_user_email}>")
+
+    print(f"Configuring timezone: {get_timezone(config)}, locale: {get_locale(config)}")
+
+    # Create user-defined networks before the container joins them
+    network_name = get_network_name(config, container_name)
+    if network_name:
+        ensure_network(network_name, config.get("network_subnet"))
+
+    check_runtime_available(config)
+
+    # Docker would create a missing bind mount source as root
+    if get_claude_config_mode(config) != "copy":
+        (Path.home() / ".claude").mkdir(exist_ok=True)
+    display = get_display_config(config)
+    if display and display["x11"]:
+        XAUTH_DIR.mkdir(parents=True, exist_ok=True)
+
+    print(f"Starting container '{container_name}' with {project_root} mounted at {container_mount_root}...")
+    docker_cmd = build_run_command(project_root, container_name, image_name, container_mount_root, config)
+
+    # Start container detached with sleep infinity to keep it running
+    # No timeout: a retry after a timed out 'docker run' would hit the half-created container
+    run_result = run_docker(docker_cmd, timeout=0, stdout=subprocess.PIPE)
+
+    if run_result.returncode != 0:
+        stderr = run_result.stderr.decode().strip()
+        fail(docker_error_category(stderr), f"Failed to start container: {stderr}")
+
+    if config.get("audit", False):
+        install_audit_hook(container_name)
+
+    engine = get_engine_info()
+    if engine["rootless"] and not engine["podman"]:
+        # Rootless Docker maps the host user to container root, so bind mounts
+        # are root-owned inside. Make node uid 0 so it can write to them.
+        map_node_user(container_name, 0, 0, "rootless Docker")
+    elif config.get("host_user", False) and sys.platform.startswith("linux") and not engine["rootless"]:
+        if os.getuid() != 0:
+            map_node_user(container_name, os.getuid(), os.getgid(), "host_user")
+
+    # Fresh volumes are root-owned unless the image has the directory
+    shared_dirs = [f"{CONTAINER_HOME}/{SHARED_AUTH_DIRS[agent]}" for agent in get_shared_auth_agents(config)]
+    if config.get("shell_history", True):
+        shared_dirs.append(SHELL_HISTORY_DIR)
+    if shared_dirs:
+        run_command(
+            ["docker", "exec", "-u", "root", container_name, "chown", "-R", "node:node"] + shared_dirs,
+            stdout=subprocess.DEVNULL,
+            stderr=subprocess.DEVNULL
+        )
+
+    # Caches and overlays can be large, so only fix the mount points and the
+    # parents Docker created for caches (overlay parents are in the bind mount)
+    cache_dirs = sorted({d for path in cache_paths(config).values() for d in (path, posixpath.dirname(path))})
+    cache_dirs += list(volume_overlay_paths(project_root, container_mount_root, config).values())
+    if cache_dirs:
+        run_command(
+            ["docker", "exec", "-u", "root", container_name, "chown", "node:node"] + cache_dirs,
+            stdout=subprocess.DEVNULL,
+            stderr=subprocess.DEVNULL
+        )
+
+    ensure_locale(container_name, get_locale(config))
+
+def warn_if_outdated_image(container_name):
+    """Warn when the image the container was created from has been rebuilt or pulled since.
+
+    Compares with the container's own image reference, like 'vibecon list',
+    so containers restored from a snapshot aren't flagged.
+    """
+    result = run_command(
+        ["docker", "inspect", "-f", "{{.Image}} {{.Config.Image}}", container_name],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    fields = result.stdout.split()
+    if result.returncode != 0 or len(fields) != 2:
+        return
+    image_id, image = fields
+    result = run_command(
+        ["docker", "image", "inspect", "-f", "{{.Id}}", image],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    current_id = result.stdout.strip()
+    if result.returncode == 0 and current_id and current_id != image_id:
+        warn(f"'{container_name}' runs an outdated {image} image; recreate it with 'vibecon --recreate' "
+             "(keeps volumes) or all containers with 'vibecon upgrade'")
+
+
+def ensure_container_running(project_root, vibecon_root, container_name, image_name, container_mount_root, config=None):
+    """Ensure container is running and healthy
+
+    Running containers that report unhealthy are removed and recreated rather
+    than exec'd into.
+
+    Args:
+        project_root: Host path to mount as project root
+        vibecon_root: Path to vibecon installation (for building image)
+        container_name: Name for the container
+        image_name: Docker image to use
+        container_mount_root: Path inside container where project_root is mounted
+        config: Optional config with mounts
+    """
+    if config is None:
+        config = {"mounts": []}
+
+    # The default check can't fail, so don't sit out its first probe interval
+    healthcheck = get_healthcheck_config(config)
+    wait_timeout = healthcheck["wait_timeout"] if healthcheck and healthcheck["cmd"] != DEFAULT_HEALTHCHECK["cmd"] else 0
+
+    # Compose services come first: the container and the dind sidecar join
+    # their network, which compose must create itself
+    if config.get("compose") and not is_container_running(container_name):
+        compose_up(project_root, container_name, config)
+
+    # The dind sidecar runs independently and must be up before any exec
+    if get_docker_access(config) == "dind":
+        network_name = get_network_name(config, container_name)
+        ensure_network(network_name, config.get("network_subnet"))
+        ensure_dind_sidecar(container_name, network_name, config)
+
+    # Only an unhealthy container is recreated; a slow one keeps its state
+    if is_container_running(container_name):
+        if wait_for_healthy(container_name, wait_timeout) != "unhealthy":
+            warn_if_outdated_image(container_name)
+            return  # Running, nothing to do
+        print(f"Container '{container_name}' is unhealthy, recreating...")
+        remove_container(container_name)
+    elif container_exists(container_name):
+        # Container is not running but exists (stopped/dead) - try to restart it
+        if restart_container(container_name) and wait_for_healthy(container_name, wait_timeout) != "unhealthy":
+            warn_if_outdated_image(container_name)
+            return  # Successfully restarted
+        # Restart failed, remove and recreate
+        print("Restart failed, removing container and creating a new one...")
+        remove_container(container_name)
+
+    # Build image only if it doesn't exist; custom images are pulled instead
+    if not image_exists(image_name):
+        if image_name == IMAGE_NAME:
+            print(f"Image '{image_name}' not found, building...")
+            build_image(vibecon_root, image_name, config=config)
+        else:
+            pull_image(image_name)
+    start_container(project_root, container_name, image_name, container_mount_root, config)
+
+    if wait_for_healthy(container_name, wait_timeout) == "unhealthy":
+        fail(None, f"Container '{container_name}' did not become healthy",
+             f"Check 'docker inspect {container_name}' for healthcheck output")
+
+
+# Written once all 'on_create' commands succeeded; lives and dies with the container
+ON_CREATE_MARKER = f"{CONTAINER_HOME}/.vibecon-on-create-done"
+
+
+def format_on_create(spec):
+    """An 'on_create' entry as a shell command line: strings as they are, lists quoted"""
+    return spec if isinstance(spec, str) else shlex.join(spec)
+
+
+def run_on_create(container_name, config, container_mount_root, secret_env_names=()):
+    """Run the 'on_create' commands in the workspace root the first time a container is used.
+
+    Runs after the workspace is in place (sync mode, sandbox) and secrets are
+    injected. A failed command stops vibecon, and the commands run again on
+    the next invocation until they all succeed.
+    """
+    commands = config.get("on_create", [])
+    if not commands:
+        return
+    result = run_command(
+        ["docker", "exec", container_name, "test", "-f", ON_CREATE_MARKER],
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.DEVNULL
+    )
+    if result.returncode == 0:
+        return
+    env = passthrough_env_args(config) + env_args(get_proxy_env(config)) + env_args(config.get("env", {}))
+    for spec in commands:
+        command = ["sh", "-c", spec] if isinstance(spec, str) else spec
+        print(f"Running on_create: {format_on_create(spec)}")
+        result = run_command(
+            ["docker", "exec", "-w", container_mount_root] + env + [container_name] + wrap_with_secret_env(command, secret_env_names)
+        )
+        if result.returncode != 0:
+            fail(None, f"on_create command failed with exit code {result.returncode}: {format_on_create(spec)}",
+                 "Fix the command or its cause and run vibecon again; on_create runs until all commands succeed")
+    run_command(["docker", "exec", container_name, "touch", ON_CREATE_MARKER], stdout=subprocess.DEVNULL, stderr=subprocess.DEVNULL)
+
+
+# Readiness checks from 'wait_for', run inside the container, so names resolve
+# as the agent sees them; node is always in the image
+WAIT_FOR_KINDS = ("tcp", "http", "file", "command")
+DEFAULT_WAIT_FOR_TIMEOUT = 60
+TCP_CHECK_SCRIPT = (
+    'const [h,p]=[process.argv[1].replace(/:[^:]*$/,""),+process.argv[1].split(":").pop()];'
+    'const s=require("net").connect(p,h);s.on("connect",()=>process.exit(0));'
+    's.on("error",()=>process.exit(1));setTimeout(()=>process.exit(1),2000)'
+)
+HTTP_CHECK_SCRIPT = (
+    'fetch(process.argv[1],{signal:AbortSignal.timeout(5000)})'
+    '.then(r=>process.exit(r.ok?0:1),()=>process.exit(1))'
+)
+
+
+def wait_for_check(entry):
+    """Return (shell check, description) for a 'wait_for' entry"""
+    kinds = [kind for kind in WAIT_FOR_KINDS if kind in entry]
+    if len(kinds) != 1:
+        fail("config-invalid", f"Each 'wait_for' entry needs exactly one of {', '.join(WAIT_FOR_KINDS)}, got: {json.dumps(entry)}")
+    kind = kinds[0]
+    value = entry[kind]
+    if kind == "tcp":
+        return f"node -e {shlex.quote(TCP_CHECK_SCRIPT)} {shlex.quote(value)}", value
+    if kind == "http":
+        return f"node -e {shlex.quote(HTTP_CHECK_SCRIPT)} {shlex.quote(value)}", value
+    if kind == "file":
+        return f"test -e {shlex.quote(value)}", value
+    command = value if isinstance(value, str) else shlex.join(value)
+    return f"sh -c {shlex.quote(command)} >/dev/null 2>&1", f"'{command}'"
+
+
+def wait_for_ready(container_name, config, container_mount_root):
+    """Poll the 'wait_for' checks until each passes, failing after its timeout"""
+    env = passthrough_env_args(config) + env_args(get_proxy_env(config)) + env_args(config.get("env", {}))
+    for entry in config.get("wait_for", []):
+        check, description = wait_for_check(entry)
+        timeout = entry.get("timeout", DEFAULT_WAIT_FOR_TIMEOUT)
+        script = (
+            f"end=$(( $(date +%s) + {int(timeout)} )); "
+            f"until {check}; do [ $(date +%s) -ge $end ] && exit 1; sleep 1; done"
+        )
+        print(f"Waiting for {description}...")
+        result = run_command(["docker", "exec", "-w", container_mount_root] + env + [container_name, "sh", "-c", script])
+        if result.returncode != 0:
+            fail(None, f"Timed out after {timeout}s waiting for {description}",
+                 "Check that the service is started, or raise the entry's 'timeout'")
+
+def find_config_file():
+    """Find the nearest config file in the current directory or its parents"""
+    current = Path(os.getcwd()).resolve()
+    while True:
+        config_path = find_config_in_dir(current)
+        if config_path:
+            return config_path
+        if current.parent == current:
+            return None
+        current = current.parent
+
+
+def validate_config_files():
+    """Validate global and project config files. Returns exit code."""
+    paths = [global_config_path()]
+    project_config = find_config_file()
+    if project_config and project_config not in paths:
+        paths.append(project_config)
+
+    ok = True
+    for path in paths:
+        if not path.exists():
+            print(f"Skipped: {path} (not found)")
+            continue
+        try:
+            config = read_config_file(path)
+        except ValueError as e:
+            print(f"Invalid: {path}")
+            print(f"  {e}")
+            ok = False
+            continue
+        errors = validate_config(config)
+        if errors:
+            print(f"Invalid: {path}")
+            for error in errors:
+                print(f"  {error}")
+            ok = False
+        else:
+            print(f"Valid: {path}")
+    return 0 if ok else 1
+
+
+def format_docker_command(docker_cmd):
+    """Format a docker command one flag per line for display"""
+    lines = [shlex.join(docker_cmd[:2])]
+    i = 2
+    while i < len(docker_cmd):
+        arg = docker_cmd[i]
+        if arg.startswith("-") and "=" not in arg and i + 1 < len(docker_cmd) and not docker_cmd[i + 1].startswith("-"):
+            lines.append(f"  {shlex.quote(arg)} {shlex.quote(docker_cmd[i + 1])}")
+            i += 2
+        else:
+            lines.append(f"  {shlex.quote(arg)}")
+            i += 1
+    return " \\\n".join(lines)
+
+
+def show_config(profile_names):
+    """Print the effective merged config annotated by source file, plus docker run arguments"""
+    project_root, root_config, container_mount_root = find_project_root()
+    container_name = generate_container_name(project_root, profile_names)
+    base_config = get_merged_config(root_config, container_mount_root)
+    config = layer_config(apply_profiles(base_config, profile_names), env_overrides())
+
+    global_source = str(global_config_path())
+    project_source = str(find_config_in_dir(project_root))
+    print(f"Project root: {project_root}")
+    print(f"Container:    {container_name}")
+
+    # Each file's own entries, without anything layered in through 'extends'
+    own = {}
+    for source in (global_source, project_source):
+        own[source] = expand_config(read_config_file(source)) if os.path.exists(source) else {}
+
+    def describe_source(key, mount_spec=None):
+        if mount_spec is None and key in env_overrides():
+            return "environment (VIBECON_*)"
+        if mount_spec is None and base_config.get(key) != config[key]:
+            return "profile"
+        if mount_spec is not None and not any(mount_spec is m for m in base_config["mounts"]):
+            return "profile"
+        in_project = key in root_config if mount_spec is None else any(mount_spec is m for m in root_config.get("mounts", []))
+        source = project_source if in_project else global_source
+        if mount_spec is None:
+            direct = key in own[source]
+        else:
+            direct = mount_spec in own[source].get("mounts", [])
+        return source if direct else f"{source} (via extends)"
+
+    print("\nSettings:")
+    for key, value in config.items():
+        if key == "mounts":
+            continue
+        print(f"  {key}: {json.dumps(value)}  # {describe_source(key)}")
+
+    print("\nMounts:")
+    print(f"  {project_root} -> {container_mount_root}  # workspace")
+    for mount_spec in config["mounts"]:
+        print(f"  {json.dumps(mount_spec)}  # {describe_source('mounts', mount_spec)}")
+
+    print("\nDocker run command:")
+    docker_cmd = build_run_command(project_root, container_name, config.get("image", IMAGE_NAME), container_mount_root, config)
+    print(format_docker_command(docker_cmd))
+    return 0
+
+
+def get_container_workdir(cwd, project_root, container_mount_root):
+    """Working directory inside the container: cwd's path relative to project_root under the mount root"""
+    # If cwd is nested under project_root, calculate relative path
+    try:
+        rel_path = os.path.relpath(cwd, project_root)
+        if rel_path == ".":
+            container_workdir = container_mount_root
+        elif rel_path.startswith(".."):
+            # cwd is not under project_root, use mount root
+            container_workdir = container_mount_root
+        else:
+            container_workdir = os.path.join(container_mount_root, rel_path)
+    except ValueError:
+        # Different drives on Windows
+        container_workdir = container_mount_root
+    return container_workdir
+
+
+def watched_sync_paths(config):
+    """Host paths whose changes trigger a resync in watch mode"""
+    claude_dir = Path.home() / ".claude"
+    paths = [claude_dir / "settings.json", claude_dir / "CLAUDE.md", Path.home() / ".claude.json"]
+    paths += [claude_dir / dir_name for dir_name in CLAUDE_SYNCED_DIRS]
+    if config.get("gitconfig", False):
+        paths.append(Path.home() / ".gitconfig")
+    if get_kubeconfig_config(config):
+        paths.append(host_kubeconfig_path())
+    for provider, settings in get_cloud_credentials(config).items():
+        if settings["mode"] == "sync":
+            paths.append(Path.home() / CLOUD_CREDENTIAL_DIRS[provider])
+    return paths
+
+
+def sync_snapshot(paths):
+    """Map each file under paths to its (mtime, size), following directory symlinks"""
+    snapshot = {}
+    for path in paths:
+        if not path.exists():
+            continue
+        files = [path] if path.is_file() else path.resolve().rglob("*")
+        for file in files:
+            try:
+                stat = file.stat()
+            except OSError:
+                continue
+            snapshot[str(file)] = (stat.st_mtime_ns, stat.st_size)
+    return snapshot
+
+
+def sync_command(argv):
+    """vibecon sync [--watch] - push host config into the running container"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon sync",
+        description="Sync Claude config, MCP servers, credentials and dotfiles into the running container"
+    )
+    parser.add_argument("-w", "--watch", action="store_true", help="keep running and resync whenever host files change")
+    parser.add_argument("--interval", type=float, default=1.0, metavar="SECONDS", help="polling interval for --watch (default: 1)")
+    parser.add_argument("-p", "--profile", action="append", default=[], metavar="NAME", help="apply a named profile")
+    args = parser.parse_args(argv)
+
+    project_root, root_config, container_mount_root = find_project_root()
+    profile_names = args.profile or env_profiles()
+    container_name = generate_container_name(project_root, profile_names)
+    config = layer_config(apply_profiles(get_merged_config(root_config, container_mount_root), profile_names), env_overrides())
+    container_workdir = get_container_workdir(os.getcwd(), project_root, container_mount_root)
+
+    if not is_container_running(container_name):
+        fail(None, f"Container '{container_name}' is not running; start it with vibecon first")
+
+    sync_workspace(container_name, project_root, container_mount_root, config)
+    sync_to_container(container_name, config, container_workdir)
+    success(f"Synced config into '{container_name}'")
+    if not args.watch:
+        return 0
+
+    # Poll instead of using OS file events, which need third-party modules
+    print("Watching for changes (Ctrl+C to stop)...")
+    paths = watched_sync_paths(config)
+    snapshot = sync_snapshot(paths)
+    try:
+        while True:
+            time.sleep(args.interval)
+            # In workspace sync mode the workspace itself is reconciled continuously
+            sync_workspace(container_name, project_root, container_mount_root, config)
+            current = sync_snapshot(paths)
+            if current == snapshot:
+                continue
+            changed = sorted(set(current.items()) ^ set(snapshot.items()))
+            snapshot = current
+            if not is_container_running(container_name):
+                print(f"Container '{container_name}' stopped, exiting")
+                return 0
+            sync_to_container(container_name, config, container_workdir)
+            names = sorted({Path(path).name for path, _ in changed})
+            print(f"[{time.strftime('%H:%M:%S')}] Synced: {', '.join(names)}")
+    except KeyboardInterrupt:
+        return 0
+
+
+def container_published_ports(container_name):
+    """Map of published container ports ("3000/tcp") to their host bindings, from 'docker port'"""
+    result = run_command(["docker", "port", container_name], capture_output=True, text=True)
+    published = {}
+    for line in result.stdout.splitlines():
+        container_port, _, host_binding = line.partition(" -> ")
+        if host_binding:
+            published.setdefault(container_port.strip(), []).append(host_binding.strip())
+    return published
+
+
+def port_command(argv):
+    """vibecon port - list published ports and listeners in the container"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon port",
+        description="List the container's published ports and the servers listening inside it"
+    )
+    parser.add_argument("--json", action="store_true", help="print JSON instead of a table")
+    parser.add_argument("-p", "--profile", action="append", default=[], metavar="NAME", help="apply a named profile")
+    args = parser.parse_args(argv)
+
+    project_root, root_config, container_mount_root = find_project_root()
+    container_name = generate_container_name(project_root, args.profile or env_profiles())
+    if not is_container_running(container_name):
+        fail(None, f"Container '{container_name}' is not running; start it with vibecon first")
+
+    host_network = get_container_network_mode(container_name) == "host"
+    published = container_published_ports(container_name)
+    listeners = container_listeners(container_name)
+
+    rows = []
+    ports = {int(spec.split("/")[0]) for spec in published if spec.endswith("/tcp")} | set(listeners)
+    for port in sorted(ports):
+        addresses = sorted(listeners.get(port, []))
+        if host_network:
+            host = [f"{address}:{port}" for address in addresses]
+        else:
+            host = published.get(f"{port}/tcp", [])
+        rows.append({"port": port, "listening": addresses, "host": host})
+    # UDP can't be probed by listener state, so only published ones are shown
+    for spec, host in sorted(published.items()):
+        if not spec.endswith("/tcp"):
+            rows.append({"port": spec, "listening": [], "host": host})
+
+    if args.json:
+        print(json.dumps(rows, indent=2))
+        return 0
+    if not rows:
+        print("No published ports and nothing listening in the container.")
+        return 0
+
+    print(f"{'PORT':<12}{'LISTENING ON':<24}HOST")
+    for row in rows:
+        listening = ", ".join(row["listening"]) or "-"
+        host = ", ".join(row["host"]) or "-"
+        print(f"{str(row['port']):<12}{listening:<24}{host}")
+
+    # Docker forwards published ports to the container's external interface
+    if not host_network:
+        for row in rows:
+            if row["host"] and row["listening"] and not set(row["listening"]) & {"0.0.0.0", "::"}:
+                warn(f"port {row['port']} is published but only listens on "
+                     f"{', '.join(row['listening'])}; bind it to 0.0.0.0 to reach it from the host")
+    return 0
+
+
+# Touched before every exec; the mtime is a container's last activity
+ACTIVITY_DIR = Path.home() / ".cache" / "vibecon" / "activity"
+
+
+def record_activity(container_name):
+    """Remember that a command was just run in the container"""
+    ACTIVITY_DIR.mkdir(parents=True, exist_ok=True)
+    (ACTIVITY_DIR / container_name).touch()
+
+
+def last_activity(container_name):
+    """Time of the last command run in the container, or None"""
+    try:
+        return (ACTIVITY_DIR / container_name).stat().st_mtime
+    except OSError:
+        return None
+
+
+# Commands run in the container with 'audit' on: bash and zsh in the container
+# append to AUDIT_SPOOL, which vibecon moves into a host log per container.
+# Best-effort: the spool is writable by the agent, and dash (/bin/sh) and
+# programs exec'd without a shell aren't hooked.
+AUDIT_DIR = Path.home() / ".local" / "state" / "vibecon" / "audit"
+AUDIT_HOOK = "/etc/vibecon/audit.sh"
+AUDIT_SPOOL = "/var/spool/vibecon-audit"
+
+# Sourced by zsh (zshenv) and bash (BASH_ENV, bash.bashrc). Non-interactive
+# shells log their -c string, which is how agents run commands; interactive
+# ones log each command line.
+AUDIT_HOOK_SCRIPT = r"""
+_vibecon_audit() {
+  printf '%s\t%s\t%s\n' "$(date -u +%Y-%m-%dT%H:%M:%SZ)" "$PWD" "$(printf '%s' "$1" | tr '\n\t' '  ')" \
+    >> /var/spool/vibecon-audit/commands.log 2>/dev/null
+}
+if [ -n "$ZSH_VERSION" ]; then
+  if [[ -o interactive ]]; then
+    autoload -Uz add-zsh-hook
+    _vibecon_audit_preexec() { _vibecon_audit "$1"; }
+    add-zsh-hook preexec _vibecon_audit_preexec
+  elif [ -n "$ZSH_EXECUTION_STRING" ]; then
+    _vibecon_audit "$ZSH_EXECUTION_STRING"
+  fi
+elif [ -n "$BASH_VERSION" ]; then
+  case $- in
+    *i*) trap '[ -z "$COMP_LINE" ] && [ "$BASH_COMMAND" != "$PROMPT_COMMAND" ] && _vibecon_audit "$BASH_COMMAND"' DEBUG ;;
+    *) [ -n "$BASH_EXECUTION_STRING" ] && _vibecon_audit "$BASH_EXECUTION_STRING" ;;
+  esac
+fi
+"""
+
+
+def audit_log_path(container_name):
+    return AUDIT_DIR / f"{container_name}.log"
+
+
+def audit_args(config):
+    """docker run arguments for the audit hook in non-interactive bash"""
+    if not config.get("audit", False):
+        return []
+    return ["-e", f"BASH_ENV={AUDIT_HOOK}"]
+
+
+def install_audit_hook(container_name):
+    """Install the shell hook and the spool directory in a new container.
+
+    Both are root-owned, so the agent can't change the hook. The spool is
+    writable by everyone, as the hook runs as the agent's user, which also
+    means the agent can truncate it or add entries before it is collected.
+    """
+    script = (
+        f"mkdir -p {posixpath.dirname(AUDIT_HOOK)} {AUDIT_SPOOL} && "
+        f"cat > {AUDIT_HOOK} && chmod 644 {AUDIT_HOOK} && chmod 1733 {AUDIT_SPOOL} && "
+        f"echo '. {AUDIT_HOOK}' >> /etc/zsh/zshenv && "
+        f"echo '. {AUDIT_HOOK}' >> /etc/bash.bashrc"
+    )
+    result = run_command(
+        ["docker", "exec", "-i", "-u", "root", container_name, "sh", "-c", script],
+        input=AUDIT_HOOK_SCRIPT,
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.PIPE,
+        text=True
+    )
+    if result.returncode != 0:
+        warn(f"Failed to install the audit hook: {result.stderr.strip()}")
+
+
+def append_audit_entries(container_name, entries):
+    """Append entries to the container's host-side audit log"""
+    AUDIT_DIR.mkdir(parents=True, exist_ok=True)
+    path = audit_log_path(container_name)
+    with open(path, "a") as log:
+        for entry in entries:
+            log.write(json.dumps(entry) + "\n")
+    path.chmod(0o600)
+
+
+def audit_exec(container_name, command, workdir):
+    """Log a command vibecon itself runs in the container"""
+    append_audit_entries(container_name, [{
+        "time": time.strftime("%Y-%m-%dT%H:%M:%SZ", time.gmtime()),
+        "source": "exec",
+        "cwd": workdir,
+        "command": shlex.join(command),
+    }])
+
+
+def collect_audit_log(container_name):
+    """Move commands logged inside the container into the host-side audit log.
+
+    The spool file is renamed before reading, so commands logged meanwhile
+    go to a new file and are collected next time.
+    """
+    result = run_command(
+        ["docker", "exec", "-u", "root", container_name, "sh", "-c",
+         f"cd {AUDIT_SPOOL} 2>/dev/null && [ -f commands.log ] && "
+         "mv commands.log collecting.log && cat collecting.log && rm collecting.log"],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    entries = []
+    for line in result.stdout.splitlines():
+        parts = line.split("\t", 2)
+        if len(parts) == 3:
+            entries.append({"time": parts[0], "source": "shell", "cwd": parts[1], "command": parts[2]})
+    if entries:
+        append_audit_entries(container_name, entries)
+
+
+def read_audit_log(container_name):
+    path = audit_log_path(container_name)
+    if not path.exists():
+        return []
+    entries = []
+    with open(path) as log:
+        for line in log:
+            try:
+                entries.append(json.loads(line))
+            except ValueError:
+                continue
+    return entries
+
+
+def audit_command(argv):
+    """vibecon audit - show commands run in the workspace's container"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon audit",
+        description="Show the audit log of commands run in the container (needs \"audit\": true)"
+    )
+    parser.add_argument("-n", "--lines", type=int, default=50, metavar="N", help="show the last N entries (default: 50, 0 for all)")
+    parser.add_argument("-g", "--grep", metavar="PATTERN", help="only entries whose command matches this regex")
+    parser.add_argument("--json", action="store_true", help="print entries as JSON lines")
+    parser.add_argument("-p", "--profile", action="append", help="profile of the container")
+    args = parser.parse_args(argv)
+
+    project_root, _, _ = find_project_root()
+    container_name = generate_container_name(project_root, args.profile or env_profiles())
+    if is_container_running(container_name):
+        collect_audit_log(container_name)
+
+    entries = read_audit_log(container_name)
+    if args.grep:
+        try:
+            pattern = re.compile(args.grep)
+        except re.error as e:
+            fail(None, f"Invalid pattern: {e}")
+        entries = [entry for entry in entries if pattern.search(entry.get("command", ""))]
+    if args.lines > 0:
+        entries = entries[-args.lines:]
+
+    if args.json:
+        for entry in entries:
+            print(json.dumps(entry))
+        return 0
+    if not entries:
+        print(f"No audited commands for '{container_name}'.")
+        return 0
+    for entry in entries:
+        source = style(f"{entry.get('source', ''):<5}", "cyan")
+        print(f"{entry.get('time', '')}  {source}  {entry.get('cwd', '')}  {entry.get('command', '')}")
+    return 0
+
+
+
+# Keys and ssh config entries for 'ssh': vibecon keeps its own client key and a
+# fixed host key, so recreated containers keep the same identity
+SSH_DIR = Path.home() / ".config" / "vibecon" / "ssh"
+SSH_HOST_KEY_ALIAS = "vibecon"
+SSH_SERVER_DIR = "/etc/vibecon/ssh"
+
+# Run as root on every invocation: sshd is not restarted with the container.
+# Sessions get the container's environment through SetEnv, as sshd doesn't
+# pass it on by itself.
+SSH_SERVER_SCRIPT = f"""
+set -e
+command -v sshd >/dev/null || {{ echo "openssh-server is not installed in the image" >&2; exit 3; }}
+mkdir -p {SSH_SERVER_DIR} /run/sshd
+IFS= read -r authorized_key
+printf '%s\\n' "$authorized_key" > {SSH_SERVER_DIR}/authorized_keys
+cat > {SSH_SERVER_DIR}/host_key
+chmod 600 {SSH_SERVER_DIR}/host_key
+chmod 644 {SSH_SERVER_DIR}/authorized_keys
+tr '\\0' '\\n' < /proc/1/environ | grep -v -e '^HOME=' -e '^HOSTNAME=' -e '^$' \\
+  | sed 's/\\\\/\\\\\\\\/g; s/"/\\\\"/g; s/^/SetEnv "/; s/$/"/' > /etc/ssh/sshd_config.d/vibecon-env.conf
+pgrep -x sshd >/dev/null || /usr/sbin/sshd
+"""
+
+
+def get_ssh_config(config):
+    """Return {"port"} from 'ssh', or None if the SSH server is off. port None lets Docker pick one."""
+    value = config.get("ssh", False)
+    if value is False:
+        return None
+    if value is True:
+        value = {}
+    return {"port": value.get("port")}
+
+
+def ssh_args(config, network_name):
+    """Build docker run arguments publishing the SSH server on a localhost port"""
+    ssh = get_ssh_config(config)
+    if ssh is None:
+        return []
+    if network_name == "host":
+        fail("config-invalid", "'ssh' cannot be combined with host networking")
+    return ["-p", f"127.0.0.1:{ssh['port'] or ''}:22"]
+
+
+def ensure_ssh_keys():
+    """Create vibecon's SSH client key and container host key on first use"""
+    SSH_DIR.mkdir(parents=True, exist_ok=True)
+    SSH_DIR.chmod(0o700)
+    for key in ("id_ed25519", "host_ed25519_key"):
+        if (SSH_DIR / key).exists():
+            continue
+        if not shutil.which("ssh-keygen"):
+            fail(None, "'ssh-keygen' not found on PATH", "Install OpenSSH to use 'ssh'")
+        result = run_command(["ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", f"vibecon-{key}", "-f", str(SSH_DIR / key)])
+        if result.returncode != 0:
+            fail(None, f"Failed to create {SSH_DIR / key}")
+    host_public_key = (SSH_DIR / "host_ed25519_key.pub").read_text().split()
+    (SSH_DIR / "known_hosts").write_text(f"{SSH_HOST_KEY_ALIAS} {host_public_key[0]} {host_public_key[1]}\n")
+
+
+def start_ssh_server(container_name, config):
+    """Install the keys and start sshd in the container if 'ssh' is on"""
+    if get_ssh_config(config) is None:
+        return
+    ensure_ssh_keys()
+    key_input = (SSH_DIR / "id_ed25519.pub").read_text().strip() + "\n" + (SSH_DIR / "host_ed25519_key").read_text()
+    result = run_command(
+        ["docker", "exec", "-i", "-u", "root", container_name, "sh", "-c", SSH_SERVER_SCRIPT],
+        input=key_input,
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.PIPE,
+        text=True
+    )
+    if result.returncode != 0:
+        fail(None, f"Failed to start the SSH server: {result.stderr.strip()}",
+             "If the image predates 'ssh' support, rebuild it with 'vibecon -B' and recreate the container with 'vibecon -K'")
+
+
+def ssh_host_port(container_name):
+    """Host port the container's SSH server is published on, or None"""
+    result = run_command(["docker", "port", container_name, "22/tcp"], stdout=subprocess.PIPE, stderr=subprocess.DEVNULL, text=True)
+    lines = result.stdout.split()
+    if result.returncode != 0 or not lines:
+        return None
+    return int(lines[0].rsplit(":", 1)[1])
+
+
+def write_ssh_config_entry(container_name, port):
+    """Add or update the container's Host entry in vibecon's ssh config.
+
+    Offers to include that config from ~/.ssh/config, backing the file up
+    first. Returns True if ~/.ssh/config includes it.
+    """
+    config_path = SSH_DIR / "config"
+    entry = (
+        f"Host {container_name}\n"
+        f"  HostName 127.0.0.1\n"
+        f"  Port {port}\n"
+        f"  User node\n"
+        f"  IdentityFile {SSH_DIR / 'id_ed25519'}\n"
+        f"  IdentitiesOnly yes\n"
+        f"  HostKeyAlias {SSH_HOST_KEY_ALIAS}\n"
+        f"  UserKnownHostsFile {SSH_DIR / 'known_hosts'}\n"
+        f"  StrictHostKeyChecking yes"
+    )
+    existing = config_path.read_text() if config_path.exists() else ""
+    entries = [block.strip() for block in re.split(r"\n(?=Host )", existing) if block.strip()]
+    entries = [block for block in entries if block.splitlines()[0] != f"Host {container_name}"]
+    config_path.write_text("\n\n".join(entries + [entry]) + "\n")
+
+    user_config = Path.home() / ".ssh" / "config"
+    include = f"Include {config_path}"
+    current = user_config.read_text() if user_config.exists() else ""
+    if include in current.splitlines():
+        return True
+    declined = SSH_DIR / ".include-declined"
+    if declined.exists():
+        return False
+    # Include must come before the first Host block to apply to all hosts
+    print(f"For 'ssh {container_name}' to work anywhere, {user_config} needs this line at the top:")
+    print(f"  {include}")
+    if not sys.stdin.isatty():
+        return False
+    if not ask_yes(f"Add it to {user_config}?"):
+        declined.touch()
+        return False
+    user_config.parent.mkdir(mode=0o700, exist_ok=True)
+    if user_config.exists():
+        backup = user_config.with_name("config.vibecon-backup")
+        shutil.copy2(user_config, backup)
+        print(f"Saved a backup to {backup}")
+    user_config.write_text(f"{include}\n\n{current}")
+    user_config.chmod(0o600)
+    print(f"Added '{include}' to {user_config}")
+    return True
+
+
+def ssh_command(argv):
+    """vibecon ssh - connect to the container's SSH server"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon ssh",
+        description="Start the container with its SSH server ('ssh' in the config), set up keys and an ssh config entry "
+                    "named after the container, and connect. The entry also works for JetBrains Gateway, VS Code Remote-SSH and plain ssh."
+    )
+    parser.add_argument("command", nargs=argparse.REMAINDER, help="command to run instead of a shell in the current directory")
+    parser.add_argument("--setup", action="store_true", help="only set up the server and the ssh config entry, and print the host name")
+    parser.add_argument("-p", "--profile", action="append", default=[], metavar="NAME", help="apply a named profile")
+    args = parser.parse_args(argv)
+
+    project_root, root_config, container_mount_root = find_project_root()
+    profile_names = args.profile or env_profiles()
+    container_name = generate_container_name(project_root, profile_names)
+    config = layer_config(apply_profiles(get_merged_config(root_config, container_mount_root), profile_names), env_overrides())
+    if get_ssh_config(config) is None:
+        fail("config-invalid", "The SSH server is off", 'Set "ssh": true in .vibecon.json and recreate the container with \'vibecon -K\'')
+    vibecon_root = find_vibecon_root()
+    if not vibecon_root:
+        fail(None, "Could not find Dockerfile in vibecon.py directory")
+
+    set_docker_retry(config)
+    wait_for_docker()
+    ensure_container_running(project_root, vibecon_root, container_name, config.get("image", IMAGE_NAME), container_mount_root, config)
+    start_ssh_server(container_name, config)
+    port = ssh_host_port(container_name)
+    if port is None:
+        fail("config-invalid", f"Container '{container_name}' has no published SSH port",
+             "It was created before 'ssh' was enabled; recreate it with 'vibecon -K'")
+    # Without the Include, point ssh at vibecon's config directly
+    ssh = ["ssh"] if write_ssh_config_entry(container_name, port) else ["ssh", "-F", str(SSH_DIR / "config")]
+
+    if args.setup:
+        print(f"{shlex.join(ssh)} {container_name}   # 127.0.0.1:{port}, user node")
+        return 0
+    command = args.command[1:] if args.command[:1] == ["--"] else args.command
+    if not command:
+        container_workdir = get_container_workdir(os.getcwd(), project_root, container_mount_root)
+        return run_command(ssh + ["-t", container_name, f"cd {shlex.quote(container_workdir)} && exec $SHELL -l"]).returncode
+    return run_command(ssh + [container_name] + command).returncode
+
+
+def format_age(timestamp):
+    """Format a timestamp as a short relative age, like 5m ago"""
+    if timestamp is None:
+        return "-"
+    seconds = max(0, int(time.time() - timestamp))
+    for unit, size in (("d", 86400), ("h", 3600), ("m", 60)):
+        if seconds >= size:
+            return f"{seconds // size}{unit} ago"
+    return "just now"
+
+
+def confirm(question):
+    """Ask a yes/no question, defaulting to no (also without a terminal)"""
+    try:
+        return input(f"{question} [y/N] ").strip().lower() in ("y", "yes")
+    except EOFError:
+        print()
+        return False
+
+
+def ask_yes(question):
+    """Ask a yes/no question, defaulting to yes"""
+    try:
+        return input(f"{question} [Y/n] ").strip().lower() in ("", "y", "yes")
+    except EOFError:
+        print()
+        return False
+
+
+def parse_docker_time(text):
+    """Parse a timestamp from docker inspect (RFC 3339 with nanoseconds) into epoch seconds, or None"""
+    match = re.match(r"(\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d)(?:\.\d+)?(Z|[+-]\d\d:\d\d)$", text or "")
+    if not match:
+        return None
+    offset = "+00:00" if match.group(2) == "Z" else match.group(2)
+    return datetime.datetime.fromisoformat(match.group(1) + offset).timestamp()
+
+
+def parse_duration(text):
+    """Parse a duration like 90s, 30m, 12h, 30d or 2w into seconds"""
+    match = re.fullmatch(r"(\d+)([smhdw])", text.strip())
+    if not match:
+        raise ValueError(f"invalid duration '{text}' (use e.g. 12h, 30d or 2w)")
+    return int(match.group(1)) * {"s": 1, "m": 60, "h": 3600, "d": 86400, "w": 604800}[match.group(2)]
+
+
+def list_vibecon_containers():
+    """Inspect all vibecon workspace containers, leaving out docker-in-docker sidecars"""
+    result = run_command(
+        ["docker", "ps", "-a", "--filter", "name=^vibecon-", "--format", "{{.Names}}"],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    names = set(result.stdout.split())
+    names = sorted(name for name in names if not (name.endswith("-dind") and name[:-len("-dind")] in names))
+    if not names:
+        return []
+    result = run_command(["docker", "inspect"] + names, stdout=subprocess.PIPE, stderr=subprocess.DEVNULL, text=True)
+    try:
+        inspected = json.loads(result.stdout or "[]")
+    except json.JSONDecodeError:
+        return []
+
+    # A container is outdated when its image tag now points at a newer build
+    image_ids = {}
+    for image in {info["Config"]["Image"] for info in inspected}:
+        image_result = run_command(
+            ["docker", "image", "inspect", "-f", "{{.Id}}", image],
+            stdout=subprocess.PIPE,
+            stderr=subprocess.DEVNULL,
+            text=True
+        )
+        image_ids[image] = image_result.stdout.strip() or None
+
+    containers = []
+    for info in inspected:
+        name = info["Name"].lstrip("/")
+        image = info["Config"]["Image"]
+        project = (info["Config"].get("Labels") or {}).get("vibecon.project")
+        # Where the project is mounted, for running commands in it
+        workdir = next((mount["Destination"] for mount in info.get("Mounts") or []
+                        if mount.get("Type") == "bind" and mount.get("Source") == project),
+                       info["Config"].get("WorkingDir") or None)
+        containers.append({
+            "name": name,
+            "project": project,
+            "workdir": workdir,
+            "status": info["State"]["Status"],
+            "image": image,
+            "outdated": bool(image_ids.get(image)) and image_ids[image] != info["Image"],
+            "last_activity": last_activity(name),
+            "created": parse_docker_time(info.get("Created")),
+        })
+    return containers
+
+
+def container_image_info(container_name):
+    """The image reference, ID and tags of a container's image, for reports"""
+    result = run_command(
+        ["docker", "inspect", "-f", "{{.Config.Image}} {{.Image}}", container_name],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    if result.returncode != 0 or len(result.stdout.split()) != 2:
+        return {"image": None, "image_id": None, "image_tags": []}
+    image, image_id = result.stdout.split()
+    result = run_command(
+        ["docker", "image", "inspect", "-f", "{{json .RepoTags}}", image_id],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    try:
+        tags = json.loads(result.stdout) or []
+    except json.JSONDecodeError:
+        tags = []
+    return {"image": image, "image_id": image_id, "image_tags": tags}
+
+
+def container_stats(names):
+    """CPU and memory usage of running containers, keyed by name, from 'docker stats'"""
+    if not names:
+        return {}
+    result = run_command(
+        ["docker", "stats", "--no-stream", "--format", "{{json .}}"] + list(names),
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    stats = {}
+    for line in result.stdout.splitlines():
+        try:
+            entry = json.loads(line)
+        except json.JSONDecodeError:
+            continue
+        stats[entry["Name"]] = entry
+    return stats
+
+
+SIZE_UNITS = {"b": 1, "kb": 1000, "kib": 1024, "mb": 1000 ** 2, "mib": 1024 ** 2,
+              "gb": 1000 ** 3, "gib": 1024 ** 3, "tb": 1000 ** 4, "tib": 1024 ** 4}
+
+
+def parse_size(text):
+    """Parse a docker size string ("1.2GB", "100MiB") to bytes; 0 if unparseable"""
+    match = re.match(r"^\s*([\d.]+)\s*([a-zA-Z]*)", text or "")
+    if not match:
+        return 0
+    return int(float(match.group(1)) * SIZE_UNITS.get(match.group(2).lower() or "b", 1))
+
+
+def format_size(size):
+    """Format a byte count like docker does ("1.2GB")"""
+    for unit in ("TB", "GB", "MB", "kB"):
+        scale = SIZE_UNITS[unit.lower()]
+        if size >= scale:
+            return f"{size / scale:.1f}{unit}"
+    return f"{size}B"
+
+
+def docker_system_df():
+    """Parsed 'docker system df -v' output ({} if docker isn't available)"""
+    result = run_command(
+        ["docker", "system", "df", "-v", "--format", "{{json .}}"],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    try:
+        return json.loads(result.stdout or "{}")
+    except json.JSONDecodeError:
+        return {}
+
+
+def vibecon_disk_usage():
+    """Disk used by vibecon images, volumes and container layers, from 'docker system df'"""
+    df = docker_system_df()
+    # Versioned tags point at the same image as vibecon:latest; count each image once
+    images = {}
+    for image in df.get("Images") or []:
+        if image.get("Repository") == IMAGE_NAME.split(":")[0]:
+            images.setdefault(image.get("ID"), {
+                "name": f"{image['Repository']}:{image['Tag']}",
+                "size": parse_size(image.get("UniqueSize") or image.get("Size")),
+            })
+    volumes = [
+        {"name": volume["Name"], "size": parse_size(volume.get("Size"))}
+        for volume in df.get("Volumes") or []
+        if volume["Name"].startswith("vibecon-")
+    ]
+    containers = {
+        container["Names"]: parse_size(container.get("Size"))
+        for container in df.get("Containers") or []
+        if container["Names"].startswith("vibecon-")
+    }
+    return {"images": list(images.values()), "volumes": volumes, "containers": containers}
+
+
+def stats_command(argv):
+    """vibecon stats - resource and disk usage of all vibecon containers"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon stats",
+        description="Show CPU, memory and disk usage of all vibecon containers, images and volumes"
+    )
+    parser.add_argument("--json", action="store_true", help="print JSON instead of a table")
+    args = parser.parse_args(argv)
+
+    containers = list_vibecon_containers()
+    stats = container_stats([container["name"] for container in containers if container["status"] == "running"])
+    disk = vibecon_disk_usage()
+
+    rows = []
+    for container in containers:
+        usage = stats.get(container["name"], {})
+        rows.append({
+            "name": container["name"],
+            "project": container["project"],
+            "status": container["status"],
+            "cpu_percent": float(usage["CPUPerc"].rstrip("%")) if usage.get("CPUPerc") else None,
+            "memory_bytes": parse_size(usage["MemUsage"].split("/")[0]) if usage.get("MemUsage") else None,
+            "disk_bytes": disk["containers"].get(container["name"], 0),
+        })
+    totals = {
+        "cpu_percent": round(sum(row["cpu_percent"] or 0 for row in rows), 2),
+        "memory_bytes": sum(row["memory_bytes"] or 0 for row in rows),
+        "container_disk_bytes": sum(row["disk_bytes"] for row in rows),
+        "image_disk_bytes": sum(image["size"] for image in disk["images"]),
+        "volume_disk_bytes": sum(volume["size"] for volume in disk["volumes"]),
+    }
+    totals["disk_bytes"] = totals["container_disk_bytes"] + totals["image_disk_bytes"] + totals["volume_disk_bytes"]
+
+    if args.json:
+        print(json.dumps({"containers": rows, "images": disk["images"], "volumes": disk["volumes"], "totals": totals}, indent=2))
+        return 0
+
+    print(f"{'STATUS':<10}{'CPU':>8}{'MEMORY':>10}{'DISK':>10}  PROJECT")
+    for row in rows:
+        cpu = f"{row['cpu_percent']:.1f}%" if row["cpu_percent"] is not None else "-"
+        memory = format_size(row["memory_bytes"]) if row["memory_bytes"] is not None else "-"
+        label = row["project"] or row["name"]
+        print(f"{row['status']:<10}{cpu:>8}{memory:>10}{format_size(row['disk_bytes']):>10}  {label}")
+    print()
+    print(f"Containers: {len(rows)}, {totals['cpu_percent']:.1f}% CPU, {format_size(totals['memory_bytes'])} memory, "
+          f"{format_size(totals['container_disk_bytes'])} disk")
+    print(f"Images:     {len(disk['images'])}, {format_size(totals['image_disk_bytes'])}")
+    print(f"Volumes:    {len(disk['volumes'])}, {format_size(totals['volume_disk_bytes'])}")
+    print(f"Total disk: {format_size(totals['disk_bytes'])}")
+    return 0
+
+
+# Volumes shared by all containers, named vibecon-<kind>-<name>
+SHARED_VOLUME_PREFIXES = ("vibecon-cache-", "vibecon-auth-")
+
+
+def volume_owner(volume_name, container_names):
+    """Container a vibecon volume belongs to, "shared", or None if it is orphaned.
+
+    Per-container volumes are named {container}_{name} (or exactly like a
+    sandbox container), per-workspace ones {container}-{name}, where the
+    workspace's base container may carry a --profile or --sandbox suffix.
+    """
+    if volume_name.startswith(SHARED_VOLUME_PREFIXES):
+        return "shared"
+    for container_name in sorted(container_names, key=len, reverse=True):
+        base_name = container_name.split("--", 1)[0]
+        if volume_name == container_name or volume_name.startswith(container_name + "_"):
+            return container_name
+        if volume_name.startswith(base_name + "-"):
+            return container_name
+    return None
+
+
+def du_command(argv):
+    """vibecon du - disk usage breakdown with cleanup suggestions"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon du",
+        description="Break down disk used by vibecon image tags, container layers and volumes, and suggest cleanups"
+    )
+    parser.parse_args(argv)
+
+    df = docker_system_df()
+    if not df:
+        fail(None, "Could not get disk usage from 'docker system df'")
+    containers = {container["name"]: container for container in list_vibecon_containers()}
+    repository = IMAGE_NAME.split(":")[0]
+
+    # Tags of the same image share its layers, so group them by image ID
+    images = {}
+    for image in df.get("Images") or []:
+        if image.get("Repository") != repository:
+            continue
+        entry = images.setdefault(image["ID"], {
+            "tags": [],
+            "size": parse_size(image.get("UniqueSize") or image.get("Size")),
+            "containers": int(image.get("Containers") or 0),
+        })
+        entry["tags"].append(f"{repository}:{image['Tag']}")
+    print("Images (unique size):")
+    unused_tags = []
+    for image in sorted(images.values(), key=lambda image: IMAGE_NAME not in image["tags"]):
+        current = IMAGE_NAME in image["tags"]
+        usage = f"used by {image['containers']}" if image["containers"] else "unused"
+        print(f"  {format_size(image['size']):>9}  {usage:<14}{', '.join(image['tags'])}")
+        if not current and not image["containers"]:
+            unused_tags.extend(image["tags"])
+
+    print("\nContainers (writable layer):")
+    for container in df.get("Containers") or []:
+        name = container["Names"]
+        if name in containers:
+            label = containers[name]["project"] or name
+            print(f"  {format_size(parse_size(container.get('Size'))):>9}  {containers[name]['status']:<14}{label}")
+
+    print("\nVolumes:")
+    orphaned = []
+    for volume in sorted(df.get("Volumes") or [], key=lambda volume: volume["Name"]):
+        name = volume["Name"]
+        if not name.startswith("vibecon-"):
+            continue
+        owner = volume_owner(name, containers)
+        if owner is None:
+            orphaned.append(name)
+            owner_label = "orphaned"
+        elif owner == "shared":
+            owner_label = "shared"
+        else:
+            owner_label = containers[owner]["project"] or owner
+        print(f"  {format_size(parse_size(volume.get('Size'))):>9}  {owner_label:<14}{name}")
+
+    build_cache = sum(parse_size(entry.get("Size")) for entry in df.get("BuildCache") or [])
+    print(f"\nBuild cache: {format_size(build_cache)}")
+
+    suggestions = []
+    if unused_tags:
+        suggestions.append(("Remove image tags no container uses", ["docker", "rmi"] + unused_tags))
+    if orphaned:
+        suggestions.append(("Remove volumes whose workspace container is gone", ["docker", "volume", "rm"] + orphaned))
+    stopped = [name for name, container in containers.items() if container["status"] != "running"]
+    if stopped:
+        suggestions.append(("Remove stopped containers (run 'vibecon -K' in a project for a clean destroy)", ["docker", "rm", "-v"] + stopped))
+    if build_cache:
+        suggestions.append(("Clear the image build cache", ["docker", "builder", "prune"]))
+    if suggestions:
+        print("\nSuggestions:")
+        for description, command in suggestions:
+            print(f"  {description}:")
+            print(f"    {shlex.join(command)}")
+    return 0
+
+
+def bulk_container_command(argv, action):
+    """vibecon stop|destroy - stop or destroy this workspace's container, or many with --all"""
+    verb = "Stop" if action == "stop" else "Destroy"
+    parser = argparse.ArgumentParser(
+        prog=f"vibecon {action}",
+        description=f"{verb} the container of this workspace (outside of one, pick a container), "
+                    "or with --all every vibecon container"
+    )
+    parser.add_argument("--all", action="store_true", help=f"{action} all vibecon containers (after confirmation)")
+    parser.add_argument("--older-than", metavar="AGE", help="with --all, only containers idle for longer than AGE, e.g. 30d or 12h")
+    parser.add_argument("-f", "--filter", action="append", metavar="GLOB",
+                        help="with --all, only workspaces whose path or container name matches (repeatable)")
+    parser.add_argument("-y", "--yes", action="store_true", help="don't ask for confirmation")
+    if action == "destroy":
+        parser.add_argument("--keep-volumes", action="store_true", help="keep the containers' named volumes")
+    parser.add_argument("-p", "--profile", action="append", help="profile of the container (without --all)")
+    args = parser.parse_args(argv)
+    keep_volumes = getattr(args, "keep_volumes", False)
+
+    if not args.all:
+        if args.older_than or args.filter:
+            parser.error("--older-than and --filter need --all")
+        container_name = workspace_or_picked_container(args.profile, verb, running_only=action == "stop")
+        if container_name is None:
+            return 1
+        if action == "stop":
+            stop_container(container_name)
+            return 0
+        # The workspace's --sandbox container goes with it, as it does with --all
+        names = [container_name]
+        if container_exists(sandbox_container_name(container_name)):
+            names.append(sandbox_container_name(container_name))
+        if not keep_volumes:
+            print("Named volumes are removed too (keep them with --keep-volumes).")
+        if not args.yes and not confirm(f"Destroy {' and '.join(names)}?"):
+            return 1
+        for name in names:
+            destroy_container(name, keep_volumes)
+        return 0
+
+    try:
+        min_idle = parse_duration(args.older_than) if args.older_than else None
+    except ValueError as e:
+        parser.error(str(e))
+
+    containers = list_vibecon_containers()
+    if action == "stop":
+        containers = [container for container in containers if container["status"] == "running"]
+    if args.filter:
+        patterns = [os.path.expanduser(pattern) for pattern in args.filter]
+        containers = [
+            container for container in containers
+            if any(fnmatch.fnmatch(container["project"] or "", pattern) or fnmatch.fnmatch(container["name"], pattern)
+                   for pattern in patterns)
+        ]
+    if min_idle is not None:
+        # Idle since the last vibecon command, or since creation if none ran
+        now = time.time()
+        containers = [
+            container for container in containers
+            if now - (container["last_activity"] or container["created"] or now) > min_idle
+        ]
+    if not containers:
+        print(f"No containers to {action}.")
+        return 0
+
+    print(f"Containers to {action}:")
+    for container in containers:
+        idle = format_age(container["last_activity"] or container["created"])
+        print(f"  {container['name']:<40} {container['status']:<10} {idle:<10} {container['project'] or '-'}")
+    if action == "destroy" and not keep_volumes:
+        print("Their named volumes are removed too (keep them with --keep-volumes).")
+    if not args.yes and not confirm(f"{verb} {len(containers)} container(s)?"):
+        return 1
+
+    for container in containers:
+        if action == "stop":
+            stop_container(container["name"])
+        else:
+            destroy_container(container["name"], keep_volumes)
+    return 0
+
+
+def stop_command(argv):
+    return bulk_container_command(argv, "stop")
+
+
+def destroy_command(argv):
+    return bulk_container_command(argv, "destroy")
+
+
+def volumes_command(argv):
+    """vibecon volumes <action> - list and remove volumes created by vibecon"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon volumes",
+        description="List and remove the named volumes vibecon created for containers and workspaces"
+    )
+    actions = parser.add_subparsers(dest="action")
+    list_parser = actions.add_parser("list", help="list volumes of this workspace (default)")
+    list_parser.add_argument("-a", "--all", action="store_true", help="list the volumes of all workspaces")
+    rm_parser = actions.add_parser("rm", help="remove volumes")
+    rm_parser.add_argument("names", nargs="*", metavar="NAME", help="volumes to remove")
+    rm_parser.add_argument("--orphaned", action="store_true", help="remove all volumes whose workspace container is gone")
+    rm_parser.add_argument("-y", "--yes", action="store_true", help="don't ask for confirmation")
+    args = parser.parse_args(argv)
+
+    containers = [container["name"] for container in list_vibecon_containers()]
+    volumes = [
+        {"name": volume["Name"], "size": parse_size(volume.get("Size")), "owner": volume_owner(volume["Name"], containers)}
+        for volume in docker_system_df().get("Volumes") or []
+        if volume["Name"].startswith("vibecon-")
+    ]
+
+    if args.action == "rm":
+        names = list(args.names)
+        if args.orphaned:
+            names.extend(volume["name"] for volume in volumes if volume["owner"] is None)
+        if not names:
+            fail(None, "Name the volumes to remove, or use --orphaned")
+        print("Volumes to remove:")
+        for name in names:
+            print(f"  {name}")
+        if not args.yes and not confirm("Remove them?"):
+            return 1
+        # Volumes still used by a container are refused by docker and reported
+        return run_command(["docker", "volume", "rm"] + names, stdout=subprocess.DEVNULL).returncode
+
+    if not getattr(args, "all", False):
+        project_root, _, _ = find_project_root()
+        base_name = generate_container_name(project_root)
+        volumes = [volume for volume in volumes if volume["name"].startswith(base_name)]
+    if not volumes:
+        print("No vibecon volumes.")
+        return 0
+    for volume in sorted(volumes, key=lambda volume: volume["name"]):
+        status = volume["owner"] if volume["owner"] in (None, "shared") else "in use"
+        print(f"{format_size(volume['size']):>9}  {status or 'orphaned':<10}{volume['name']}")
+    return 0
+
+
+# Bumped when the layout of 'vibecon export' archives changes
+EXPORT_FORMAT = 1
+
+
+def volume_tar_command(volume_name, image_name, extract=False):
+    """docker run command that streams a volume's contents as tar, or extracts a tar stream into it"""
+    if extract:
+        mount, tar_args = f"{volume_name}:/volume", ["-xpf", "-"]
+    else:
+        mount, tar_args = f"{volume_name}:/volume:ro", ["-cf", "-", "."]
+    return [
+        "docker", "run", "--rm", "-i", "-u", "root", "-v", mount, "--entrypoint", "tar", image_name,
+        "-C", "/volume", "--numeric-owner",
+    ] + tar_args
+
+
+def export_command(argv):
+    """vibecon export - archive the container's home directory and volumes"""
+    if argv[:1] == ["devcontainer"]:
+        return export_devcontainer(argv[1:])
+    parser = argparse.ArgumentParser(
+        prog="vibecon export",
+        description="Archive the container's home directory, its vibecon volumes and metadata, to restore elsewhere with 'vibecon import'",
+        epilog="Use 'vibecon export devcontainer' to write a .devcontainer/devcontainer.json instead."
+    )
+    parser.add_argument("-o", "--output", metavar="FILE", help="archive path (default: {container-name}.vibecon.tar.gz)")
+    parser.add_argument("-p", "--profile", action="append", default=[], metavar="NAME", help="apply a named profile")
+    args = parser.parse_args(argv)
+
+    project_root, root_config, container_mount_root = find_project_root()
+    profile_names = args.profile or env_profiles()
+    container_name = generate_container_name(project_root, profile_names)
+    result = run_command(["docker", "inspect", container_name], stdout=subprocess.PIPE, stderr=subprocess.DEVNULL, text=True)
+    if result.returncode != 0:
+        fail(None, f"No container '{container_name}' to export")
+    info = json.loads(result.stdout)[0]
+    image_name = info["Config"]["Image"]
+
+    # Shared caches and logins belong to the machine, not to this environment
+    volumes = [
+        {"name": mount["Name"], "target": mount["Destination"]}
+        for mount in info["Mounts"]
+        if mount["Type"] == "volume" and mount["Name"].startswith("vibecon-")
+        and not mount["Name"].startswith(SHARED_VOLUME_PREFIXES)
+    ]
+    metadata = {
+        "format": EXPORT_FORMAT,
+        "container": container_name,
+        "base_name": generate_container_name(project_root),
+        "project_root": project_root,
+        "profiles": profile_names,
+        "image": image_name,
+        "created": info["Created"],
+        "exported": time.strftime("%Y-%m-%dT%H:%M:%S%z"),
+        "volumes": volumes,
+    }
+    output = Path(args.output or f"{container_name}.vibecon.tar.gz")
+
+    with tempfile.TemporaryDirectory() as staging_dir:
+        staging = Path(staging_dir)
+        print(f"Exporting {CONTAINER_HOME}...")
+        with open(staging / "home.tar", "wb") as f:
+            result = run_command(["docker", "cp", f"{container_name}:{CONTAINER_HOME}", "-"], stdout=f)
+        if result.returncode != 0:
+            fail(None, f"Could not copy {CONTAINER_HOME} out of '{container_name}'")
+        (staging / "volumes").mkdir()
+        for volume in volumes:
+            print(f"Exporting volume {volume['name']}...")
+            with open(staging / "volumes" / f"{volume['name']}.tar", "wb") as f:
+                result = run_command(volume_tar_command(volume["name"], image_name), stdin=subprocess.DEVNULL, stdout=f)
+            if result.returncode != 0:
+                fail(None, f"Could not export volume '{volume['name']}'")
+        (staging / "metadata.json").write_text(json.dumps(metadata, indent=2) + "\n")
+
+        with tarfile.open(output, "w:gz") as archive:
+            for name in ("metadata.json", "home.tar", "volumes"):
+                archive.add(staging / name, arcname=name)
+
+    success(f"Exported '{container_name}' to {output}")
+    print("The workspace itself is not included; move it with git as usual.")
+    return 0
+
+
+# docker run flags that vibecon sets for itself or that map to dedicated devcontainer.json keys
+DEVCONTAINER_SKIPPED_FLAGS = {"--name", "-w", "--label", "-e", "-p"}
+
+
+def devcontainer_path(path, project_root):
+    """Rewrite a host path relative to the workspace or home folder, so it resolves on other machines"""
+    for root, variable in ((project_root, "${localWorkspaceFolder}"), (str(Path.home()), "${localEnv:HOME}")):
+        if path == root or path.startswith(root + "/"):
+            return variable + path[len(root):]
+    return path
+
+
+def devcontainer_mount(flag, value, project_root):
+    """Convert a -v or --mount argument into a devcontainer.json mount string"""
+    if flag == "--mount":
+        fields = []
+        for field in value.split(","):
+            key, sep, field_value = field.partition("=")
+            if key in ("source", "src") and sep:
+                field = f"{key}={devcontainer_path(field_value, project_root)}"
+            fields.append(field)
+        return ",".join(fields)
+    parts = value.split(":")
+    if len(parts) == 1:
+        return f"type=volume,target={parts[0]}"
+    source, target = parts[0], parts[1]
+    mount_type = "bind" if source.startswith("/") else "volume"
+    mount = f"source={devcontainer_path(source, project_root)},target={target},type={mount_type}"
+    if len(parts) > 2 and "ro" in parts[2].split(","):
+        mount += ",readonly"
+    return mount
+
+
+def devcontainer_config(project_root, container_name, image_name, container_mount_root, config):
+    """Translate the docker run command for a config into an equivalent devcontainer.json"""
+    docker_cmd = build_run_command(project_root, container_name, image_name, container_mount_root, config)
+    devcontainer = {
+        "name": os.path.basename(project_root),
+        "image": image_name,
+        "workspaceFolder": container_mount_root,
+        "workspaceMount": f"source=${{localWorkspaceFolder}},target={container_mount_root},type=bind",
+        "remoteUser": "node",
+        # Keep the image's entrypoint, which configures git from GIT_USER_*
+        "overrideCommand": False,
+    }
+    # Configured timezone and locale are project settings; the host's are not
+    container_env = {}
+    if config.get("timezone"):
+        container_env["TZ"] = config["timezone"]
+    if config.get("locale"):
+        container_env.update(locale_env(config))
+    container_env.update({key: str(value) for key, value in config.get("env", {}).items()})
+    if container_env:
+        devcontainer["containerEnv"] = container_env
+    ports = [int(str(port).split(":")[-1].split("/")[0].split("-")[0]) for port in config.get("ports", [])]
+    if ports:
+        devcontainer["forwardPorts"] = ports
+
+    mounts = []
+    run_args = []
+    for arg, value in split_run_args(docker_cmd[2:-1]):
+        if value is None:
+            if arg != "-d":
+                run_args.append(arg)
+            continue
+        if arg in DEVCONTAINER_SKIPPED_FLAGS:
+            continue
+        if arg in ("-v", "--mount"):
+            mount = devcontainer_mount(arg, value, project_root)
+            if f"target={container_mount_root}," in mount + ",":
+                # The workspace itself, a volume in sync or sandbox mode
+                devcontainer["workspaceMount"] = mount
+            else:
+                mounts.append(mount)
+            continue
+        run_args.extend([arg, value])
+    if mounts:
+        devcontainer["mounts"] = mounts
+    if run_args:
+        devcontainer["runArgs"] = run_args
+    if config.get("on_create"):
+        devcontainer["postCreateCommand"] = " && ".join(format_on_create(spec) for spec in config["on_create"])
+    return devcontainer
+
+
+def export_devcontainer(argv):
+    """vibecon export devcontainer - write a devcontainer.json equivalent to the merged config"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon export devcontainer",
+        description="Write a devcontainer.json with the image, mounts, env, ports and docker arguments of the merged config, for VS Code Dev Containers and Codespaces"
+    )
+    parser.add_argument("-o", "--output", metavar="FILE", help="file to write, - for stdout (default: .devcontainer/devcontainer.json in the project)")
+    parser.add_argument("-f", "--force", action="store_true", help="overwrite an existing file")
+    parser.add_argument("-p", "--profile", action="append", default=[], metavar="NAME", help="apply a named profile")
+    args = parser.parse_args(argv)
+
+    project_root, root_config, container_mount_root = find_project_root()
+    profile_names = args.profile or env_profiles()
+    container_name = generate_container_name(project_root, profile_names)
+    config = layer_config(apply_profiles(get_merged_config(root_config, container_mount_root), profile_names), env_overrides())
+    image_name = config.get("image", IMAGE_NAME)
+    # Keep warnings out of the JSON when writing to stdout
+    with contextlib.redirect_stdout(sys.stderr):
+        devcontainer = devcontainer_config(project_root, container_name, image_name, container_mount_root, config)
+        if image_name == IMAGE_NAME:
+            warn(f"'{IMAGE_NAME}' is built locally by vibecon; push it to a registry and set 'image' so teammates can pull it")
+    content = json.dumps(devcontainer, indent=2) + "\n"
+
+    if args.output == "-":
+        sys.stdout.write(content)
+        return 0
+    output = Path(args.output or os.path.join(project_root, ".devcontainer", "devcontainer.json"))
+    if output.exists() and not args.force:
+        fail(None, f"{output} already exists", "pass --force to overwrite it")
+    output.parent.mkdir(parents=True, exist_ok=True)
+    output.write_text(content)
+    success(f"Wrote {output}")
+    return 0
+
+
+def import_command(argv):
+    """vibecon import - restore an environment archived with 'vibecon export'"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon import",
+        description="Recreate this workspace's container from a 'vibecon export' archive"
+    )
+    parser.add_argument("archive", metavar="FILE", help="archive created by 'vibecon export'")
+    parser.add_argument("-f", "--force", action="store_true", help="replace an existing container and its volumes")
+    parser.add_argument("-p", "--profile", action="append", default=[], metavar="NAME", help="apply a named profile")
+    args = parser.parse_args(argv)
+
+    project_root, root_config, container_mount_root = find_project_root()
+    profile_names = args.profile or env_profiles()
+    container_name = generate_container_name(project_root, profile_names)
+    config = layer_config(apply_profiles(get_merged_config(root_config, container_mount_root), profile_names), env_overrides())
+    image_name = config.get("image", IMAGE_NAME)
+    vibecon_root = find_vibecon_root()
+
+    replace = container_exists(container_name)
+    if replace and not args.force:
+        fail(None, f"Container '{container_name}' already exists; use --force to replace it")
+
+    with tempfile.TemporaryDirectory() as staging_dir:
+        staging = Path(staging_dir)
+        # Unpack and check everything before the existing container is touched
+        try:
+            with tarfile.open(args.archive, "r:gz") as archive:
+                archive.extractall(staging, filter="data")
+            metadata = json.loads((staging / "metadata.json").read_text())
+        except (tarfile.TarError, OSError, json.JSONDecodeError) as e:
+            fail(None, f"Could not read {args.archive}: {e}")
+        if metadata.get("format") != EXPORT_FORMAT:
+            fail(None, f"Unsupported export format {metadata.get('format')} (expected {EXPORT_FORMAT})")
+        missing = [name for name in ["home.tar"] + [f"volumes/{volume['name']}.tar" for volume in metadata.get("volumes", [])]
+                   if not (staging / name).is_file()]
+        if missing or not all(key in metadata for key in ("base_name", "volumes", "project_root", "container")):
+            fail(None, f"{args.archive} is incomplete" + (f", missing {', '.join(missing)}" if missing else ""))
+
+        # Volumes are named after the workspace path, which may differ on this machine
+        old_base, new_base = metadata["base_name"], generate_container_name(project_root)
+        if not image_exists(image_name):
+            if image_name == IMAGE_NAME:
+                build_image(vibecon_root, image_name, config=config)
+            else:
+                pull_image(image_name)
+        if replace:
+            destroy_container(container_name)
+        for volume in metadata["volumes"]:
+            old_name = volume["name"]
+            new_name = new_base + old_name[len(old_base):] if old_name.startswith(old_base) else old_name
+            print(f"Restoring volume {new_name}...")
+            run_command(["docker", "volume", "rm", "-f", new_name], stdout=subprocess.DEVNULL, stderr=subprocess.DEVNULL)
+            with open(staging / "volumes" / f"{old_name}.tar", "rb") as f:
+                result = run_command(volume_tar_command(new_name, image_name, extract=True), stdin=f)
+            if result.returncode != 0:
+                fail(None, f"Could not restore volume '{new_name}'")
+
+        # The container is created with the restored volumes, then gets the home directory
+        ensure_container_running(project_root, vibecon_root, container_name, image_name, container_mount_root, config)
+        print(f"Restoring {CONTAINER_HOME}...")
+        with open(staging / "home.tar", "rb") as f:
+            result = run_command(["docker", "cp", "-a", "-", f"{container_name}:{posixpath.dirname(CONTAINER_HOME)}"], stdin=f)
+        if result.returncode != 0:
+            fail(None, f"Could not restore {CONTAINER_HOME} in '{container_name}'")
+
+    if metadata["project_root"] != project_root:
+        print(f"Note: exported from {metadata['project_root']}")
+    success(f"Imported '{metadata['container']}' as '{container_name}'")
+    return 0
+
+
+# Snapshots are images named vibecon-snapshot:{container hash}-{name}, labeled
+# with the container they were taken from
+SNAPSHOT_REPOSITORY = "vibecon-snapshot"
+
+
+def snapshot_image_name(container_name, snapshot_name):
+    """Image name of a container snapshot; container names are too long for tags"""
+    return f"{SNAPSHOT_REPOSITORY}:{hashlib.md5(container_name.encode()).hexdigest()[:8]}-{snapshot_name}"
+
+
+def list_snapshots(container_name):
+    """Snapshots of a container as (name, created, size), oldest first"""
+    result = run_command(
+        [
+            "docker", "images", "--filter", f"label=vibecon.container={container_name}",
+            "--format", '{{.Tag}}\t{{.CreatedAt}}\t{{.Size}}',
+        ],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True
+    )
+    prefix = snapshot_image_name(container_name, "").split(":", 1)[1]
+    snapshots = []
+    for line in result.stdout.splitlines():
+        tag, created, size = line.split("\t")
+        if tag.startswith(prefix):
+            snapshots.append((tag[len(prefix):], created, size))
+    return sorted(snapshots, key=lambda snapshot: snapshot[1])
+
+
+def snapshot_command(argv):
+    """vibecon snapshot <action> - save and restore container snapshots"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon snapshot",
+        description="Commit the container to an image, and recreate the container from it later"
+    )
+    actions = parser.add_subparsers(dest="action", required=True)
+    save_parser = actions.add_parser("save", help="commit the container as a named snapshot (default action)")
+    save_parser.add_argument("name", help="snapshot name")
+    restore_parser = actions.add_parser("restore", help="recreate the container from a snapshot")
+    restore_parser.add_argument("name", help="snapshot name")
+    actions.add_parser("list", help="list the container's snapshots")
+    rm_parser = actions.add_parser("rm", help="delete a snapshot")
+    rm_parser.add_argument("name", help="snapshot name")
+    for action in actions.choices.values():
+        action.add_argument("-p", "--profile", action="append", default=[], metavar="NAME", help="apply a named profile")
+    # "vibecon snapshot NAME" is short for "vibecon snapshot save NAME"
+    if argv and argv[0] not in actions.choices and not argv[0].startswith("-"):
+        argv = ["save"] + argv
+    args = parser.parse_args(argv)
+
+    project_root, root_config, container_mount_root = find_project_root()
+    profile_names = args.profile or env_profiles()
+    container_name = generate_container_name(project_root, profile_names)
+
+    if args.action == "list":
+        snapshots = list_snapshots(container_name)
+        if not snapshots:
+            print("No snapshots.")
+        for name, created, size in snapshots:
+            print(f"{name:<24}{created:<32}{size}")
+        return 0
+
+    if not re.match(r"^[a-zA-Z0-9][a-zA-Z0-9_.-]*$", args.name):
+        fail(None, f"Invalid snapshot name '{args.name}' (letters, digits, '_', '.', '-')")
+    image_name = snapshot_image_name(container_name, args.name)
+
+    if args.action == "save":
+        if not container_exists(container_name):
+            fail(None, f"No container '{container_name}' to snapshot")
+        print(f"Saving snapshot '{args.name}' of '{container_name}'...")
+        result = run_command(
+            [
+                "docker", "commit",
+                "--change", f"LABEL vibecon.container={container_name}",
+                "--change", f"LABEL vibecon.snapshot={args.name}",
+                container_name, image_name,
+            ],
+            stdout=subprocess.DEVNULL
+        )
+        if result.returncode != 0:
+            return 1
+        print(f"Saved as {image_name}. Volumes and the workspace are not part of the snapshot.")
+        return 0
+
+    if not image_exists(image_name):
+        fail(None, f"No snapshot '{args.name}' (see 'vibecon snapshot list')")
+
+    if args.action == "rm":
+        return run_command(["docker", "rmi", image_name], stdout=subprocess.DEVNULL).returncode
+
+    # restore: volumes keep their current contents
+    config = layer_config(apply_profiles(get_merged_config(root_config, container_mount_root), profile_names), env_overrides())
+    if container_exists(container_name):
+        destroy_container(container_name, keep_volumes=True)
+    ensure_container_running(project_root, find_vibecon_root(), container_name, image_name, container_mount_root, config)
+    success(f"Restored '{container_name}' from snapshot '{args.name}'")
+    return 0
+
+
+def redact_config(value, key=None):
+    """Copy of a config with env values, secret sources and URL credentials
+    hidden, for bug reports"""
+    if key in ("env", "secrets") and isinstance(value, dict):
+        return {name: "***" for name in value}
+    if isinstance(value, dict):
+        return {k: redact_config(v, k) for k, v in value.items()}
+    if isinstance(value, list):
+        return [redact_config(item) for item in value]
+    if isinstance(value, str):
+        return redact_url_credentials(value)
+    return value
+
+
+def debug_bundle_command(argv):
+    """vibecon debug-bundle - collect logs, config and docker info for a bug report"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon debug-bundle",
+        description="Collect vibecon logs, the effective config and docker info into an archive to attach to bug reports"
+    )
+    parser.add_argument("-o", "--output", metavar="FILE", help="archive path (default: vibecon-debug-{time}.tar.gz)")
+    parser.add_argument("-p", "--profile", action="append", default=[], metavar="NAME", help="apply a named profile")
+    args = parser.parse_args(argv)
+    output = Path(args.output or time.strftime("vibecon-debug-%Y%m%d-%H%M%S.tar.gz"))
+
+    def command_output(command):
+        try:
+            result = run_command(command, capture_output=True, text=True)
+        except FileNotFoundError as e:
+            return f"{e}\n"
+        return result.stdout + result.stderr
+
+    files = {}
+    vibecon_root = find_vibecon_root()
+    files["system.txt"] = "".join([
+        f"platform: {sys.platform}\n",
+        f"python: {sys.version.split()[0]}\n",
+        f"vibecon: {Path(__file__).resolve()}\n",
+        command_output(["git", "-C", vibecon_root or ".", "describe", "--always", "--dirty"]) if vibecon_root else "",
+    ])
+    files["docker-version.txt"] = command_output(["docker", "version"])
+    files["docker-info.txt"] = command_output(["docker", "info"])
+
+    # The project is optional, so a bundle can be made when finding it is the problem
+    config_file = find_config_file()
+    if config_file:
+        project_root, root_config, container_mount_root = find_project_root()
+        profile_names = args.profile or env_profiles()
+        container_name = generate_container_name(project_root, profile_names)
+        config = layer_config(apply_profiles(get_merged_config(root_config, container_mount_root), profile_names), env_overrides())
+        files["config.json"] = json.dumps({
+            "project_root": project_root,
+            "config_file": str(config_file),
+            "container": container_name,
+            "config": redact_config(config),
+        }, indent=2) + "\n"
+        result = run_command(["docker", "inspect", container_name], capture_output=True, text=True)
+        if result.returncode == 0:
+            inspected = json.loads(result.stdout)
+            for info in inspected:
+                info["Config"]["Env"] = [entry.split("=", 1)[0] + "=***" for entry in info["Config"].get("Env") or []]
+            files["container.json"] = json.dumps(inspected, indent=2) + "\n"
+            files["container-logs.txt"] = command_output(["docker", "logs", "--tail", "500", container_name])
+
+    # Logs written by older versions may still hold credentials in URLs
+    if LOG_DIR.is_dir():
+        for log_file in sorted(LOG_DIR.iterdir()):
+            files[f"logs/{log_file.name}"] = redact_url_credentials(log_file.read_text(errors="replace"))
+
+    with tarfile.open(output, "w:gz") as archive:
+        for name, content in files.items():
+            data = content.encode()
+            info = tarfile.TarInfo(f"vibecon-debug/{name}")
+            info.size = len(data)
+            info.mtime = int(time.time())
+            archive.addfile(info, io.BytesIO(data))
+
+    print(f"Wrote {output}")
+    if not LOG_DIR.is_dir():
+        print(f"No logs were found; reproduce the problem with 'vibecon --debug ...' to log to {LOG_DIR}")
+    print("Env values and URL credentials are redacted, but check the archive before sharing it.")
+    return 0
+
+
+# Dashboard keys; actions that need the terminal run after leaving curses
+DASHBOARD_HELP = "enter/a attach  s stop  d destroy  b rebuild  l logs  q quit"
+
+
+def poll_dashboard(state, interval, stop_event):
+    """Refresh the dashboard's container list and stats in the background"""
+    while True:
+        containers = list_vibecon_containers()
+        running = [container["name"] for container in containers if container["status"] == "running"]
+        stats = container_stats(running)
+        with state["lock"]:
+            state["containers"] = containers
+            state["stats"] = stats
+            state["loaded"] = True
+        if stop_event.wait(interval):
+            return
+
+
+def draw_dashboard(stdscr, state, message):
+    """Render the container table"""
+    import curses
+
+    stdscr.erase()
+    height, width = stdscr.getmaxyx()
+
+    def line(y, text, attr=0):
+        if 0 <= y < height:
+            stdscr.addnstr(y, 0, text.ljust(width), width - 1, attr)
+
+    with state["lock"]:
+        containers = list(state["containers"])
+        stats = dict(state["stats"])
+        loaded = state["loaded"]
+    line(0, f"vibecon - {len(containers)} containers", curses.A_BOLD)
+    line(1, f"{'STATUS':<10}{'CPU':>8}{'MEMORY':>22}  {'ACTIVE':<10}{'IMAGE':<10}PROJECT", curses.A_UNDERLINE)
+    if not containers:
+        line(3, "  No vibecon containers." if loaded else "  Loading...")
+    state["selected"] = min(state["selected"], max(0, len(containers) - 1))
+    for index, container in enumerate(containers[:max(0, height - 4)]):
+        usage = stats.get(container["name"], {})
+        image = "outdated" if container["outdated"] else "current"
+        label = container["project"] or container["name"]
+        if container["project"] and "--" in container["name"]:
+            label += " (" + container["name"].split("--", 1)[1] + ")"
+        attr = curses.A_REVERSE if index == state["selected"] else 0
+        line(2 + index, f"{container['status']:<10}{usage.get('CPUPerc', '-'):>8}{usage.get('MemUsage', '-'):>22}  "
+                        f"{format_age(container['last_activity']):<10}{image:<10}{label}", attr)
+    line(height - 1, message or DASHBOARD_HELP, curses.A_DIM)
+    stdscr.refresh()
+    return containers
+
+
+def run_dashboard(stdscr, state):
+    """Dashboard event loop; returns (action, container) to run outside curses, or None to quit"""
+    import curses
+
+    curses.curs_set(0)
+    stdscr.timeout(500)
+    message = state.pop("message", None)
+    while True:
+        containers = draw_dashboard(stdscr, state, message)
+        key = stdscr.getch()
+        if key == -1:
+            continue
+        message = None
+        if key in (ord("q"), 27):
+            return None
+        if key in (curses.KEY_DOWN, ord("j")):
+            state["selected"] = min(state["selected"] + 1, max(0, len(containers) - 1))
+        elif key in (curses.KEY_UP, ord("k")):
+            state["selected"] = max(state["selected"] - 1, 0)
+        elif containers and key in (10, 13, curses.KEY_ENTER, ord("a"), ord("s"), ord("d"), ord("b"), ord("l")):
+            container = containers[state["selected"]]
+            action = {ord("s"): "stop", ord("d"): "destroy", ord("b"): "rebuild", ord("l"): "logs"}.get(key, "attach")
+            if action in ("destroy", "rebuild"):
+                # Both remove the container, so ask first
+                draw_dashboard(stdscr, state, f"{action} {container['name']}? [y/N]")
+                stdscr.timeout(-1)
+                confirmed = stdscr.getch() in (ord("y"), ord("Y"))
+                stdscr.timeout(500)
+                if not confirmed:
+                    continue
+            return action, container
+
+
+def dashboard_action(action, container):
+    """Run a dashboard action with the terminal back in normal mode"""
+    name = container["name"]
+    if action == "attach":
+        if container["status"] != "running":
+            run_command(["docker", "start", name], stdout=subprocess.DEVNULL)
+        host_term = os.environ.get("TERM", "xterm-256color")
+        run_command(["docker", "exec", "-it", "-e", f"TERM={host_term}", name, "zsh"])
+        return None
+    if action == "logs":
+        pager = os.environ.get("PAGER", "less")
+        run_command(f"docker logs --timestamps {shlex.quote(name)} 2>&1 | {pager}", shell=True)
+        return None
+    if action == "stop":
+        stop_container(name)
+        return f"Stopped {name}"
+    if action == "destroy":
+        destroy_container(name)
+        return f"Destroyed {name}"
+    if action == "rebuild":
+        # Recreated from the current image the next time vibecon runs in the project
+        destroy_container(name, keep_volumes=True)
+        return f"Removed {name}; it is recreated from the current image on the next vibecon run"
+
+
+def ui_command(argv):
+    """vibecon ui - terminal dashboard for all vibecon containers"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon ui",
+        description="Terminal dashboard of all vibecon containers: status, resource usage, activity and image age"
+    )
+    parser.add_argument("--interval", type=float, default=2.0, metavar="SECONDS", help="refresh interval (default: 2)")
+    args = parser.parse_args(argv)
+
+    # curses is missing from some Python builds (Windows), so only import it here
+    try:
+        import curses
+    except ImportError:
+        fail(None, "vibecon ui needs the Python curses module")
+
+    state = {"lock": threading.Lock(), "containers": [], "stats": {}, "loaded": False, "selected": 0}
+    while True:
+        stop_event = threading.Event()
+        threading.Thread(target=poll_dashboard, args=(state, args.interval, stop_event), daemon=True).start()
+        try:
+            choice = curses.wrapper(run_dashboard, state)
+        except KeyboardInterrupt:
+            choice = None
+        finally:
+            stop_event.set()
+        if choice is None:
+            return 0
+        state["message"] = dashboard_action(*choice)
+
+
+def config_command(argv):
+    """vibecon config <action> - inspect configuration"""
+    parser = argparse.ArgumentParser(prog="vibecon config", description="Inspect vibecon configuration")
+    actions = parser.add_subparsers(dest="action", required=True)
+    actions.add_parser("validate", help="validate ~/.vibecon.json and the project .vibecon.json against the schema")
+    show_parser = actions.add_parser("show", help="show the effective merged config and the resulting docker run command")
+    show_parser.add_argument("-p", "--profile", action="append", default=[], metavar="NAME", help="apply a named profile")
+    args = parser.parse_args(argv)
+
+    if args.action == "validate":
+        return validate_config_files()
+    if args.action == "show":
+        return show_config(args.profile or env_profiles())
+
+
+def fuzzy_match(query, text):
+    """Whether the characters of query appear in text in order, ignoring case"""
+    position = 0
+    text = text.lower()
+    for char in query.lower():
+        position = text.find(char, position) + 1
+        if not position:
+            return False
+    return True
+
+
+def run_picker(stdscr, containers, title):
+    """Picker event loop: type to filter, arrows to move, enter to pick; returns a container or None"""
+    import curses
+
+    query = ""
+    selected = 0
+    while True:
+        matches = [container for container in containers
+                   if fuzzy_match(query, f"{container['project'] or ''} {container['name']}")]
+        selected = min(selected, max(0, len(matches) - 1))
+        height, width = stdscr.getmaxyx()
+        visible = max(1, height - 3)
+        offset = max(0, selected - visible + 1)
+
+        stdscr.erase()
+        stdscr.addnstr(0, 0, f"{title} (type to filter, enter to pick, esc to cancel)", width - 1, curses.A_BOLD)
+        for row, container in enumerate(matches[offset:offset + visible]):
+            line = (f"{container['project'] or '-':<48} {container['status']:<9} "
+                    f"{format_age(container['last_activity']):<10} {container['name']}")
+            attributes = curses.A_REVERSE if offset + row == selected else curses.A_NORMAL
+            stdscr.addnstr(row + 2, 0, line, width - 1, attributes)
+        if not matches:
+            stdscr.addnstr(2, 0, "no matches", width - 1)
+        stdscr.addnstr(1, 0, f"> {query}", width - 1)
+
+        key = stdscr.get_wch()
+        if key in ("\n", "\r", curses.KEY_ENTER):
+            return matches[selected] if matches else None
+        if key == "\x1b":
+            return None
+        if key == curses.KEY_UP:
+            selected = max(selected - 1, 0)
+        elif key == curses.KEY_DOWN:
+            selected = min(selected + 1, max(0, len(matches) - 1))
+        elif key in (curses.KEY_BACKSPACE, "\x7f", "\b"):
+            query = query[:-1]
+        elif isinstance(key, str) and key.isprintable():
+            query += key
+            selected = 0
+
+
+def pick_container(containers, title):
+    """Let the user pick one of several containers by workspace path; None if cancelled.
+
+    A single container is picked without asking. Without a terminal, the
+    candidates are listed and vibecon exits with an error.
+    """
+    if len(containers) <= 1:
+        return containers[0] if containers else None
+    containers = sorted(containers, key=lambda container: container["last_activity"] or 0, reverse=True)
+    if not (sys.stdin.isatty() and sys.stdout.isatty()):
+        for container in containers:
+            print(f"  {container['name']:<40} {container['project'] or '-'}")
+        fail(None, "Several containers match (listed above)", "Run this in a workspace or in a terminal to pick one")
+    try:
+        import curses
+    except ImportError:
+        # No curses on some Python builds (Windows): pick by number instead
+        for index, container in enumerate(containers, 1):
+            print(f"{index:>3}) {container['project'] or '-':<48} {container['name']}")
+        try:
+            answer = input(f"{title} [1-{len(containers)}]: ").strip()
+        except EOFError:
+            return None
+        return containers[int(answer) - 1] if answer.isdigit() and 1 <= int(answer) <= len(containers) else None
+    os.environ.setdefault("ESCDELAY", "25")
+    try:
+        return curses.wrapper(run_picker, containers, title)
+    except KeyboardInterrupt:
+        return None
+
+
+def workspace_or_picked_container(profiles, title, running_only=False):
+    """The current workspace's container, or outside a workspace one picked from all vibecon containers"""
+    found = locate_project_root()
+    if found:
+        return generate_container_name(found[0], profiles or env_profiles())
+    containers = list_vibecon_containers()
+    if running_only:
+        containers = [container for container in containers if container["status"] == "running"]
+    if not containers:
+        print("Not in a vibecon workspace, and there are no" + (" running" if running_only else "") + " vibecon containers.")
+        return None
+    container = pick_container(containers, title)
+    return container["name"] if container else None
+
+
+def list_command(argv):
+    """vibecon list - list all vibecon containers"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon list",
+        description="List all vibecon containers with their workspace paths"
+    )
+    parser.add_argument("--json", action="store_true", help="print the containers as JSON")
+    parser.add_argument("--pick", action="store_true",
+                        help="pick a container interactively and print its name, e.g. for docker logs $(vibecon list --pick)")
+    args = parser.parse_args(argv)
+
+    containers = sorted(list_vibecon_containers(), key=lambda container: container["last_activity"] or 0, reverse=True)
+    if args.pick:
+        # curses draws on stdout, so point it at stderr to keep only the choice in stdout
+        sys.stdout.flush()
+        saved_stdout = os.dup(1)
+        os.dup2(2, 1)
+        try:
+            container = pick_container(containers, "Pick a container")
+        finally:
+            sys.stdout.flush()
+            os.dup2(saved_stdout, 1)
+            os.close(saved_stdout)
+        if container is None:
+            return 1
+        print(container["name"])
+        return 0
+    if args.json:
+        print(json.dumps(containers, indent=2))
+        return 0
+    if not containers:
+        print("No vibecon containers.")
+        return 0
+    print(f"{'WORKSPACE':<48} {'STATUS':<9} {'ACTIVE':<10} CONTAINER")
+    for container in containers:
+        outdated = style(" (outdated image)", "yellow") if container["outdated"] else ""
+        print(f"{container['project'] or '-':<48} {container['status']:<9} "
+              f"{format_age(container['last_activity']):<10} {container['name']}{outdated}")
+    return 0
+
+
+def attach_command(argv):
+    """vibecon attach [SESSION] - reattach to a tmux session started with --tmux"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon attach",
+        description="Reattach to an agent session started with --tmux (the most recently active one by default)"
+    )
+    parser.add_argument("session", nargs="?", help="session name (see --list)")
+    parser.add_argument("-l", "--list", action="store_true", help="list the sessions in the container")
+    parser.add_argument("-p", "--profile", action="append", default=[], metavar="NAME", help="apply a named profile")
+    parser.add_argument("--sandbox", action="store_true", help="attach in the --sandbox container")
+    args = parser.parse_args(argv)
+
+    container_name = workspace_or_picked_container(args.profile, "Attach to", running_only=True)
+    if container_name is None:
+        return 1
+    if args.sandbox:
+        container_name = sandbox_container_name(container_name)
+    if not is_container_running(container_name):
+        fail(None, f"Container '{container_name}' is not running, so it has no sessions")
+
+    sessions = list_tmux_sessions(container_name)
+    if args.list:
+        if not sessions:
+            print("No sessions.")
+        for session in sessions:
+            attached = "attached" if session["attached"] else "detached"
+            print(f"{session['name']:<20}{attached:<10}active {format_age(session['activity'])}")
+        return 0
+
+    if args.session:
+        session = next((session for session in sessions if session["name"] == args.session), None)
+        if session is None:
+            fail(None, f"No session '{args.session}' (see 'vibecon attach --list')")
+    elif sessions:
+        session = sessions[0]
+    else:
+        fail(None, "No sessions to attach to; start commands with 'vibecon --tmux' to be able to reattach")
+
+    return attach_tmux_session(container_name, session["name"])
+
+
+def attach_tmux_session(container_name, session_name):
+    """Attach the terminal to a vibecon tmux session in the container. Returns the exit code."""
+    host_term = os.environ.get("TERM", "xterm-256color")
+    return run_command([
+        "docker", "exec", "-it", "-e", f"TERM={host_term}", container_name,
+        "tmux", "-L", TMUX_SOCKET_PREFIX + session_name, "attach-session", "-t", session_name,
+    ]).returncode
+
+
+def run_prefixed(container, command, prefix, output_lock):
+    """Run a command in a container, printing its output lines with a prefix. Returns the exit code."""
+    exec_args = ["docker", "exec", "-i"]
+    if container["workdir"]:
+        exec_args += ["-w", container["workdir"]]
+    record_activity(container["name"])
+    LOG.debug("%s", format_command(exec_args + [container["name"]] + command))
+    process = subprocess.Popen(
+        exec_args + [container["name"]] + command,
+        stdin=subprocess.DEVNULL,
+        stdout=subprocess.PIPE,
+        stderr=subprocess.STDOUT,
+        text=True,
+        errors="replace"
+    )
+    for line in process.stdout:
+        with output_lock:
+            print(f"{prefix} {line.rstrip()}", flush=True)
+    return process.wait()
+
+
+def each_command(argv):
+    """vibecon each CMD - run a command in every vibecon container concurrently"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon each",
+        description="Run a command in all running vibecon containers at once, with output prefixed by workspace",
+        epilog="example: vibecon each -f '~/src/*' -- git pull"
+    )
+    parser.add_argument("-f", "--filter", action="append", metavar="GLOB",
+                        help="only workspaces whose path or container name matches (repeatable)")
+    parser.add_argument("-a", "--all", action="store_true", help="start stopped containers too")
+    parser.add_argument("-j", "--jobs", type=int, default=8, metavar="N", help="run in at most N containers at once (default: 8)")
+    parser.add_argument("command", nargs=argparse.REMAINDER, help="command to run")
+    args = parser.parse_args(argv)
+
+    command = args.command[1:] if args.command[:1] == ["--"] else args.command
+    if not command:
+        parser.error("no command given")
+
+    containers = list_vibecon_containers()
+    if args.filter:
+        patterns = [os.path.expanduser(pattern) for pattern in args.filter]
+        containers = [
+            container for container in containers
+            if any(fnmatch.fnmatch(container["project"] or "", pattern) or fnmatch.fnmatch(container["name"], pattern)
+                   for pattern in patterns)
+        ]
+    if not args.all:
+        containers = [container for container in containers if container["status"] == "running"]
+    if not containers:
+        print("No matching containers" + ("" if args.all else " running (use -a to start stopped ones)") + ".")
+        return 1
+
+    for container in containers:
+        if container["status"] != "running":
+            print(f"Starting {container['name']}...")
+            run_docker(["docker", "start", container["name"]], stdout=subprocess.DEVNULL)
+
+    # Label output by project directory, falling back to the container name for duplicates
+    labels = [os.path.basename(container["project"] or "") or container["name"] for container in containers]
+    labels = [label if labels.count(label) == 1 else container["name"] for label, container in zip(labels, containers)]
+    width = max(len(label) for label in labels)
+    colors = ["cyan", "magenta", "yellow", "blue", "green"]
+    prefixes = [style(f"{label:<{width}} |", colors[index % len(colors)]) for index, label in enumerate(labels)]
+
+    output_lock = threading.Lock()
+    with concurrent.futures.ThreadPoolExecutor(max_workers=max(1, args.jobs)) as executor:
+        futures = [
+            executor.submit(run_prefixed, container, command, prefix, output_lock)
+            for container, prefix in zip(containers, prefixes)
+        ]
+        returncodes = [future.result() for future in futures]
+
+    failed = [label for label, returncode in zip(labels, returncodes) if returncode != 0]
+    print()
+    if failed:
+        print(style(f"Failed in {len(failed)} of {len(containers)}: {', '.join(failed)}", "red"))
+        return 1
+    success(f"Succeeded in all {len(containers)} containers.")
+    return 0
+
+
+# Session recordings, as asciinema v2 casts in a directory per workspace
+RECORDINGS_DIR = Path.home() / ".local" / "share" / "vibecon" / "recordings"
+
+
+def recording_supported():
+    """Recording needs POSIX pseudo-terminals"""
+    if sys.platform.startswith("win"):
+        warn("session recording is not supported on Windows; running without it")
+        return False
+    return True
 
-                # If statusLine has a command, add that file to copy list
-                if "command" in settings["statusLine"]:
-                    cmd_path = settings["statusLine"]["command"]
-                    # Expand ~ to home directory
-                    if cmd_path.startswith("~"):
-                        cmd_path = str(Path.home()) + cmd_path[1:]
-                    cmd_file = Path(cmd_path)
-                    if cmd_file.exists():
-                        files_to_copy.append(cmd_file)
 
-        except (json.JSONDecodeError, IOError) as e:
-            print(f"Warning: Failed to parse settings.json: {e}")
+def workspace_recordings_dir(project_root):
+    return RECORDINGS_DIR / generate_container_name(str(project_root))
 
-    # Ensure container directory exists
-    subprocess.run(
-        ["docker", "exec", container_name, "mkdir", "-p", container_claude_dir],
-        stdout=subprocess.DEVNULL,
-        stderr=subprocess.DEVNULL
-    )
 
-    # Handle CLAUDE.md sync: copy if exists locally, remove from container if not
-    if claude_md_file.exists():
-        files_to_copy.append(claude_md_file)
-    else:
-        # Remove CLAUDE.md from container if it doesn't exist locally
-        subprocess.run(
-            ["docker", "exec", container_name, "rm", "-f", f"{container_claude_dir}/CLAUDE.md"],
-            stdout=subprocess.DEVNULL,
-            stderr=subprocess.DEVNULL
-        )
+def new_recording_path(project_root, command):
+    """Path for a new recording of command, named after the time and command"""
+    directory = workspace_recordings_dir(project_root)
+    directory.mkdir(parents=True, exist_ok=True)
+    name = re.sub(r"[^A-Za-z0-9_-]", "-", posixpath.basename(command[0])) if command else "session"
+    return directory / f"{time.strftime('%Y%m%d-%H%M%S')}-{name}.cast"
 
-    # Handle commands directory sync (may be a symlink)
-    commands_dir = claude_dir / "commands"
-    commands_source = None
-    if commands_dir.exists():
-        # Resolve symlink if it is one
-        commands_source = commands_dir.resolve()
-        if not commands_source.is_dir():
-            commands_source = None
-
-    if commands_source:
-        # Remove existing commands directory to ensure clean sync (no stale files)
-        subprocess.run(
-            ["docker", "exec", container_name, "rm", "-rf", f"{container_claude_dir}/commands"],
-            stdout=subprocess.DEVNULL,
-            stderr=subprocess.DEVNULL
-        )
-        # Create fresh commands directory
-        subprocess.run(
-            ["docker", "exec", container_name, "mkdir", "-p", f"{container_claude_dir}/commands"],
-            stdout=subprocess.DEVNULL,
-            stderr=subprocess.DEVNULL
-        )
-        # Copy commands directory using tar
-        tar_create = subprocess.Popen(
-            ["tar", "-cf", "-", "."],
-            cwd=str(commands_source),
-            stdout=subprocess.PIPE,
-            stderr=subprocess.PIPE
-        )
-        tar_extract = subprocess.run(
-            ["docker", "exec", "-i", container_name, "tar", "-xf", "-", "-C", f"{container_claude_dir}/commands"],
-            stdin=tar_create.stdout,
-            stdout=subprocess.PIPE,
-            stderr=subprocess.PIPE
-        )
-        tar_create.wait()
-        if tar_extract.returncode != 0:
-            print(f"Warning: Failed to copy commands directory: {tar_extract.stderr.decode()}")
-    else:
-        # Remove commands directory from container if it doesn't exist locally
-        subprocess.run(
-            ["docker", "exec", container_name, "rm", "-rf", f"{container_claude_dir}/commands"],
-            stdout=subprocess.DEVNULL,
-            stderr=subprocess.DEVNULL
-        )
 
-    # Copy files using tar if we have any
-    if files_to_copy:
-        # Build tar with files, preserving just the filename (not full path)
-        # We need to handle files from different directories
-        with tempfile.TemporaryDirectory() as tmpdir:
-            tmpdir_path = Path(tmpdir)
-            for src_file in files_to_copy:
-                # Copy to temp dir with just the filename
-                dest = tmpdir_path / src_file.name
-                dest.write_bytes(src_file.read_bytes())
-                # Preserve executable bit
-                if os.access(src_file, os.X_OK):
-                    dest.chmod(dest.stat().st_mode | 0o111)
-
-            # Tar and copy all files at once
-            tar_create = subprocess.Popen(
-                ["tar", "-cf", "-", "."],
-                cwd=str(tmpdir_path),
-                stdout=subprocess.PIPE,
-                stderr=subprocess.PIPE
-            )
-            tar_extract = subprocess.run(
-                ["docker", "exec", "-i", container_name, "tar", "-xf", "-", "-C", container_claude_dir],
-                stdin=tar_create.stdout,
-                stdout=subprocess.PIPE,
-                stderr=subprocess.PIPE
-            )
-            tar_create.wait()
-            if tar_extract.returncode != 0:
-                print(f"Warning: Failed to copy files: {tar_extract.stderr.decode()}")
-
-    # Write container settings.json if we have any settings to write
-    if container_settings:
-        settings_json = json.dumps(container_settings, indent=2)
-        subprocess.run(
-            ["docker", "exec", container_name, "sh", "-c",
-             f"cat > {container_claude_dir}/settings.json << 'EOFCONFIG'\n{settings_json}\nEOFCONFIG"],
-            stdout=subprocess.DEVNULL,
-            stderr=subprocess.PIPE
-        )
+def record_command(args, env, cast_path):
+    """Run an interactive command in a pseudo-terminal, recording its output to an asciinema cast.
 
-    # Fix ownership for node user
-    subprocess.run(
-        ["docker", "exec", "-u", "root", container_name, "chown", "-R", "node:node", container_claude_dir],
-        stdout=subprocess.DEVNULL,
-        stderr=subprocess.DEVNULL
+    Returns the command's exit code.
+    """
+    import codecs
+    import fcntl
+    import pty
+    import select
+    import signal
+    import struct
+    import termios
+    import tty
+
+    def terminal_size():
+        columns, lines = shutil.get_terminal_size()
+        return columns, lines
+
+    columns, lines = terminal_size()
+    pid, master = pty.fork()
+    if pid == 0:
+        # docker exec -t sizes the container's terminal from ours
+        fcntl.ioctl(0, termios.TIOCSWINSZ, struct.pack("HHHH", lines, columns, 0, 0))
+        os.execvpe(args[0], args, env)
+
+    resizes = []
+
+    def resize(signum, frame):
+        columns, lines = terminal_size()
+        fcntl.ioctl(master, termios.TIOCSWINSZ, struct.pack("HHHH", lines, columns, 0, 0))
+        resizes.append((time.monotonic(), columns, lines))
+
+    previous_handler = signal.signal(signal.SIGWINCH, resize)
+    stdin_attrs = termios.tcgetattr(0) if os.isatty(0) else None
+    if stdin_attrs:
+        tty.setraw(0)
+    decoder = codecs.getincrementaldecoder("utf-8")(errors="replace")
+    start = time.monotonic()
+    try:
+        with open(cast_path, "w") as cast:
+            header = {"version": 2, "width": columns, "height": lines, "timestamp": int(time.time()),
+                      "env": {"TERM": env.get("TERM", ""), "SHELL": os.environ.get("SHELL", "")}}
+            cast.write(json.dumps(header) + "\n")
+            inputs = [master, 0]
+            while True:
+                ready, _, _ = select.select(inputs, [], [])
+                # Resize events, so players can follow the terminal's size
+                while resizes:
+                    at, columns, lines = resizes.pop(0)
+                    cast.write(json.dumps([round(at - start, 6), "r", f"{columns}x{lines}"]) + "\n")
+                if master in ready:
+                    try:
+                        data = os.read(master, 65536)
+                    except OSError:
+                        data = b""
+                    if not data:
+                        break
+                    os.write(1, data)
+                    cast.write(json.dumps([round(time.monotonic() - start, 6), "o", decoder.decode(data)]) + "\n")
+                if 0 in ready:
+                    data = os.read(0, 65536)
+                    if data:
+                        os.write(master, data)
+                    else:
+                        inputs.remove(0)
+    finally:
+        if stdin_attrs:
+            termios.tcsetattr(0, termios.TCSAFLUSH, stdin_attrs)
+        signal.signal(signal.SIGWINCH, previous_handler)
+        os.close(master)
+    _, status = os.waitpid(pid, 0)
+    return os.waitstatus_to_exitcode(status)
+
+
+def replay_command(argv):
+    """vibecon replay [FILE] - play back a recorded session"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon replay",
+        description="Play back a session recorded with --record (the workspace's latest by default)"
     )
+    parser.add_argument("recording", nargs="?", metavar="FILE", help="cast file or its name from --list")
+    parser.add_argument("-l", "--list", action="store_true", help="list the workspace's recordings")
+    parser.add_argument("-s", "--speed", type=float, default=1.0, help="playback speed (default: 1)")
+    parser.add_argument("--max-idle", type=float, default=2.0, metavar="SECONDS", help="cap pauses at this length (default: 2)")
+    args = parser.parse_args(argv)
+
+    project_root, _, _ = find_project_root()
+    directory = workspace_recordings_dir(project_root)
+    recordings = sorted(directory.glob("*.cast")) if directory.is_dir() else []
+    if args.list:
+        if not recordings:
+            print("No recordings.")
+        for recording in recordings:
+            print(f"{format_size(recording.stat().st_size):>9}  {recording.name}")
+        return 0
+
+    if args.recording:
+        cast_path = Path(args.recording)
+        if not cast_path.exists():
+            cast_path = directory / args.recording
+    elif recordings:
+        cast_path = recordings[-1]
+    else:
+        fail(None, "No recordings for this workspace; record one with 'vibecon --record'")
+    if not cast_path.exists():
+        fail(None, f"Recording not found: {args.recording}")
+
+    with open(cast_path) as cast:
+        header = json.loads(cast.readline())
+        print(f"Replaying {cast_path.name} ({header['width']}x{header['height']}); Ctrl+C to stop")
+        previous = 0.0
+        try:
+            for line in cast:
+                timestamp, kind, data = json.loads(line)
+                if kind != "o":
+                    continue
+                time.sleep(min(timestamp - previous, args.max_idle) / args.speed)
+                previous = timestamp
+                sys.stdout.write(data)
+                sys.stdout.flush()
+        except KeyboardInterrupt:
+            pass
+    print()
+    return 0
 
 
-def start_container(project_root, container_name, image_name, container_mount_root, config=None):
-    """Start the container in detached mode
+# Workspaces vibecon ran in, with the profiles used, for 'vibecon prestart'
+WORKSPACES_FILE = Path.home() / ".local" / "share" / "vibecon" / "workspaces.json"
 
-    Args:
-        project_root: Host path to mount as project root
-        container_name: Name for the container
-        image_name: Docker image to use
-        container_mount_root: Path inside container where project_root is mounted
-        config: Optional config with mounts
-    """
-    if config is None:
-        config = {"volumes": {}, "mounts": []}
 
-    host_term = os.environ.get("TERM", "xterm-256color")
-    container_hostname = "vibecon"
+def read_workspaces():
+    try:
+        workspaces = json.loads(WORKSPACES_FILE.read_text())
+    except (OSError, ValueError):
+        return []
+    return workspaces if isinstance(workspaces, list) else []
 
-    # Get git user info from host
-    git_user_name, git_user_email = get_git_user_info()
-    if git_user_name:
-        print(f"Configuring git user: {git_user_name} <{git_user_email}>")
 
-    # Get host timezone
-    host_timezone = get_host_timezone()
-    print(f"Configuring timezone: {host_timezone}")
+def register_workspace(project_root, profile_names):
+    """Remember a workspace and its profiles, most recently used first"""
+    entry = {"project": project_root, "profiles": list(profile_names or [])}
+    workspaces = [entry] + [workspace for workspace in read_workspaces() if workspace != entry]
+    try:
+        WORKSPACES_FILE.parent.mkdir(parents=True, exist_ok=True)
+        WORKSPACES_FILE.write_text(json.dumps(workspaces, indent=2) + "\n")
+    except OSError as e:
+        LOG.warning("could not update %s: %s", WORKSPACES_FILE, e)
 
-    print(f"Starting container '{container_name}' with {project_root} mounted at {container_mount_root}...")
 
-    # Build docker run command
-    docker_cmd = [
-        "docker", "run",
-        "-d",
-        "--name", container_name,
-        "--hostname", container_hostname,
-        "-e", f"TERM={host_term}",
-        "-e", "COLORTERM=truecolor",
-        "-e", f"TZ={host_timezone}",
-    ]
+def prestart_workspace(workspace, vibecon_root):
+    """Create or start the container of a registered workspace. Returns its name, or None if gone."""
+    try:
+        os.chdir(workspace["project"])
+    except OSError:
+        return None
+    found = locate_project_root()
+    if not found or found[0] != workspace["project"]:
+        return None
+    project_root, root_config, container_mount_root = found
+    container_name = generate_container_name(project_root, workspace["profiles"])
+    config = layer_config(apply_profiles(get_merged_config(root_config, container_mount_root), workspace["profiles"]), env_overrides())
+    image_name = config.get("image", IMAGE_NAME)
+    ensure_container_running(project_root, vibecon_root, container_name, image_name, container_mount_root, config)
+    return container_name
 
-    # Add git user environment variables if available
-    if git_user_name:
-        docker_cmd.extend([
-            "-e", f"GIT_USER_NAME={git_user_name}",
-            "-e", f"GIT_USER_EMAIL={git_user_email}",
-        ])
 
-    # Add main workspace volume mount
-    docker_cmd.extend(["-v", f"{project_root}:{container_mount_root}"])
+def prestart_command(argv):
+    """vibecon prestart - start the containers of recently used workspaces ahead of time"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon prestart",
+        description="Create and start the containers of the workspaces vibecon was used in, e.g. from a login hook, "
+                    "so the first vibecon of the day starts right away"
+    )
+    parser.add_argument("-n", "--max", type=int, default=10, metavar="N",
+                        help="only the N most recently used workspaces (default: 10)")
+    parser.add_argument("-l", "--list", action="store_true", help="list the registered workspaces")
+    parser.add_argument("-q", "--quiet", action="store_true", help="only print errors")
+    args = parser.parse_args(argv)
+
+    workspaces = read_workspaces()
+    if args.list:
+        if not workspaces:
+            print("No workspaces yet; they are registered when vibecon runs in them.")
+        for workspace in workspaces:
+            profiles = f" (profiles: {', '.join(workspace['profiles'])})" if workspace["profiles"] else ""
+            print(f"{workspace['project']}{profiles}")
+        return 0
 
-    # Add extra mounts from config
-    for mount_spec in config.get("mounts", []):
-        mount_args = parse_mount(mount_spec, cwd, container_name)
-        docker_cmd.extend(mount_args)
+    vibecon_root = find_vibecon_root()
+    if not vibecon_root:
+        fail(None, "Could not find Dockerfile in vibecon.py directory")
+    set_docker_retry(load_config(global_config_path()))
+    wait_for_docker()
 
-    # Add image name
-    docker_cmd.append(image_name)
+    cwd = os.getcwd()
+    failed = 0
+    for workspace in workspaces[:max(0, args.max)]:
+        try:
+            with contextlib.redirect_stdout(io.StringIO() if args.quiet else sys.stdout):
+                container_name = prestart_workspace(workspace, vibecon_root)
+        except SystemExit:
+            # Errors were printed already; carry on with the other workspaces
+            warn(f"could not start the container for {workspace['project']}")
+            failed += 1
+            continue
+        finally:
+            os.chdir(cwd)
+        if container_name is None:
+            LOG.info("prestart: skipped %s, it is no longer a vibecon workspace", workspace["project"])
+        elif not args.quiet:
+            success(f"{container_name} is running ({workspace['project']})")
+    return 1 if failed else 0
+
+
+def build_latest_image(vibecon_root, force=False):
+    """Build vibecon:latest when the tools have new versions (-b), or always when force (-B)"""
+    global_config = load_config(global_config_path())
+    tools = get_tools(global_config)
+    versions = get_all_versions(tools)
+    composite_tag = make_composite_tag(versions, tools)
+    versioned_image = f"vibecon:{composite_tag}"
+
+    if image_exists(versioned_image) and not force:
+        print(f"\nImage already exists: {versioned_image}")
+        print("No rebuild needed - all versions are up to date.")
+        print("Use -B/--force-build to rebuild anyway.")
+    else:
+        if force and image_exists(versioned_image):
+            print(f"\nForce rebuild requested...")
+        else:
+            print(f"\nNew versions detected, building image...")
+        build_image(vibecon_root, IMAGE_NAME, versions, global_config)
+        print(f"\nBuild complete! Image tagged as:")
+        print(f"  - {IMAGE_NAME}")
+        print(f"  - {versioned_image}")
 
-    # Start container detached with sleep infinity to keep it running
-    run_result = subprocess.run(
-        docker_cmd,
-        stdout=subprocess.PIPE,
-        stderr=subprocess.PIPE
+
+def upgrade_command(argv):
+    """vibecon upgrade - rebuild the image if needed and recreate the containers running an outdated one"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon upgrade",
+        description="Check versions and rebuild the image like 'vibecon -b', then recreate the containers that run an "
+                    "outdated image, keeping their volumes and applying their workspace's current config"
     )
+    parser.add_argument("-B", "--force-build", action="store_true", help="rebuild the image even if all versions are up to date")
+    parser.add_argument("--no-build", action="store_true", help="skip the version check, only recreate outdated containers")
+    parser.add_argument("-y", "--yes", action="store_true", help="recreate all outdated containers without asking")
+    args = parser.parse_args(argv)
 
-    if run_result.returncode != 0:
-        print(f"Failed to start container: {run_result.stderr.decode()}")
-        sys.exit(1)
+    vibecon_root = find_vibecon_root()
+    if not vibecon_root:
+        fail(None, "Could not find Dockerfile in vibecon.py directory")
+    set_docker_retry(load_config(global_config_path()))
+    wait_for_docker()
+    if not args.no_build:
+        build_latest_image(vibecon_root, args.force_build)
+
+    outdated = [container for container in list_vibecon_containers() if container["outdated"]]
+    if not outdated:
+        success("All containers run the current image.")
+        return 0
+    print(f"\n{len(outdated)} container(s) run an outdated image.")
+
+    # The registry knows which profiles a container was created with
+    workspaces = {generate_container_name(workspace["project"], workspace["profiles"]): workspace
+                  for workspace in read_workspaces()}
+    cwd = os.getcwd()
+    failed = 0
+    for container in outdated:
+        name = container["name"]
+        if not args.yes and not confirm(f"Recreate {name} ({container['project'] or 'unknown workspace'})? "
+                                        "Changes outside the workspace and volumes are lost"):
+            continue
+        print(f"Removing container '{name}'...")
+        remove_container(name)
+        workspace = workspaces.get(name)
+        # Stopped containers and those of unregistered workspaces (sandboxes,
+        # moved projects) are recreated when vibecon next runs in them
+        if container["status"] != "running" or workspace is None:
+            print("  It is recreated from the new image on the next vibecon run")
+            continue
+        try:
+            recreated = prestart_workspace(workspace, vibecon_root)
+        except SystemExit:
+            # Errors were printed already; carry on with the other containers
+            warn(f"could not recreate the container for {workspace['project']}")
+            failed += 1
+            continue
+        finally:
+            os.chdir(cwd)
+        if recreated:
+            success(f"Recreated {name} ({workspace['project']})")
+        else:
+            print(f"  {workspace['project']} is no longer a vibecon workspace, so the container was not recreated")
+    return 1 if failed else 0
 
-def ensure_container_running(project_root, vibecon_root, container_name, image_name, container_mount_root, config=None):
-    """Ensure container is running
 
-    Args:
-        project_root: Host path to mount as project root
-        vibecon_root: Path to vibecon installation (for building image)
-        container_name: Name for the container
-        image_name: Docker image to use
-        container_mount_root: Path inside container where project_root is mounted
-        config: Optional config with mounts
+def bench_command(argv):
+    """vibecon bench - time the stages of starting a command in the workspace container"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon bench",
+        description="Measure how long each startup stage takes over several runs, to spot slow configs and regressions"
+    )
+    parser.add_argument("-n", "--runs", type=int, default=5, metavar="N", help="number of runs (default: 5)")
+    parser.add_argument("--cold", action="store_true", help="stop the container before each run to include its start")
+    parser.add_argument("--json", action="store_true", help="print the timings in seconds as JSON")
+    parser.add_argument("-p", "--profile", action="append", default=[], metavar="NAME", help="apply a named profile")
+    args = parser.parse_args(argv)
+
+    vibecon_root = find_vibecon_root()
+    profile_names = args.profile or env_profiles()
+    stages = ["config", "inspect", "start", "sync", "exec"]
+    timings = {stage: [] for stage in stages}
+
+    for run in range(max(1, args.runs)):
+        if not args.json:
+            print(f"Run {run + 1}/{args.runs}...", file=sys.stderr)
+        # Stage output would drown the report
+        with contextlib.redirect_stdout(io.StringIO()):
+            start = time.monotonic()
+            project_root, root_config, container_mount_root = find_project_root()
+            container_name = generate_container_name(project_root, profile_names)
+            config = layer_config(apply_profiles(get_merged_config(root_config, container_mount_root), profile_names), env_overrides())
+            image_name = config.get("image", IMAGE_NAME)
+            container_workdir = get_container_workdir(os.getcwd(), project_root, container_mount_root)
+            timings["config"].append(time.monotonic() - start)
+
+            if args.cold:
+                run_command(["docker", "stop", container_name], stdout=subprocess.DEVNULL, stderr=subprocess.DEVNULL)
+
+            start = time.monotonic()
+            is_container_running(container_name)
+            timings["inspect"].append(time.monotonic() - start)
+
+            start = time.monotonic()
+            ensure_container_running(project_root, vibecon_root, container_name, image_name, container_mount_root, config)
+            timings["start"].append(time.monotonic() - start)
+
+            start = time.monotonic()
+            sync_workspace(container_name, project_root, container_mount_root, config)
+            if host_sync_enabled(config):
+                sync_to_container(container_name, config, container_workdir)
+            timings["sync"].append(time.monotonic() - start)
+
+            start = time.monotonic()
+            run_command(["docker", "exec", "-w", container_workdir, container_name, "true"])
+            timings["exec"].append(time.monotonic() - start)
+
+    if args.json:
+        print(json.dumps(timings, indent=2))
+        return 0
+    print(f"\n{'STAGE':<10}{'MIN':>9}{'MEDIAN':>9}{'MAX':>9}")
+    for stage in stages:
+        values = sorted(timings[stage])
+        print(f"{stage:<10}{values[0]:>8.2f}s{values[len(values) // 2]:>8.2f}s{values[-1]:>8.2f}s")
+    total = sorted(sum(run) for run in zip(*timings.values()))
+    print(f"{'total':<10}{total[0]:>8.2f}s{total[len(total) // 2]:>8.2f}s{total[-1]:>8.2f}s")
+    return 0
+
+
+def parse_cp_path(arg):
+    """Split a vibecon cp argument into (in_container, path).
+
+    Container paths start with ':' or 'c:' ('c:' is a drive letter on Windows).
     """
-    if is_container_running(container_name):
-        return  # Already running, nothing to do
+    if arg.startswith(":"):
+        return True, arg[1:]
+    if arg.startswith("c:") and not sys.platform.startswith("win"):
+        return True, arg[2:]
+    return False, arg
 
-    # Container is not running - check if it exists (stopped/dead)
-    if container_exists(container_name):
-        # Try to restart the stopped container
-        if restart_container(container_name):
-            return  # Successfully restarted
-        # Restart failed, remove and recreate
-        print("Restart failed, removing container and creating a new one...")
-        subprocess.run(
-            ["docker", "rm", "-f", container_name],
+
+def cp_command(argv):
+    """vibecon cp SRC DST - copy files between the host and the workspace container"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon cp",
+        description="Copy files between the host and the workspace's container. "
+                    "Container paths start with ':' (or 'c:'); relative ones are relative to the current directory's "
+                    "place in the container",
+        epilog="examples: vibecon cp :dist/app.tar.gz .   vibecon cp ~/data.csv :/tmp/"
+    )
+    parser.add_argument("source", metavar="SRC")
+    parser.add_argument("destination", metavar="DST")
+    parser.add_argument("-L", "--follow-link", action="store_true", help="follow symbolic links in SRC")
+    parser.add_argument("-p", "--profile", action="append", default=[], metavar="NAME", help="apply a named profile")
+    parser.add_argument("--sandbox", action="store_true", help="copy to or from the --sandbox container")
+    args = parser.parse_args(argv)
+
+    source_in_container, source = parse_cp_path(args.source)
+    destination_in_container, destination = parse_cp_path(args.destination)
+    if source_in_container == destination_in_container:
+        fail(None, "Exactly one of SRC and DST must be a container path (starting with ':' or 'c:')")
+
+    project_root, _, container_mount_root = find_project_root()
+    container_name = generate_container_name(project_root, args.profile or env_profiles())
+    if args.sandbox:
+        container_name = sandbox_container_name(container_name)
+    if not container_exists(container_name):
+        fail(None, f"Container '{container_name}' does not exist; start it with vibecon first")
+
+    container_workdir = get_container_workdir(os.getcwd(), project_root, container_mount_root)
+    if source_in_container:
+        source = posixpath.join(container_workdir, source)
+        result = run_command(["docker", "cp"] + (["-L"] if args.follow_link else []) +
+                             [f"{container_name}:{source}", destination])
+        return result.returncode
+
+    destination = posixpath.join(container_workdir, destination)
+    # docker cp creates files as root, so hand them to the node user afterwards
+    is_directory = run_command(
+        ["docker", "exec", container_name, "test", "-d", destination],
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.DEVNULL
+    ).returncode == 0
+    copied = posixpath.join(destination, os.path.basename(os.path.normpath(source))) if is_directory else destination
+    result = run_command(["docker", "cp"] + (["-L"] if args.follow_link else []) +
+                         [source, f"{container_name}:{destination}"])
+    if result.returncode != 0:
+        return result.returncode
+    if is_container_running(container_name):
+        run_command(
+            ["docker", "exec", "-u", "root", container_name, "chown", "-R", "node:node", copied],
             stdout=subprocess.DEVNULL,
             stderr=subprocess.DEVNULL
         )
+    return 0
+
+
+def exec_command(argv):
+    """vibecon exec [OPTIONS --] COMMAND... - run a command without parsing its arguments"""
+    if argv[:1] in (["-h"], ["--help"]):
+        print("usage: vibecon exec [OPTIONS --] COMMAND [ARG...]")
+        print()
+        print("Run COMMAND in the container exactly as given, including arguments that look like vibecon")
+        print("flags. vibecon options (-p, -e, --mount, ...) go before '--'; without '--' all arguments")
+        print("are the command.")
+        return 0
+    if "--" in argv:
+        split = argv.index("--")
+        options, command = argv[:split], argv[split + 1:]
+    else:
+        options, command = [], argv
+    if not command:
+        fail(None, "vibecon exec needs a command, e.g. 'vibecon exec -- go test -v ./...'")
+    if options and not options[0].startswith("-"):
+        fail(None, f"Expected vibecon options before '--', got: {options[0]}")
+    # "--" ends option parsing, so the command reaches the container untouched
+    return main(options + ["--"] + command)
 
-    # Build image only if it doesn't exist
-    if not image_exists(image_name):
-        print(f"Image '{image_name}' not found, building...")
-        build_image(vibecon_root, image_name)
-    start_container(project_root, container_name, image_name, container_mount_root, config)
 
-def main():
+def code_command(argv):
+    """vibecon code - open the container in VS Code"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon code",
+        description="Start the workspace's container and open it in VS Code through the Dev Containers extension, "
+                    "at the current directory"
+    )
+    parser.add_argument("-p", "--profile", action="append", default=[], metavar="NAME", help="apply a named profile")
+    parser.add_argument("--no-sync", action="store_true", help="skip copying host agent configs into the container")
+    args = parser.parse_args(argv)
+
+    code = shutil.which("code")
+    if not code:
+        fail(None, "'code' not found on PATH",
+             "In VS Code run 'Shell Command: Install 'code' command in PATH', and install the Dev Containers extension")
+    vibecon_root = find_vibecon_root()
+    if not vibecon_root:
+        fail(None, "Could not find Dockerfile in vibecon.py directory")
+    project_root, root_config, container_mount_root = find_project_root()
+    profile_names = args.profile or env_profiles()
+    container_name = generate_container_name(project_root, profile_names)
+    config = layer_config(apply_profiles(get_merged_config(root_config, container_mount_root), profile_names), env_overrides())
+    image_name = config.get("image", IMAGE_NAME)
+    container_workdir = get_container_workdir(os.getcwd(), project_root, container_mount_root)
+
+    set_docker_retry(config)
+    wait_for_docker()
+    ensure_container_running(project_root, vibecon_root, container_name, image_name, container_mount_root, config)
+    sync_workspace(container_name, project_root, container_mount_root, config)
+    if host_sync_enabled(config) and not args.no_sync:
+        sync_to_container(container_name, config, container_workdir)
+    wait_for_ready(container_name, config, container_mount_root)
+    run_on_create(container_name, config, container_mount_root, inject_secrets(container_name, config))
+    register_workspace(project_root, profile_names)
+
+    # The Dev Containers extension identifies attached containers by their hex-encoded name
+    uri = f"vscode-remote://attached-container+{container_name.encode().hex()}{container_workdir}"
+    return run_command([code, "--folder-uri", uri]).returncode
+
+
+def image_is_pinned(image_name):
+    """Whether an image reference names a fixed image: a digest or a tag other than latest"""
+    if "@" in image_name:
+        return True
+    name, _, tag = image_name.rpartition(":")
+    return bool(name) and "/" not in tag and tag != "latest"
+
+
+def write_report(path, report):
+    """Write a run report as JSON to path, or to stdout for -"""
+    content = json.dumps(report, indent=2) + "\n"
+    if path == "-":
+        sys.stdout.write(content)
+    else:
+        Path(path).write_text(content)
+
+
+def ci_command(argv):
+    """vibecon ci [OPTIONS] -- COMMAND... - run a command in a throwaway container for CI"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon ci",
+        description="Run a command in a fresh container of the workspace, the way CI runners need it: without a TTY, "
+                    "host config sync or version checks, from an existing or pinned image, and removed afterwards"
+    )
+    parser.add_argument("command", nargs=argparse.REMAINDER, help="command to run (default: the configured default command)")
+    parser.add_argument("--image", metavar="REF", help="image to use instead of 'image' from the config, ideally pinned by digest")
+    parser.add_argument("--result", metavar="FILE", help="write the result as JSON to FILE (- for stdout, after the command's output)")
+    parser.add_argument("--keep", action="store_true", help="keep the container for inspection instead of removing it")
+    parser.add_argument("-e", "--env", action="append", default=[], metavar="KEY[=VALUE]", help="set an environment variable (repeatable)")
+    parser.add_argument("--mount", action="append", default=[], metavar="SPEC", help="extra mount, as for vibecon --mount (repeatable)")
+    parser.add_argument("--network", "--net", metavar="NAME", help="network to join, e.g. the one of the job's service containers")
+    parser.add_argument("-p", "--profile", action="append", default=[], metavar="NAME", help="apply a named profile")
+    parser.set_defaults(port=[], publish_all=False)
+    args = parser.parse_args(argv)
+
+    project_root, root_config, container_mount_root = find_project_root()
+    vibecon_root = find_vibecon_root()
+    profile_names = args.profile or env_profiles()
+    config = layer_config(apply_profiles(get_merged_config(root_config, container_mount_root), profile_names), env_overrides())
+    config = layer_config(config, cli_overrides(args))
+    # Nothing from the runner's home directory or display, and no state shared with other runs
+    config = {
+        **config,
+        "claude_config_mode": "copy",
+        "shell_history": False,
+        "display": False,
+        "media": False,
+        "ssh": False,
+    }
+    image_name = args.image or config.get("image", IMAGE_NAME)
+    command = args.command[1:] if args.command[:1] == ["--"] else args.command
+    command = command or get_default_command(config)
+
+    set_docker_retry(config)
+    wait_for_docker()
+    # Building the default image would check the latest agent versions online
+    if image_name == IMAGE_NAME and not image_exists(image_name):
+        fail("image-missing", f"Image '{IMAGE_NAME}' does not exist and 'vibecon ci' doesn't build it",
+             "Set 'image' to an image pushed to a registry, or pass --image")
+    if not image_is_pinned(image_name):
+        warn(f"Image '{image_name}' is not pinned; use a version tag or digest for reproducible runs")
+
+    container_name = generate_container_name(project_root, profile_names) + f"--ci-{os.getpid()}"
+    container_workdir = get_container_workdir(os.getcwd(), project_root, container_mount_root)
+    started = time.time()
+    exit_code = None
+    try:
+        ensure_container_running(project_root, vibecon_root, container_name, image_name, container_mount_root, config)
+        apply_network_policy(container_name, config)
+        sync_workspace(container_name, project_root, container_mount_root, config)
+        secret_env_names = inject_secrets(container_name, config)
+        wait_for_ready(container_name, config, container_mount_root)
+        run_on_create(container_name, config, container_mount_root, secret_env_names)
+        wrapped_command = wrap_with_secret_env(command, secret_env_names)
+        if config.get("audit", False):
+            audit_exec(container_name, command, container_workdir)
+        exec_cmd = [
+            "docker", "exec",
+            "-w", container_workdir,
+            "-e", "CI=true",
+        ] + passthrough_env_args(config) + env_args(get_proxy_env(config)) + env_args(config.get("env", {})) + [
+            container_name
+        ] + wrapped_command
+        exit_code = run_command(exec_cmd, stdin=subprocess.DEVNULL).returncode
+        if config.get("audit", False):
+            collect_audit_log(container_name)
+        sync_workspace(container_name, project_root, container_mount_root, config)
+    finally:
+        finished = time.time()
+        if args.keep:
+            print(f"Keeping container '{container_name}' (remove it with 'docker rm -f {container_name}')", file=sys.stderr)
+        else:
+            with contextlib.redirect_stdout(sys.stderr):
+                destroy_container(container_name)
+        # Written when setup fails too, with a null exit_code
+        if args.result:
+            write_report(args.result, {
+                "container": container_name,
+                "image": image_name,
+                "command": command,
+                "exit_code": exit_code,
+                "started": datetime.datetime.fromtimestamp(started, datetime.timezone.utc).isoformat(),
+                "finished": datetime.datetime.fromtimestamp(finished, datetime.timezone.utc).isoformat(),
+                "duration": round(finished - started, 3),
+            })
+    return exit_code
+
+
+def read_batch_tasks(paths):
+    """Split prompt files into (name, prompt) tasks at lines consisting of ---"""
+    tasks = []
+    for path in paths:
+        try:
+            text = Path(path).read_text()
+        except OSError as e:
+            fail(None, f"Cannot read prompt file {path}: {e.strerror}")
+        for prompt in re.split(r"(?m)^---[ \t]*$", text):
+            prompt = prompt.strip()
+            if not prompt:
+                continue
+            title = prompt.splitlines()[0].lstrip("#").strip()
+            slug = re.sub(r"[^a-z0-9]+", "-", title.lower()).strip("-")[:40].rstrip("-")
+            tasks.append((slug or "task", prompt))
+    return tasks
+
+
+def run_batch_task(container_name, config, container_mount_root, container_workdir, prompt, claude_args, output_base):
+    """Run one prompt with 'claude -p' in a prepared container. Returns its exit code and duration."""
+    started = time.time()
+    token_env = {}
+    if host_sync_enabled(config):
+        token_env = sync_to_container(container_name, config, container_workdir, ["claude"])
+    secret_env_names = inject_secrets(container_name, config)
+    wait_for_ready(container_name, config, container_mount_root)
+    run_on_create(container_name, config, container_mount_root, secret_env_names)
+    command = wrap_with_secret_env(["claude", "-p"] + claude_args, secret_env_names)
+    record_activity(container_name)
+    exec_cmd = [
+        "docker", "exec", "-i",
+        "-w", container_workdir,
+    ] + passthrough_env_args(config) + env_args(get_proxy_env(config)) + env_args(config.get("env", {})) + [
+        arg for name in token_env for arg in ("-e", name)
+    ] + [container_name] + command
+    # The prompt goes in on stdin, which keeps long prompts out of argv
+    with open(f"{output_base}.out", "wb") as out, open(f"{output_base}.log", "wb") as log:
+        result = run_command(exec_cmd, input=prompt.encode(), stdout=out, stderr=log, env={**os.environ, **token_env})
+    return {"exit_code": result.returncode, "duration": round(time.time() - started, 3)}
+
+
+def batch_command(argv):
+    """vibecon batch --prompt-file FILE - run Claude Code headless on a list of tasks"""
+    parser = argparse.ArgumentParser(
+        prog="vibecon batch",
+        description="Run 'claude -p' on each task of the prompt files, with the output of each task saved to files. "
+                    "Tasks are separated by lines consisting of ---. One at a time they run in the workspace's container "
+                    "and edit the workspace; with -j N, N run at once, each in its own sandbox container, and their "
+                    "changes are saved as patches instead.",
+        epilog="Arguments after -- are passed to claude, e.g. -- --output-format json --max-turns 20"
+    )
+    parser.add_argument("--prompt-file", action="append", required=True, metavar="FILE", help="file with tasks (repeatable)")
+    parser.add_argument("-j", "--jobs", type=int, default=1, metavar="N", help="tasks to run at once, in sandbox containers (default: 1)")
+    parser.add_argument("-o", "--output", metavar="DIR", help="directory for the results (default: vibecon-batch-{time})")
+    parser.add_argument("-p", "--profile", action="append", default=[], metavar="NAME", help="apply a named profile")
+    parser.add_argument("claude_args", nargs=argparse.REMAINDER, help=argparse.SUPPRESS)
+    args = parser.parse_args(argv)
+    claude_args = args.claude_args[1:] if args.claude_args[:1] == ["--"] else args.claude_args
+
+    tasks = read_batch_tasks(args.prompt_file)
+    if not tasks:
+        fail(None, "The prompt files contain no tasks")
+    vibecon_root = find_vibecon_root()
+    if not vibecon_root:
+        fail(None, "Could not find Dockerfile in vibecon.py directory")
+    project_root, root_config, container_mount_root = find_project_root()
+    profile_names = args.profile or env_profiles()
+    container_name = generate_container_name(project_root, profile_names)
+    config = layer_config(apply_profiles(get_merged_config(root_config, container_mount_root), profile_names), env_overrides())
+    image_name = config.get("image", IMAGE_NAME)
+    container_workdir = get_container_workdir(os.getcwd(), project_root, container_mount_root)
+    output_dir = Path(args.output or f"vibecon-batch-{time.strftime('%Y%m%d-%H%M%S')}")
+    output_dir.mkdir(parents=True, exist_ok=True)
+    set_docker_retry(config)
+    wait_for_docker()
+
+    def run_in_workspace(index, name, prompt):
+        output_base = output_dir / f"{index:02d}-{name}"
+        return run_batch_task(container_name, config, container_mount_root, container_workdir, prompt, claude_args, output_base)
+
+    def run_in_sandbox(index, name, prompt):
+        worker_name = f"{container_name}--batch-{index}"
+        worker_config = {**config, "sandbox": True}
+        output_base = output_dir / f"{index:02d}-{name}"
+        try:
+            ensure_container_running(project_root, vibecon_root, worker_name, image_name, container_mount_root, worker_config)
+            apply_network_policy(worker_name, worker_config)
+            init_sandbox(worker_name, container_mount_root)
+            result = run_batch_task(worker_name, worker_config, container_mount_root, container_workdir, prompt, claude_args, output_base)
+            patch = sandbox_diff(worker_name, container_mount_root)
+            if patch:
+                Path(f"{output_base}.patch").write_bytes(patch)
+            return {**result, "patch": f"{output_base}.patch" if patch else None}
+        finally:
+            remove_container(worker_name)
+            compose_down(worker_name)
+            run_command(["docker", "volume", "rm", worker_name], stdout=subprocess.DEVNULL, stderr=subprocess.DEVNULL)
+
+    if args.jobs > 1:
+        run_task = run_in_sandbox
+    else:
+        run_task = run_in_workspace
+        ensure_container_running(project_root, vibecon_root, container_name, image_name, container_mount_root, config)
+        apply_network_policy(container_name, config)
+        sync_workspace(container_name, project_root, container_mount_root, config)
+
+    print(f"Running {len(tasks)} task(s), {max(1, args.jobs)} at a time, results in {output_dir}/")
+    results = []
+    with concurrent.futures.ThreadPoolExecutor(max_workers=max(1, args.jobs)) as executor:
+        futures = {}
+        for index, (name, prompt) in enumerate(tasks, 1):
+            futures[executor.submit(run_task, index, name, prompt)] = (index, name)
+        for future in concurrent.futures.as_completed(futures):
+            index, name = futures[future]
+            result = {"task": index, "name": name, "output": str(output_dir / f"{index:02d}-{name}.out"), **future.result()}
+            results.append(result)
+            status = style("ok", "green") if result["exit_code"] == 0 else style(f"failed ({result['exit_code']})", "red")
+            print(f"[{index}/{len(tasks)}] {name}: {status}")
+    if args.jobs <= 1:
+        sync_workspace(container_name, project_root, container_mount_root, config)
+
+    results.sort(key=lambda result: result["task"])
+    (output_dir / "summary.json").write_text(json.dumps(results, indent=2) + "\n")
+    failed = [result for result in results if result["exit_code"] != 0]
+    if failed:
+        print(f"{len(failed)} of {len(tasks)} task(s) failed; see the .log files in {output_dir}/")
+        return 1
+    success(f"All {len(tasks)} task(s) done")
+    if any(result.get("patch") for result in results):
+        print(f"Apply a task's changes with 'git apply {output_dir}/NN-name.patch'")
+    return 0
+
+
+# vibecon's own subcommands; use "vibecon -- <name>" to run a same-named command in the container
+SUBCOMMANDS = {
+    "config": config_command,
+    "init": init_command,
+    "secret": secret_command,
+    "sync": sync_command,
+    "sandbox": sandbox_command,
+    "port": port_command,
+    "ui": ui_command,
+    "stats": stats_command,
+    "du": du_command,
+    "volumes": volumes_command,
+    "export": export_command,
+    "import": import_command,
+    "snapshot": snapshot_command,
+    "ssh": ssh_command,
+    "debug-bundle": debug_bundle_command,
+    "exec": exec_command,
+    "attach": attach_command,
+    "code": code_command,
+    "replay": replay_command,
+    "audit": audit_command,
+    "each": each_command,
+    "batch": batch_command,
+    "bench": bench_command,
+    "ci": ci_command,
+    "cp": cp_command,
+    "list": list_command,
+    "prestart": prestart_command,
+    "upgrade": upgrade_command,
+    "stop": stop_command,
+    "destroy": destroy_command,
+}
+
+
+def main(argv=None):
+    """Run vibecon with argv (default: the process arguments)"""
+    argv = list(sys.argv[1:] if argv is None else argv)
+
+    # --no-color and --debug may come before a subcommand too
+    debug = False
+    while argv and argv[0] in ("--no-color", "--debug"):
+        if argv[0] == "--no-color":
+            COLOR["enabled"] = False
+        else:
+            debug = True
+        del argv[0]
+    setup_logging(debug)
+
+    # Dispatch vibecon subcommands before parsing container command arguments
+    if argv and argv[0] in SUBCOMMANDS:
+        sys.exit(SUBCOMMANDS[argv[0]](argv[1:]))
+
     parser = argparse.ArgumentParser(
         description="vibecon - Persistent Docker container environment",
         formatter_class=argparse.RawDescriptionHelpFormatter,
@@ -909,10 +8215,30 @@ Examples:
   %(prog)s gemini             # Run Gemini CLI in container
   %(prog)s codex              # Run OpenAI Codex in container
   %(prog)s -r .               # Initialize .vibecon.json in current dir
+  %(prog)s init -t node       # Write a starter .vibecon.json from a template
   %(prog)s -b                 # Check versions and rebuild if updated
   %(prog)s -B                 # Force rebuild regardless of versions
+  %(prog)s upgrade            # Rebuild if updated and recreate outdated containers
   %(prog)s -k                 # Stop container (can be restarted)
   %(prog)s -K                 # Destroy container permanently
+  %(prog)s -p gpu             # Use the "gpu" profile (separate container)
+  %(prog)s -e DEBUG=1 zsh     # Extra environment variable for this run
+  %(prog)s --mount ~/data:/data --port 8080:8080
+                              # One-off mounts/ports (temporary container)
+  %(prog)s config validate    # Validate config files against the schema
+  %(prog)s config show        # Show merged config and docker run command
+  %(prog)s --sandbox          # Work on a scratch copy, then: vibecon sandbox diff|apply|discard
+  %(prog)s sync --watch       # Push ~/.claude edits into the running container live
+  %(prog)s secret set ANTHROPIC_API_KEY
+                              # Store a key in the OS keychain for all containers
+  %(prog)s exec -- go test -v ./...   # Run a command whose flags vibecon would parse
+  %(prog)s -- config          # Run a command named like a subcommand
+  %(prog)s --record claude    # Record the session, then: vibecon replay
+  %(prog)s audit -g 'rm '     # Commands run in the container (with "audit": true)
+  %(prog)s cp :dist/app.zip . # Copy files out of (or into) the container
+  %(prog)s each -- git pull   # Run a command in every running vibecon container
+  %(prog)s destroy --all --older-than 30d
+                              # Destroy containers idle for a month (asks first)
 """
     )
 
@@ -953,6 +8279,30 @@ Examples:
         help="destroy and remove the container permanently"
     )
 
+    parser.add_argument(
+        "--no-color",
+        action="store_true",
+        help="disable colored output (also: NO_COLOR=1)"
+    )
+
+    parser.add_argument(
+        "--debug",
+        action="store_true",
+        help=f"trace executed commands to stderr and log them to {LOG_FILE}"
+    )
+
+    parser.add_argument(
+        "--keep-volumes",
+        action="store_true",
+        help="with -K, keep the container's named volumes"
+    )
+
+    parser.add_argument(
+        "--recreate",
+        action="store_true",
+        help="recreate the container from the current image before running, keeping its volumes"
+    )
+
     parser.add_argument(
         "-b", "--build",
         action="store_true",
@@ -965,13 +8315,97 @@ Examples:
         help="force rebuild even if image exists"
     )
 
+    parser.add_argument(
+        "-p", "--profile",
+        action="append",
+        default=[],
+        metavar="NAME",
+        help="apply a named profile from the config (repeatable); uses a separate container"
+    )
+
+    parser.add_argument(
+        "--mount",
+        action="append",
+        default=[],
+        metavar="SPEC",
+        help="extra mount for this run: SOURCE:TARGET[:ro] or a JSON mount object (repeatable)"
+    )
+
+    parser.add_argument(
+        "-e", "--env",
+        action="append",
+        default=[],
+        metavar="KEY[=VALUE]",
+        help="set an environment variable for this run (repeatable)"
+    )
+
+    parser.add_argument(
+        "--port",
+        action="append",
+        default=[],
+        metavar="SPEC",
+        help="publish a port for this run, docker -p syntax (repeatable)"
+    )
+
+    parser.add_argument(
+        "-P", "--publish-all",
+        action="store_true",
+        help="publish all exposed ports for this run"
+    )
+
+    parser.add_argument(
+        "--network", "--net",
+        metavar="NAME",
+        help="network for this run, e.g. --net=host to reach dev servers directly (Linux)"
+    )
+
+    parser.add_argument(
+        "--sandbox",
+        action="store_true",
+        help="work on a scratch copy of the workspace; review with 'vibecon sandbox diff/apply'"
+    )
+
+    parser.add_argument(
+        "--record",
+        action="store_true",
+        help="record the session as an asciinema cast (play with vibecon replay)"
+    )
+
+    parser.add_argument(
+        "--tmux",
+        action="store_true",
+        help="run the command in a tmux session that survives closing the terminal"
+    )
+
+    parser.add_argument(
+        "--no-tmux",
+        action="store_true",
+        help="don't use tmux even if the config enables it"
+    )
+
+    parser.add_argument(
+        "--no-sync",
+        action="store_true",
+        help="skip syncing host config (~/.claude, MCP, credentials) into the container for this run"
+    )
+
+    parser.add_argument(
+        "--report",
+        metavar="FILE",
+        help="write a JSON summary of the run (command, times, exit code, container, image, synced files) to FILE, - for stdout"
+    )
+
     parser.add_argument(
         "command",
         nargs="*",
         help="command to execute in container (default: zsh)"
     )
 
-    args = parser.parse_args()
+    args = parser.parse_args(argv)
+    if args.no_color:
+        COLOR["enabled"] = False
+    if args.debug and not debug:
+        setup_logging(debug=True)
 
     # Handle install flag - install symlink and exit
     if args.install:
@@ -997,26 +8431,8 @@ Examples:
     if args.build or args.force_build:
         vibecon_root = find_vibecon_root()
         if not vibecon_root:
-            print("Error: Could not find Dockerfile in vibecon.py directory")
-            sys.exit(1)
-
-        versions = get_all_versions()
-        composite_tag = make_composite_tag(versions)
-        versioned_image = f"vibecon:{composite_tag}"
-
-        if image_exists(versioned_image) and not args.force_build:
-            print(f"\nImage already exists: {versioned_image}")
-            print("No rebuild needed - all versions are up to date.")
-            print("Use -B/--force-build to rebuild anyway.")
-        else:
-            if args.force_build and image_exists(versioned_image):
-                print(f"\nForce rebuild requested...")
-            else:
-                print(f"\nNew versions detected, building image...")
-            build_image(vibecon_root, IMAGE_NAME, versions)
-            print(f"\nBuild complete! Image tagged as:")
-            print(f"  - {IMAGE_NAME}")
-            print(f"  - {versioned_image}")
+            fail(None, "Could not find Dockerfile in vibecon.py directory")
+        build_latest_image(vibecon_root, args.force_build)
         sys.exit(0)
 
     # Find project root - exits with error if no .vibecon.json with 'root' found
@@ -1025,29 +8441,39 @@ Examples:
 
     vibecon_root = find_vibecon_root()
     if not vibecon_root:
-        print("Error: Could not find Dockerfile in vibecon.py directory")
-        sys.exit(1)
+        fail(None, "Could not find Dockerfile in vibecon.py directory")
 
     # Container name is based on project root, not cwd
-    container_name = generate_container_name(project_root)
-
-    # Load config files
-    config = get_merged_config(root_config)
+    profile_names = args.profile or env_profiles()
+    container_name = generate_container_name(project_root, profile_names)
+
+    # Load config files, then layer VIBECON_* environment overrides on top
+    config = apply_profiles(get_merged_config(root_config, container_mount_root), profile_names)
+    config = layer_config(config, env_overrides())
+    image_name = config.get("image", IMAGE_NAME)
+
+    # Flags that change how the container is created need a throwaway container,
+    # removed again after this run. --env alone only affects the exec.
+    overrides = cli_overrides(args)
+    config = layer_config(config, overrides)
+    ephemeral = bool(overrides.keys() - {"env"})
+    if ephemeral:
+        overrides_hash = hashlib.md5(json.dumps(overrides, sort_keys=True).encode()).hexdigest()[:8]
+        container_name += f"--run-{overrides_hash}"
 
     # Calculate working directory inside container
-    # If cwd is nested under project_root, calculate relative path
-    try:
-        rel_path = os.path.relpath(cwd, project_root)
-        if rel_path == ".":
-            container_workdir = container_mount_root
-        elif rel_path.startswith(".."):
-            # cwd is not under project_root, use mount root
-            container_workdir = container_mount_root
-        else:
-            container_workdir = os.path.join(container_mount_root, rel_path)
-    except ValueError:
-        # Different drives on Windows
-        container_workdir = container_mount_root
+    container_workdir = get_container_workdir(cwd, project_root, container_mount_root)
+
+    # Detached tmux sessions would die with the temporary container
+    if ephemeral and args.tmux:
+        fail(None, "--tmux can't be combined with --mount, --port, --publish-all or --network")
+
+    # Sandbox runs use their own container on a copy of the workspace
+    if args.sandbox:
+        if ephemeral:
+            fail(None, "--sandbox can't be combined with --mount, --port, --publish-all or --network")
+        container_name = sandbox_container_name(container_name)
+        config = {**config, "sandbox": True}
 
     # Handle stop flag - stop the container and exit
     if args.stop:
@@ -1056,35 +8482,157 @@ Examples:
 
     # Handle destroy flag - destroy the container and exit
     if args.destroy:
-        destroy_container(container_name)
+        destroy_container(container_name, args.keep_volumes)
+        if args.sandbox:
+            run_command(["docker", "volume", "rm", container_name], stdout=subprocess.DEVNULL, stderr=subprocess.DEVNULL)
         sys.exit(0)
 
+    # Volumes survive, only the container's own filesystem is replaced
+    if args.recreate and container_exists(container_name):
+        print(f"Removing container '{container_name}' to recreate it...")
+        remove_container(container_name)
+
     # Get command to execute (use default if not specified)
-    command = args.command if args.command else DEFAULT_COMMAND
+    command = args.command if args.command else get_default_command(config)
+
+    # Known workspaces are started ahead of time by 'vibecon prestart'
+    if not ephemeral and not args.sandbox:
+        register_workspace(project_root, profile_names)
+
+    # Ride out a Docker daemon that is still starting, then ensure container is running
+    set_docker_retry(config)
+    wait_for_docker()
+    ensure_container_running(project_root, vibecon_root, container_name, image_name, container_mount_root, config)
 
-    # Ensure container is running
-    ensure_container_running(project_root, vibecon_root, container_name, IMAGE_NAME, container_mount_root, config)
+    # Restrict outbound traffic if a network policy is configured
+    apply_network_policy(container_name, config)
 
-    # Sync claude config before exec
-    sync_claude_config(container_name)
+    # sshd doesn't survive container restarts
+    start_ssh_server(container_name, config)
+
+    # Token checks in the container fail when the VM clock drifted
+    check_clock(container_name, config, image_name)
+
+    # Set up the scratch copy of the workspace, or bring the workspace volume
+    # up to date in workspace sync mode
+    if args.sandbox:
+        init_sandbox(container_name, container_mount_root)
+    else:
+        sync_workspace(container_name, project_root, container_mount_root, config)
+
+    # Sync host config into the container; returns short-lived cloud tokens
+    if args.no_sync or not host_sync_enabled(config):
+        token_env = {}
+    else:
+        token_env = sync_to_container(container_name, config, container_workdir, args.command or get_default_command(config))
+
+    # Write secrets into the container and export the as_env ones for the command
+    secret_env_names = inject_secrets(container_name, config)
+
+    # Wait for services the command needs, then run the 'on_create' setup
+    # commands on first use of a new container
+    wait_for_ready(container_name, config, container_mount_root)
+    run_on_create(container_name, config, container_mount_root, secret_env_names)
+    command = wrap_with_secret_env(command, secret_env_names)
+
+    # Keep long agent runs alive in tmux when the terminal goes away
+    tmux = False if args.no_tmux or ephemeral else (args.tmux or config.get("tmux", False))
+    session_name = None
+    if tmux and container_has_command(container_name, "tmux"):
+        session_name = tmux_session_name(tmux, args.command or get_default_command(config))
+        command = wrap_with_tmux(command, session_name, container_workdir)
+        print(f"Running in tmux session '{session_name}' (detach: Ctrl-b d, reattach: vibecon attach)")
+    elif tmux:
+        warn("tmux is not installed in the container image; rebuild it with 'vibecon -B'")
+    command = wrap_with_terminal_size(command)
+    state_path = exec_state_path()
+    command = wrap_with_exec_state(command, state_path)
+
+    # Forward servers the agent starts to the host while the command runs
+    forwarding = start_port_forwarding(container_name, config)
+    started = time.time()
+    record_activity(container_name)
+    if config.get("audit", False):
+        audit_exec(container_name, args.command or get_default_command(config), container_workdir)
 
     # Execute command in container
     host_term = os.environ.get("TERM", "xterm-256color")
-    host_timezone = get_host_timezone()
 
-    exec_result = subprocess.run(
-        [
-            "docker", "exec",
-            "-it",
-            "-w", container_workdir,
-            "-e", f"TERM={host_term}",
-            "-e", "COLORTERM=truecolor",
-            "-e", f"TZ={host_timezone}",
-            container_name
-        ] + command
-    )
+    # Scripts and pipes have no terminal to give the container
+    interactive = sys.stdin.isatty()
+    exec_cmd = [
+        "docker", "exec",
+        "-it" if interactive else "-i",
+    ] + (["--detach-keys", config["detach_keys"]] if interactive and config.get("detach_keys") else []) + [
+        "-w", container_workdir,
+        "-e", f"TERM={host_term}",
+        "-e", "COLORTERM=truecolor",
+        "-e", f"TZ={get_timezone(config)}",
+    ] + env_args(locale_env(config)) + passthrough_env_args(config) + env_args(get_proxy_env(config)) + env_args(display_env(config)) + env_args(config.get("env", {})) + [
+        # Tokens are read from docker's environment to keep them out of argv
+        arg for name in token_env for arg in ("-e", name)
+    ] + [
+        container_name
+    ] + command
+    exec_env = {**os.environ, **token_env}
 
-    sys.exit(exec_result.returncode)
+    try:
+        # Record the session as an asciinema cast if requested
+        if (args.record or config.get("record", False)) and recording_supported():
+            cast_path = new_recording_path(project_root, args.command or get_default_command(config))
+            LOG.info("recording to %s", cast_path)
+            exec_returncode = record_command(exec_cmd, exec_env, cast_path)
+            print(f"Session recorded to {cast_path} (play it with 'vibecon replay')")
+        else:
+            exec_returncode = run_forwarding_signals(exec_cmd, exec_env, container_name, state_path, interactive)
+        if exec_returncode != 0 and exec_connection_lost(exec_returncode):
+            exec_returncode = recover_lost_exec(container_name, state_path, exec_returncode, session_name)
+    finally:
+        clear_exec_state(container_name, state_path)
+
+    if forwarding:
+        forwarding.set()
+    if config.get("audit", False):
+        collect_audit_log(container_name)
+
+    # Keep logins made in this container for the next ones
+    pull_new_credentials(container_name, config)
+
+    # Bring the command's changes back to the host in workspace sync mode
+    if args.sandbox:
+        print("Sandbox changes stay in the container; review with 'vibecon sandbox diff', apply with 'vibecon sandbox apply'")
+    else:
+        sync_workspace(container_name, project_root, container_mount_root, config)
+
+    if args.report:
+        finished = time.time()
+        report = {
+            "command": args.command or get_default_command(config),
+            "workdir": container_workdir,
+            "started": datetime.datetime.fromtimestamp(started, datetime.timezone.utc).isoformat(),
+            "finished": datetime.datetime.fromtimestamp(finished, datetime.timezone.utc).isoformat(),
+            "duration": round(finished - started, 3),
+            "exit_code": exec_returncode,
+            "container": container_name,
+            "profiles": profile_names,
+            **container_image_info(container_name),
+            "synced_files": sorted(set(SYNCED_FILES)),
+        }
+
+    if ephemeral:
+        print(f"Removing temporary container '{container_name}'...")
+        remove_container(container_name)
+        compose_down(container_name)
+
+    if args.report:
+        write_report(args.report, report)
+    sys.exit(exec_returncode)
 
 if __name__ == "__main__":
-    main()
+    try:
+        main()
+    except FileNotFoundError as e:
+        # Every docker call goes through subprocess, which raises this without the CLI
+        if e.filename != "docker":
+            raise
+        fail("docker-not-found", "The docker CLI was not found")